@@ -32,8 +32,8 @@
     }
 
     // support DELETEing resources via data-method="DELETE"
-    function supportDeleteLinks() {
-        var deleteLinks = document.querySelectorAll("a[data-method=DELETE]");
+    function supportDeleteLinks(root) {
+        var deleteLinks = (root || document).querySelectorAll("a[data-method=DELETE]");
         for (var i = 0; i < deleteLinks.length; i++) {
             (function(deleteLink) {
                 deleteLink.addEventListener("click", function(ev) {
@@ -83,8 +83,8 @@
     }
 
     // open editor on doubleclick
-    function editorOnDoubleClick() {
-        var posts = document.querySelectorAll(".post");
+    function editorOnDoubleClick(root) {
+        var posts = (root || document).querySelectorAll(".post");
         for (var i = 0; i < posts.length; i++) {
             (function(post) {
                 post.addEventListener('dblclick', function(ev) {
@@ -163,10 +163,87 @@
         });
     }
 
+    // the element for a post rendered by the server, ready to be inserted
+    function postElement(html) {
+        var container = document.createElement("div");
+        container.innerHTML = html;
+        var article = container.querySelector("article.post");
+
+        supportDeleteLinks(article);
+        editorOnDoubleClick(container);
+        if (window.hljs) {
+            var codeBlocks = article.querySelectorAll("pre code");
+            for (var i = 0; i < codeBlocks.length; i++) {
+                hljs.highlightBlock(codeBlocks[i]);
+            }
+        }
+        if (window.MathJax) {
+            MathJax.Hub.Queue(["Typeset", MathJax.Hub, article]);
+        }
+        return article;
+    }
+
+    // show posts as others create, edit and delete them
+    function liveUpdates() {
+        var posts = document.getElementById("posts");
+        if (posts == null || !window.EventSource) { return; }
+
+        // other pages only show some posts, new ones might not belong there
+        var showNew = location.pathname == "/" && location.search == "";
+
+        // the browser reconnects on its own, sending the id of the last
+        // event it has seen as Last-Event-ID
+        var events = new EventSource("/events");
+
+        function postFor(id) {
+            return document.getElementById("post-" + id);
+        }
+
+        events.addEventListener("created", function(ev) {
+            var data = JSON.parse(ev.data);
+            if (!showNew || postFor(data.id) != null) { return; }
+
+            posts.insertBefore(document.createElement("hr"), posts.firstChild);
+            posts.insertBefore(postElement(data.html), posts.firstChild);
+        });
+
+        events.addEventListener("updated", function(ev) {
+            var data = JSON.parse(ev.data);
+            var old = postFor(data.id);
+            if (old == null) { return; }
+
+            old.parentNode.replaceChild(postElement(data.html), old);
+        });
+
+        events.addEventListener("deleted", function(ev) {
+            var data = JSON.parse(ev.data);
+            var old = postFor(data.id);
+            if (old == null) { return; }
+
+            var separator = old.nextElementSibling;
+            if (separator != null && separator.tagName == "HR") {
+                separator.parentNode.removeChild(separator);
+            }
+            old.parentNode.removeChild(old);
+        });
+
+        // too much was missed while disconnected
+        events.addEventListener("reload", function(ev) {
+            location.reload();
+        });
+
+        events.onerror = function(ev) {
+            if (events.readyState == EventSource.CLOSED) {
+                log("not receiving live updates anymore");
+            }
+        };
+    }
+
     tabOverride();
     supportDeleteLinks();
     renderPreview();
     editorOnDoubleClick();
     setupFullscreenMode();
     savePostShortcut();
+    liveUpdates();
 })();
@@ -0,0 +1,394 @@
+// snapshots of all posts of a storage, to restore them later
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"../post"
+	"../storage"
+)
+
+// an archive is a .tar.gz with `manifest.json` first and then all posts in
+// `posts.json`, a json array.  archives are named after the time they
+// were created, e.g. `gol-20150914T100000.000Z.tar.gz`.
+const (
+	prefix       = "gol-"
+	suffix       = ".tar.gz"
+	timeFormat   = "20060102T150405.000Z"
+	version      = 1
+	postsFile    = "posts.json"
+	manifestFile = "manifest.json"
+)
+
+// what an archive contains
+type Manifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// the kind of storage the posts come from, e.g. `sqlite`
+	Storage string `json:"storage"`
+	// whether they were read from a copy the storage made of itself (see
+	// `storage.Snapshotter`)
+	Snapshot bool `json:"snapshot"`
+	Posts    int  `json:"posts"`
+	// the sha256 of `posts.json`
+	PostsSha256 string `json:"posts_sha256"`
+	// the sha256 of the posts themselves, independent of their order and
+	// encoding (see `ContentHash`), to verify a restore
+	ContentSha256 string `json:"content_sha256"`
+}
+
+// an archive in a backup directory
+type Info struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Manifest
+}
+
+// the hash of `posts` which a store has after restoring them.  times are
+// compared in seconds, not all storages keep more.
+func ContentHash(posts []post.Post) string {
+	hashes := make([]string, 0, len(posts))
+	for _, p := range posts {
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%s\x00%s\x00%d\x00%d\x00%s",
+			p.Id, p.Title, p.Content, p.Slug, strings.Join(p.OldSlugs, "\x01"),
+			p.Created.Unix(), p.Updated.Unix(), p.UpdatedBy)
+		hashes = append(hashes, hex.EncodeToString(hash.Sum(nil)))
+	}
+	sort.Strings(hashes)
+
+	hash := sha256.New()
+	for _, h := range hashes {
+		fmt.Fprintln(hash, h)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// reads all posts of `store` from a consistent copy if it can make one,
+// otherwise one at a time
+func readPosts(ctx context.Context, store storage.Store) (posts []post.Post, snapshot bool, err error) {
+	q, err := storage.Query().Build()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if snapshotter, ok := store.(storage.Snapshotter); ok {
+		dir, err := ioutil.TempDir("", "gol_backup")
+		if err != nil {
+			return nil, false, err
+		}
+		defer os.RemoveAll(dir)
+
+		snapshotUrl, err := snapshotter.Snapshot(ctx, dir)
+		if err != nil {
+			return nil, false, err
+		}
+		copied, err := storage.Open(snapshotUrl)
+		if err != nil {
+			return nil, false, err
+		}
+		defer copied.Close()
+		store = copied
+		snapshot = true
+	}
+
+	posts = []post.Post{}
+	err = storage.Each(ctx, store, *q, func(p post.Post) error {
+		posts = append(posts, p)
+		return nil
+	})
+	return posts, snapshot, err
+}
+
+// writes an archive of all posts of `store` to `dir`, which `kind` (e.g.
+// `sqlite`) is recorded for
+func Create(ctx context.Context, store storage.Store, kind, dir string) (*Info, error) {
+	posts, snapshot, err := readPosts(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	postsJson, err := json.MarshalIndent(posts, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	postsHash := sha256.Sum256(postsJson)
+
+	created := time.Now().UTC()
+	manifest := Manifest{
+		Version:       version,
+		Created:       created,
+		Storage:       kind,
+		Snapshot:      snapshot,
+		Posts:         len(posts),
+		PostsSha256:   hex.EncodeToString(postsHash[:]),
+		ContentSha256: ContentHash(posts),
+	}
+	manifestJson, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, prefix+created.Format(timeFormat)+suffix)
+	if _, err := os.Stat(path); err == nil {
+		return nil, errors.New(fmt.Sprintf("%s already exists", path))
+	}
+	size, err := writeArchive(path, created, map[string][]byte{
+		manifestFile: manifestJson,
+		postsFile:    postsJson,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Info{path, size, manifest}, nil
+}
+
+// writes to a temporary file first, so that there are no half-written
+// archives.  returns the size of the archive.
+func writeArchive(path string, created time.Time, files map[string][]byte) (int64, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	zw := gzip.NewWriter(tmp)
+	tw := tar.NewWriter(zw)
+	// the manifest first, so that listing archives doesn't read the posts
+	for _, name := range []string{manifestFile, postsFile} {
+		header := &tar.Header{
+			Name:    name,
+			Mode:    0600,
+			Size:    int64(len(files[name])),
+			ModTime: created,
+		}
+		err = tw.WriteHeader(header)
+		if err == nil {
+			_, err = tw.Write(files[name])
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return 0, err
+	}
+	if err = zw.Close(); err != nil {
+		return 0, err
+	}
+	if err = tmp.Sync(); err != nil {
+		return 0, err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err = tmp.Close(); err != nil {
+		return 0, err
+	}
+	return info.Size(), os.Rename(tmp.Name(), path)
+}
+
+// the archives in `dir`, the newest first
+func List(dir string) ([]Info, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, prefix+"*"+suffix))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]Info, 0, len(paths))
+	for _, path := range paths {
+		manifest, _, err := readArchive(path, false)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %s", path, err))
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, Info{path, stat.Size(), *manifest})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created.After(infos[j].Created)
+	})
+	return infos, nil
+}
+
+// removes the archives in `dir` beyond the newest `keep` ones and those
+// older than `maxAge`, 0 means no limit.  the newest archive is always
+// kept.  returns the removed archives.
+func Prune(dir string, keep int, maxAge time.Duration) ([]Info, error) {
+	infos, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []Info{}
+	for i, info := range infos {
+		tooMany := keep > 0 && i >= keep
+		tooOld := maxAge > 0 && time.Since(info.Created) > maxAge
+		if i == 0 || !(tooMany || tooOld) {
+			continue
+		}
+		err = os.Remove(info.Path)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, info)
+	}
+	return removed, nil
+}
+
+// the manifest and, if `withPosts`, the posts of the archive at `path`,
+// checked against the manifest
+func readArchive(path string, withPosts bool) (*Manifest, []post.Post, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	tr := tar.NewReader(zr)
+
+	var manifest *Manifest
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch header.Name {
+		case manifestFile:
+			manifest = &Manifest{}
+			err = json.NewDecoder(tr).Decode(manifest)
+			if err != nil {
+				return nil, nil, errors.New(fmt.Sprintf("invalid manifest: %s", err))
+			}
+			if manifest.Version != version {
+				return nil, nil, errors.New(fmt.Sprintf("unsupported archive version %d", manifest.Version))
+			}
+			if !withPosts {
+				return manifest, nil, nil
+			}
+		case postsFile:
+			if manifest == nil {
+				return nil, nil, errors.New("the manifest is missing")
+			}
+			posts, err := readPostsFile(tr, *manifest)
+			return manifest, posts, err
+		}
+	}
+
+	if manifest == nil {
+		return nil, nil, errors.New("the manifest is missing")
+	}
+	return nil, nil, errors.New(fmt.Sprintf("%s is missing", postsFile))
+}
+
+func readPostsFile(r io.Reader, manifest Manifest) ([]post.Post, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+	if hex.EncodeToString(hash[:]) != manifest.PostsSha256 {
+		return nil, errors.New(fmt.Sprintf("the checksum of %s does not match the manifest, the archive is damaged", postsFile))
+	}
+
+	var posts []post.Post
+	err = json.Unmarshal(data, &posts)
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) != manifest.Posts || ContentHash(posts) != manifest.ContentSha256 {
+		return nil, errors.New("the posts do not match the manifest, the archive is damaged")
+	}
+	return posts, nil
+}
+
+// checks the archive at `path` against its manifest
+func Verify(path string) (*Manifest, error) {
+	manifest, _, err := readArchive(path, true)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", path, err))
+	}
+	return manifest, nil
+}
+
+// replaces all posts of `store` with the ones in the archive at `path`.
+// the archive is verified before anything is changed, and the store
+// afterwards.  the posts are replaced in one batch, atomically if the
+// store supports that (see `storage.Batch`).
+func Restore(ctx context.Context, store storage.Store, path string) (*Manifest, error) {
+	manifest, posts, err := readArchive(path, true)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", path, err))
+	}
+
+	q, err := storage.Query().Build()
+	if err != nil {
+		return nil, err
+	}
+	var ops []storage.Op
+	err = storage.Each(ctx, store, *q, func(p post.Post) error {
+		ops = append(ops, storage.Op{Op: storage.OpDelete, Id: p.Id})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range posts {
+		ops = append(ops, storage.Op{Op: storage.OpCreate, Post: &posts[i]})
+	}
+
+	if len(ops) > 0 {
+		err = storage.Batch(ctx, store, ops)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	restored, err := storage.WithContext(store).FindAllContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ContentHash(restored) != manifest.ContentSha256 {
+		return nil, errors.New("the restored posts differ from the archive")
+	}
+	return manifest, nil
+}
+
+// the kind of storage, without credentials or keys
+func Kind(storageUrl string) string {
+	u, err := url.Parse(storageUrl)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme
+}
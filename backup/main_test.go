@@ -0,0 +1,194 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"../post"
+	"../storage"
+	"../storage/memory"
+	_ "../storage/sqlite"
+	tu "../util/testing"
+)
+
+func examplePosts() []post.Post {
+	day := time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)
+	return []post.Post{
+		{Id: "1", Title: "the server is down", Content: "since 10:00", Created: day, Slug: "the-server-is-down", OldSlugs: []string{"server-down"}},
+		{Id: "2", Title: "it is back", Content: "the disk was full", Created: day.Add(time.Hour), Updated: day.Add(2 * time.Hour), UpdatedBy: "alice"},
+		{Id: "3", Title: "a quiet day", Content: "nothing happened", Created: day.AddDate(0, 0, 1)},
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gol_backup_test")
+	tu.RequireNil(t, err)
+	return dir
+}
+
+// an archive of `posts` as if it was created at `created`
+func writeTestArchive(t *testing.T, dir string, created time.Time, manifest Manifest, posts []post.Post) string {
+	postsJson, err := json.Marshal(posts)
+	tu.RequireNil(t, err)
+	manifest.Version = version
+	manifest.Created = created
+	manifestJson, err := json.Marshal(manifest)
+	tu.RequireNil(t, err)
+
+	p := path.Join(dir, prefix+created.Format(timeFormat)+suffix)
+	_, err = writeArchive(p, created, map[string][]byte{
+		manifestFile: manifestJson,
+		postsFile:    postsJson,
+	})
+	tu.RequireNil(t, err)
+	return p
+}
+
+func TestCreateAndList(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	store := memory.FromPosts(examplePosts())
+
+	info, err := Create(context.Background(), store, "memory", dir)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, info.Posts, 3)
+	tu.ExpectEqual(t, info.Storage, "memory")
+	tu.ExpectEqual(t, info.Snapshot, false)
+	tu.ExpectEqual(t, info.ContentSha256, ContentHash(examplePosts()))
+	manifest, err := Verify(info.Path)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, *manifest, info.Manifest)
+
+	infos, err := List(dir)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(infos), 1)
+	tu.ExpectEqual(t, infos[0].Path, info.Path)
+	tu.ExpectEqual(t, infos[0].Size, info.Size)
+	tu.ExpectEqual(t, infos[0].Posts, 3)
+
+	// no leftovers
+	files, err := ioutil.ReadDir(dir)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(files), 1)
+}
+
+func TestCreateFromSnapshot(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	store, err := storage.Open("sqlite://" + path.Join(dir, "gol.db"))
+	tu.RequireNil(t, err)
+	defer store.Close()
+	for _, p := range examplePosts() {
+		tu.RequireNil(t, store.Create(p))
+	}
+
+	info, err := Create(context.Background(), store, "sqlite", path.Join(dir, "backups"))
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, info.Snapshot, true)
+	tu.ExpectEqual(t, info.Posts, 3)
+
+	restored := &memory.Store{}
+	_, err = Restore(context.Background(), restored, info.Path)
+	tu.RequireNil(t, err)
+	p, err := restored.FindById("2")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, p.UpdatedBy, "alice")
+}
+
+func TestRestore(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	info, err := Create(context.Background(), memory.FromPosts(examplePosts()), "memory", dir)
+	tu.RequireNil(t, err)
+
+	store := memory.FromPosts([]post.Post{
+		{Id: "1", Title: "changed since", Created: examplePosts()[0].Created},
+		{Id: "4", Title: "written since"},
+	})
+	manifest, err := Restore(context.Background(), store, info.Path)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, manifest.Posts, 3)
+
+	posts, err := store.FindAll()
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(posts), 3)
+	tu.ExpectEqual(t, ContentHash(posts), ContentHash(examplePosts()))
+	_, err = store.FindById("4")
+	tu.ExpectNotNil(t, err)
+}
+
+func TestRestoreRefusesDamagedArchives(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	created := time.Now().UTC()
+	manifest := Manifest{Posts: 3, ContentSha256: ContentHash(examplePosts())}
+
+	changed := examplePosts()
+	changed[0].Content = "everything is fine"
+	manifest.PostsSha256 = "0000"
+	damaged := writeTestArchive(t, dir, created, manifest, changed)
+
+	// the checksum of posts.json matches, but not the one of the posts
+	postsJson, _ := json.Marshal(changed)
+	hash := sha256.Sum256(postsJson)
+	manifest.PostsSha256 = hex.EncodeToString(hash[:])
+	tampered := writeTestArchive(t, dir, created.Add(time.Second), manifest, changed)
+
+	truncated := path.Join(dir, prefix+"truncated"+suffix)
+	data, err := ioutil.ReadFile(tampered)
+	tu.RequireNil(t, err)
+	tu.RequireNil(t, ioutil.WriteFile(truncated, data[:len(data)/2], 0600))
+
+	for _, archive := range []string{damaged, tampered, truncated, path.Join(dir, "missing"+suffix)} {
+		_, err := Verify(archive)
+		tu.ExpectNotNil(t, err)
+
+		store := memory.FromPosts(examplePosts()[:1])
+		_, err = Restore(context.Background(), store, archive)
+		tu.ExpectNotNil(t, err)
+
+		// nothing changed
+		posts, _ := store.FindAll()
+		tu.ExpectEqual(t, posts, examplePosts()[:1])
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	now := time.Now().UTC()
+	var paths []string
+	for _, age := range []time.Duration{0, time.Hour, 2 * time.Hour, 48 * time.Hour} {
+		paths = append(paths, writeTestArchive(t, dir, now.Add(-age), Manifest{}, nil))
+	}
+
+	removed, err := Prune(dir, 0, 0)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(removed), 0)
+
+	removed, err = Prune(dir, 0, 24*time.Hour)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(removed), 1)
+	tu.ExpectEqual(t, removed[0].Path, paths[3])
+
+	removed, err = Prune(dir, 2, 0)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(removed), 1)
+	tu.ExpectEqual(t, removed[0].Path, paths[2])
+
+	// the newest one is kept in any case
+	removed, err = Prune(dir, 0, time.Nanosecond)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(removed), 1)
+	infos, err := List(dir)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(infos), 1)
+	tu.ExpectEqual(t, infos[0].Path, paths[0])
+}
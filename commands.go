@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ogier/pflag"
+
+	"./backup"
+	"./storage"
+	"./storage/encrypted"
+	"./storage/multi"
+)
+
+// subcommands, run as `gol <command> <args...>`
+var commands = map[string]func(args []string) error{
+	"backup":    backupCommand,
+	"db":        dbCommand,
+	"encrypted": encryptedCommand,
+	"multi":     multiCommand,
+}
+
+// runs the subcommand given on the command line, if there is one
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		return false
+	}
+
+	err := command(args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// gol db migrate [--status] [--storage sqlite://posts.db]
+func dbCommand(args []string) error {
+	if len(args) == 0 || args[0] != "migrate" {
+		return errors.New("usage: gol db migrate [--status] [--storage <url>]")
+	}
+
+	flags := pflag.NewFlagSet("db migrate", pflag.ExitOnError)
+	status := flags.Bool("status", false, "only show which migrations have been applied")
+	storageFlag := flags.String("storage", *storageUrl, "the storage to migrate")
+	flags.Parse(args[1:])
+
+	// don't migrate on open, so that the status is the one before
+	u, err := url.Parse(*storageFlag)
+	if err != nil {
+		return err
+	}
+	params := u.Query()
+	params.Set("migrate", "false")
+	u.RawQuery = params.Encode()
+
+	store, err := storage.Open(u.String())
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	migrator, ok := store.(storage.Migrator)
+	if !ok {
+		return errors.New(fmt.Sprintf("%s storage has no schema migrations", u.Scheme))
+	}
+
+	if !*status {
+		err = migrator.Migrate()
+		if err != nil {
+			return err
+		}
+	}
+
+	statuses, err := migrator.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "version\tapplied\tname")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.Applied {
+			applied = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, applied, s.Name)
+	}
+	return w.Flush()
+}
+
+// gol multi verify|repair [--storage primary,secondary...] [--replication-queue dir]
+func multiCommand(args []string) error {
+	usage := errors.New("usage: gol multi verify|repair [--storage <primary url>,<secondary url>...] [--replication-queue <dir>]")
+	if len(args) == 0 || (args[0] != "verify" && args[0] != "repair") {
+		return usage
+	}
+
+	flags := pflag.NewFlagSet("multi "+args[0], pflag.ExitOnError)
+	storageFlag := flags.String("storage", *storageUrl, "the storages to compare, the primary first")
+	queueFlag := flags.String("replication-queue", *replicationQueue, "repairing clears the changes given up on there")
+	flags.Parse(args[1:])
+
+	urls := strings.Split(*storageFlag, ",")
+	if len(urls) < 2 {
+		return usage
+	}
+
+	// opened one by one, so that nothing is replicated meanwhile
+	stores := make([]storage.Store, 0, len(urls))
+	defer func() {
+		for _, store := range stores {
+			store.Close()
+		}
+	}()
+	for _, u := range urls {
+		store, err := storage.Open(u)
+		if err != nil {
+			return err
+		}
+		stores = append(stores, store)
+	}
+
+	total := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "secondary\tid\tdifference")
+	for i, secondary := range stores[1:] {
+		var differences []multi.Difference
+		var err error
+		if args[0] == "repair" {
+			// the ones given up on later are not repaired yet
+			dead := 0
+			if *queueFlag != "" {
+				dead, err = multi.DeadLetters(*queueFlag, urls[i+1])
+				if err != nil {
+					return err
+				}
+			}
+			differences, err = multi.Repair(stores[0], secondary)
+			if err == nil && dead > 0 {
+				err = multi.ClearDeadLetters(*queueFlag, urls[i+1], dead)
+			}
+		} else {
+			differences, err = multi.Verify(stores[0], secondary)
+		}
+		if err != nil {
+			return err
+		}
+
+		// without secrets
+		secondaryUrl := storage.Redact(urls[i+1])
+		for _, d := range differences {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", secondaryUrl, d.Id, d.Kind)
+		}
+		total += len(differences)
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "repair" {
+		fmt.Printf("repaired %d differences\n", total)
+	} else if total > 0 {
+		return errors.New(fmt.Sprintf("found %d differences, run `gol multi repair` to fix them", total))
+	}
+	return nil
+}
+
+// gol encrypted reencrypt [--storage encrypted://?key=<new>&key=<old>&backend=...]
+func encryptedCommand(args []string) error {
+	if len(args) == 0 || args[0] != "reencrypt" {
+		return errors.New("usage: gol encrypted reencrypt [--storage <url>]")
+	}
+
+	flags := pflag.NewFlagSet("encrypted reencrypt", pflag.ExitOnError)
+	storageFlag := flags.String("storage", *storageUrl, "the encrypted storage, with the new key first")
+	flags.Parse(args[1:])
+
+	store, err := storage.Open(*storageFlag)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	encryptedStore, ok := store.(*encrypted.Store)
+	if !ok {
+		return errors.New("the storage is not encrypted, use encrypted://?key=...&backend=<url>")
+	}
+
+	n, err := encryptedStore.Reencrypt(context.Background())
+	fmt.Printf("re-encrypted %d posts\n", n)
+	return err
+}
+
+// gol backup create|list|restore [--storage <url>] [--dir backups]
+func backupCommand(args []string) error {
+	usage := errors.New("usage: gol backup create|list|restore [--storage <url>] [--dir <path>] [<archive>]")
+	if len(args) == 0 {
+		return usage
+	}
+
+	flags := pflag.NewFlagSet("backup "+args[0], pflag.ExitOnError)
+	storageFlag := flags.String("storage", *storageUrl, "the storage to back up or restore to")
+	dir := flags.String("dir", *backupDir, "where the backups are kept")
+	keep := flags.Int("keep", *backupKeep, "how many backups to keep after creating one (0 for all)")
+	maxAge := flags.Duration("max-age", *backupMaxAge, "how long to keep backups (0 for no limit)")
+	noBackup := flags.Bool("no-backup", false, "don't back up the posts before restoring")
+	flags.Parse(args[1:])
+
+	if args[0] == "list" {
+		infos, err := backup.List(*dir)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "archive\tcreated\tstorage\tposts\tsize")
+		for _, info := range infos {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", info.Path, info.Created.Format(time.RFC3339), info.Storage, info.Posts, info.Size)
+		}
+		return w.Flush()
+	}
+
+	if args[0] != "create" && args[0] != "restore" {
+		return usage
+	}
+	if args[0] == "restore" && flags.NArg() != 1 {
+		return errors.New("usage: gol backup restore [--storage <url>] [--dir <path>] [--no-backup] <archive>")
+	}
+
+	if args[0] == "restore" {
+		_, err := backup.Verify(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+	}
+
+	store, err := storage.Open(*storageFlag)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx := context.Background()
+	kind := backup.Kind(*storageFlag)
+
+	// restoring replaces all posts, keep them in case it was the wrong archive
+	if args[0] == "create" || !*noBackup {
+		info, err := backup.Create(ctx, store, kind, *dir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("backed up %d posts to %s\n", info.Posts, info.Path)
+	}
+
+	if args[0] == "create" {
+		removed, err := backup.Prune(*dir, *keep, *maxAge)
+		for _, r := range removed {
+			fmt.Printf("removed old backup %s\n", r.Path)
+		}
+		return err
+	}
+
+	manifest, err := backup.Restore(ctx, store, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %d posts from %s, backed up %s\n", manifest.Posts, flags.Arg(0), manifest.Created.Format(time.RFC3339))
+	return nil
+}
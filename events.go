@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"./storage"
+)
+
+// how often to send a comment to keep idle connections open
+const keepAliveInterval = 30 * time.Second
+
+// the data of an event sent to browsers, `html` is the post rendered with
+// the `post` template
+type postEvent struct {
+	Id   string `json:"id"`
+	Html string `json:"html,omitempty"`
+}
+
+// streams changes to posts as server-sent events.  browsers reconnect
+// with the `Last-Event-ID` header (the sequence number of the last event
+// they have seen) and get the events they have missed first.  if those
+// are not kept anymore, a `reload` event tells them to reload the page.
+func serveEvents(templates *template.Template, store storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		watcher, ok := storage.CanWatch(store)
+		if !ok {
+			http.Error(w, "the storage can not be watched", http.StatusNotImplemented)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		lastId := r.Header.Get("Last-Event-ID")
+		if lastId == "" {
+			lastId = r.URL.Query().Get("last_event_id")
+		}
+
+		var events <-chan storage.Event
+		resetClient := false
+		if lastId != "" {
+			seq, err := strconv.ParseUint(lastId, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+				return
+			}
+			events, err = watcher.WatchFrom(r.Context(), seq)
+			if err == storage.ErrEventsGone {
+				resetClient = true
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if events == nil {
+			events = watcher.Watch(r.Context())
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		// wait a bit before reconnecting
+		fmt.Fprint(w, "retry: 5000\n\n")
+		if resetClient {
+			fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+		}
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					// the client reconnects and resumes
+					return
+				}
+				err := writeEvent(w, templates, e)
+				if err != nil {
+					log.Println("Error: could not send event:", err)
+					return
+				}
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, templates *template.Template, e storage.Event) error {
+	data := postEvent{Id: e.Id}
+	if e.Post != nil {
+		var html bytes.Buffer
+		err := templates.ExecuteTemplate(&html, "post", e.Post)
+		if err != nil {
+			return err
+		}
+		data.Html = html.String()
+	}
+
+	// json has no newlines, so it fits into a single data line
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, encoded)
+	return err
+}
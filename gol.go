@@ -1,12 +1,11 @@
 package main
 
 import (
-	"bytes"
-	"crypto/md5"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/ogier/pflag"
@@ -22,26 +21,25 @@ import (
 	"./auth"
 	_ "./auth/insecure"
 	_ "./auth/ldap"
+	"./backup"
+	"./ids"
 	"./post"
 	"./storage"
+	_ "./storage/bolt"
+	"./storage/cache"
+	_ "./storage/dir"
+	_ "./storage/git"
 	_ "./storage/gol"
 	_ "./storage/json"
 	_ "./storage/memory"
-	_ "./storage/multi"
+	"./storage/multi"
+	_ "./storage/postgres"
+	"./storage/query"
 	_ "./storage/sqlite"
 	"./templates"
+	"./webhooks"
 )
 
-func toByteSlice(data interface{}) []byte {
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.LittleEndian, data)
-	if err != nil {
-		log.Println("Error: binary.Write failed:", err)
-		return []byte{}
-	}
-	return buf.Bytes()
-}
-
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -57,14 +55,19 @@ func renderPosts(templates *template.Template, w http.ResponseWriter, posts []po
 	templates.ExecuteTemplate(w, "posts", m)
 }
 
-func createPost(title, content string) post.Post {
+func createPost(idGenerator ids.Generator, title, content string) (post.Post, error) {
 	now := time.Now()
+	id, err := idGenerator.New(now)
+	if err != nil {
+		return post.Post{}, err
+	}
+
 	return post.Post{
-		Id:      fmt.Sprintf("%x", md5.Sum(toByteSlice(now.UnixNano()))),
+		Id:      id,
 		Title:   title,
 		Content: content,
 		Created: now,
-	}
+	}, nil
 }
 
 func writeJson(w http.ResponseWriter, data interface{}) {
@@ -72,6 +75,24 @@ func writeJson(w http.ResponseWriter, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
+// answers with the status that fits a storage error, e.g. 404 for
+// `storage.ErrNotFound`
+func storageError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, storage.ErrConflict):
+		status = http.StatusConflict
+	case errors.Is(err, storage.ErrInvalidQuery), errors.Is(err, storage.ErrInvalidOp):
+		status = http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		// see `--request-timeout`
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), status)
+}
+
 func notImplemented(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusNotImplemented)
 	w.Write([]byte("not implemented"))
@@ -99,7 +120,7 @@ func urlHasQuery(u *url.URL) bool {
 		return false
 	}
 
-	queryParams := []string{"id", "title", "start", "end", "sort", "reverse", "match", "range"}
+	queryParams := []string{"id", "title", "start", "count", "sort", "reverse", "match", "range", "since", "until", "on"}
 	for _, p := range queryParams {
 		if _, ok := q[p]; ok {
 			return true
@@ -109,17 +130,138 @@ func urlHasQuery(u *url.URL) bool {
 	return false
 }
 
-func queryFromURL(u *url.URL, store storage.Store) ([]post.Post, error) {
-	defaultQuery, _ := storage.Query().Reverse().Build()
+// the query in the url, newest posts first if there is none
+func urlQuery(u *url.URL) (*query.Query, error) {
 	if !urlHasQuery(u) {
-		return store.Find(*defaultQuery)
+		return storage.Query().Reverse().Build()
 	}
+	return storage.QueryFromURL(u)
+}
 
-	q, err := storage.QueryFromURL(u)
+func queryFromURL(ctx context.Context, u *url.URL, store storage.Store) ([]post.Post, error) {
+	q, err := urlQuery(u)
 	if err != nil {
 		return nil, err
 	}
-	return store.Find(*q)
+	return storage.WithContext(store).FindContext(ctx, *q)
+}
+
+// writes the posts as a json array while they are read, instead of reading
+// all of them first.  if reading fails midway the array stays incomplete.
+func writePostsJson(ctx context.Context, w http.ResponseWriter, store storage.Store, q query.Query) {
+	n := 0
+	start := func() {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("["))
+	}
+	enc := json.NewEncoder(w)
+	err := storage.Each(ctx, store, q, func(p post.Post) error {
+		if n == 0 {
+			start()
+		} else {
+			w.Write([]byte(","))
+		}
+		n += 1
+		return enc.Encode(p)
+	})
+	if err != nil {
+		if n == 0 {
+			storageError(w, err)
+		} else {
+			log.Println("Error: could not write all posts:", err)
+		}
+		return
+	}
+
+	if n == 0 {
+		start()
+	}
+	w.Write([]byte("]\n"))
+}
+
+type archiveMonth struct {
+	Key   string // e.g. 2015-09
+	Path  string // e.g. 2015/09
+	Name  string // e.g. September
+	Count int
+}
+
+type archiveYear struct {
+	Year   string
+	Count  int
+	Months []archiveMonth
+}
+
+// groups post counts by month into years, newest first
+func archiveFromCounts(counts []storage.Count) []archiveYear {
+	years := []archiveYear{}
+	for i := len(counts) - 1; i >= 0; i-- {
+		c := counts[i]
+		month, err := time.Parse("2006-01", c.Key)
+		if err != nil {
+			log.Println("Error: invalid month:", c.Key)
+			continue
+		}
+
+		year := month.Format("2006")
+		if len(years) == 0 || years[len(years)-1].Year != year {
+			years = append(years, archiveYear{Year: year})
+		}
+		y := &years[len(years)-1]
+		y.Count += c.Count
+		y.Months = append(y.Months, archiveMonth{c.Key, month.Format("2006/01"), month.Format("January"), c.Count})
+	}
+	return years
+}
+
+// the utc period described by the `year`, `month` and `day` url
+// parameters, e.g. all of september 2015 for /2015/09/
+func archivePeriod(vars map[string]string) (time.Time, time.Time, error) {
+	layout, value := "2006", vars["year"]
+	if vars["month"] != "" {
+		layout, value = layout+"-01", value+"-"+vars["month"]
+	}
+	if vars["day"] != "" {
+		layout, value = layout+"-02", value+"-"+vars["day"]
+	}
+
+	start, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	var end time.Time
+	switch {
+	case vars["day"] != "":
+		end = start.AddDate(0, 0, 1)
+	case vars["month"] != "":
+		end = start.AddDate(0, 1, 0)
+	default:
+		end = start.AddDate(1, 0, 0)
+	}
+	return start, end.Add(-time.Nanosecond), nil
+}
+
+// renders the posts of a year, month or day, newest first
+func renderArchive(templates *template.Template, w http.ResponseWriter, r *http.Request, store storage.Store) {
+	start, end, err := archivePeriod(mux.Vars(r))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	q, err := storage.Query().Range(start, end).Reverse().Build()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	posts, err := storage.WithContext(store).FindContext(r.Context(), *q)
+	if err != nil {
+		storageError(w, err)
+		return
+	}
+
+	renderPosts(templates, w, posts)
 }
 
 func newSession(sessions map[string]string, username string) string {
@@ -142,16 +284,89 @@ func hasSession(sessions map[string]string, sessionId string) (string, bool) {
 	return "", false
 }
 
+// checks the credentials of remote clients, e.g. the `gol://` storage
+var requestAuth auth.Auth
+
+// api token -> username, see `--api-tokens`
+var apiTokens = map[string]string{}
+
+// returns (user, true) if the `Authorization` header has valid credentials
+func hasCredentials(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		username, ok := apiTokens[strings.TrimPrefix(header, "Bearer ")]
+		return username, ok
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok || requestAuth == nil {
+		return "", false
+	}
+	if requestAuth.Login(username, password) != nil {
+		return "", false
+	}
+	return username, true
+}
+
+// the name of the logged in user, if any
+func currentUser(sessions map[string]string, r *http.Request) string {
+	sessionCookie, err := r.Cookie("session")
+	if err == nil {
+		if username, ok := hasSession(sessions, sessionCookie.Value); ok {
+			return username
+		}
+	}
+
+	username, _ := hasCredentials(r)
+	return username
+}
+
 func isLoggedIn(sessions map[string]string, r *http.Request) bool {
 	sessionCookie, err := r.Cookie("session")
-	if err != nil {
-		return false
+	if err == nil {
+		if _, ok := hasSession(sessions, sessionCookie.Value); ok {
+			return true
+		}
 	}
 
-	_, ok := hasSession(sessions, sessionCookie.Value)
+	_, ok := hasCredentials(r)
 	return ok
 }
 
+// reads a json object of api tokens and the users they log in as
+func loadApiTokens(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tokens := map[string]string{}
+	err = json.NewDecoder(f).Decode(&tokens)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid api tokens in %s: %s", path, err))
+	}
+	return tokens, nil
+}
+
+// cancels requests that take longer than `timeout`, along with what the
+// storage is doing for them.  the event stream is meant to stay open.
+func withTimeout(h http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func redirectToLogin(w http.ResponseWriter, r *http.Request) {
 	path := fmt.Sprintf("/login?redirect_to=%s", url.QueryEscape(r.URL.Path))
 	http.Redirect(w, r, path, http.StatusSeeOther)
@@ -182,6 +397,56 @@ func getTemplateBasePath(customLocation string) (string, error) {
 	return basePathFound, nil
 }
 
+// delivers the changes of `store` to the webhooks configured in `path`.
+// pending deliveries are kept in `<path>.queue`, all attempts are logged
+// to `<path>.log`.
+func startWebhooks(path string, store storage.Store) error {
+	watcher, ok := storage.CanWatch(store)
+	if !ok {
+		return errors.New("webhooks need a storage that can be watched")
+	}
+
+	subscriptions, err := webhooks.Load(path)
+	if err != nil {
+		return err
+	}
+	dispatcher, err := webhooks.New(subscriptions, path+".queue", path+".log")
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		err := dispatcher.Run(context.Background(), watcher)
+		if err != nil {
+			log.Println("Error:", err)
+		}
+	}()
+	return nil
+}
+
+// archives the posts of `store` every `interval`, keeping what `keep` and
+// `maxAge` allow (see `backup.Prune`)
+func startBackups(store storage.Store, kind, dir string, interval time.Duration, keep int, maxAge time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			info, err := backup.Create(context.Background(), store, kind, dir)
+			if err != nil {
+				log.Println("Error: backup failed:", err)
+				continue
+			}
+			log.Printf("backed up %d posts to %s", info.Posts, info.Path)
+
+			removed, err := backup.Prune(dir, keep, maxAge)
+			if err != nil {
+				log.Println("Error: could not remove old backups:", err)
+			}
+			for _, r := range removed {
+				log.Printf("removed old backup %s", r.Path)
+			}
+		}
+	}()
+}
+
 var Environment = getEnv("ENVIRONMENT", "development")
 var Version = "master"
 var templateBase = pflag.String("templates",
@@ -199,6 +464,36 @@ var storageUrl = pflag.String("storage",
 var authUrl = pflag.String("authentication",
 	"",
 	"the authentication method to use")
+var webhooksPath = pflag.String("webhooks",
+	"",
+	"a json file with webhooks to notify about changes (see webhooksExample.json)")
+var replicationQueue = pflag.String("replication-queue",
+	"replication-queue",
+	"where to keep changes for secondary storages until they are written")
+var readPolicy = pflag.String("read-policy",
+	multi.ReadPrimary,
+	fmt.Sprintf("where to read from with multiple storages (%s, %s or %s)", multi.ReadPrimary, multi.ReadFailover, multi.ReadFastest))
+var apiTokensPath = pflag.String("api-tokens",
+	"",
+	"a json file of api tokens and the users they log in as, e.g. {\"secret-token\": \"alice\"}")
+var requestTimeout = pflag.Duration("request-timeout",
+	30*time.Second,
+	"how long a request may take before it is cancelled (0 for no limit)")
+var backupDir = pflag.String("backup-dir",
+	"backups",
+	"where to keep backups (see `gol backup`)")
+var backupInterval = pflag.Duration("backup-interval",
+	0,
+	"how often to back up the posts (0 for never)")
+var backupKeep = pflag.Int("backup-keep",
+	7,
+	"how many backups to keep (0 for all)")
+var backupMaxAge = pflag.Duration("backup-max-age",
+	0,
+	"how long to keep backups (0 for no limit), the newest one is always kept")
+var idFormat = pflag.String("ids",
+	"ulid",
+	fmt.Sprintf("how to generate ids for new posts (one of %s)", strings.Join(ids.Names(), ", ")))
 
 func init() {
 	if Environment == "production" {
@@ -208,56 +503,18 @@ func init() {
 	fmt.Printf("gol - %s (%s)\n", Version, Environment)
 }
 
-func main() {
-	pflag.Parse()
-
-	var store storage.Store
-	storageUrls := strings.Split(*storageUrl, ",")
-	if len(storageUrls) > 1 {
-		multiUrl := fmt.Sprintf("multi://?primary=%s", url.QueryEscape(storageUrls[0]))
-		for _, storageUrl := range storageUrls[1:] {
-			multiUrl = fmt.Sprintf("%s&secondary=%s", multiUrl, url.QueryEscape(storageUrl))
-		}
-		var err error
-		store, err = storage.Open(multiUrl)
-		if err != nil {
-			log.Fatal(err)
-		}
-	} else {
-		var err error
-		store, err = storage.Open(*storageUrl)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	var authenticator auth.Auth
-	if authUrl != nil && *authUrl != "" {
-		a, err := auth.Open(*authUrl)
-		if err != nil {
-			log.Fatal(err)
-		}
-		authenticator = a
-	}
-
+// the pages and the api of gol, for the posts in `store`.  without an
+// `authenticator`, anyone may change posts.
+func newRouter(store storage.Store, templates *template.Template, authenticator auth.Auth, idGenerator ids.Generator) *mux.Router {
 	// username -> session
 	sessions := map[string]string{}
 
-	templBasePath, err := getTemplateBasePath(*templateBase)
-	if err != nil || templBasePath == "" {
-		log.Print("Could not get template base path!")
-		log.Fatal(err)
-	}
-
-	fmt.Printf("Using template base path: %s\n", templBasePath)
-	templates := templates.Templates(templBasePath, *assetBase)
-
 	router := mux.NewRouter()
 
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		posts, err := queryFromURL(r.URL, store)
+		posts, err := queryFromURL(r.Context(), r.URL, store)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			storageError(w, err)
 		} else {
 			renderPosts(templates, w, posts)
 		}
@@ -315,20 +572,19 @@ func main() {
 	}
 
 	router.HandleFunc("/posts", func(w http.ResponseWriter, r *http.Request) {
-		posts, err := queryFromURL(r.URL, store)
+		q, err := urlQuery(r.URL)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		} else {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(posts)
+			storageError(w, err)
+			return
 		}
+		writePostsJson(r.Context(), w, store, *q)
 	}).Methods("GET").Headers("Content-Type", "application/json")
 
 	router.HandleFunc("/posts", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" {
-			posts, err := queryFromURL(r.URL, store)
+			posts, err := queryFromURL(r.Context(), r.URL, store)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				storageError(w, err)
 			} else {
 				renderPosts(templates, w, posts)
 			}
@@ -340,17 +596,30 @@ func main() {
 				return
 			}
 
-			var post post.Post
+			var newPost, post post.Post
+			var err error
 			if isJson {
-				json.NewDecoder(r.Body).Decode(&post)
-				post = createPost(post.Title, post.Content)
+				json.NewDecoder(r.Body).Decode(&newPost)
+				post, err = createPost(idGenerator, newPost.Title, newPost.Content)
+				// keep ids of posts that already exist elsewhere, e.g.
+				// when replicating from another instance of gol
+				if newPost.Id != "" {
+					post.Id = newPost.Id
+					if !newPost.Created.IsZero() {
+						post.Created = newPost.Created
+					}
+				}
 			} else {
-				post = createPost(r.FormValue("title"), r.FormValue("content"))
+				post, err = createPost(idGenerator, r.FormValue("title"), r.FormValue("content"))
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 
-			err := store.Create(post)
+			err = storage.CreateWithSlug(r.Context(), storage.As(store, currentUser(sessions, r)), &post)
 			if err != nil {
-				http.Error(w, err.Error(), 400)
+				storageError(w, err)
 				return
 			}
 
@@ -365,6 +634,156 @@ func main() {
 		}
 	})
 
+	router.HandleFunc("/api/v1/stats", func(w http.ResponseWriter, r *http.Request) {
+		by := r.URL.Query().Get("by")
+		if by == "" {
+			by = storage.ByMonth
+		}
+
+		q, err := storage.QueryFromURL(r.URL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		counts, err := storage.AggregateContext(r.Context(), store, by, *q)
+		if err != nil {
+			storageError(w, err)
+			return
+		}
+		writeJson(w, counts)
+	}).Methods("GET")
+
+	// applies many changes at once, all of them or none if the store
+	// supports it (see `storage.Batch`)
+	router.HandleFunc("/api/v1/posts:batch", func(w http.ResponseWriter, r *http.Request) {
+		if authenticator != nil && !isLoggedIn(sessions, r) {
+			redirectToLogin(w, r)
+			return
+		}
+
+		var ops []storage.Op
+		err := json.NewDecoder(r.Body).Decode(&ops)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		user := currentUser(sessions, r)
+		for _, op := range ops {
+			if op.Post == nil {
+				continue
+			}
+			// when and by whom is up to the server, not the request
+			switch op.Op {
+			case storage.OpCreate:
+				op.Post.Updated = time.Time{}
+				op.Post.UpdatedBy = ""
+				if op.Post.Slug == "" {
+					err = storage.AssignSlug(store, op.Post)
+					if err != nil {
+						storageError(w, err)
+						return
+					}
+				}
+			case storage.OpUpdate:
+				op.Post.Updated = time.Now()
+				op.Post.UpdatedBy = user
+			}
+		}
+
+		err = storage.Batch(r.Context(), storage.As(store, user), ops)
+		if err != nil {
+			storageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("POST")
+
+	router.HandleFunc("/events", serveEvents(templates, store)).Methods("GET")
+
+	router.HandleFunc("/api/v1/replication", func(w http.ResponseWriter, r *http.Request) {
+		// the urls and the state of the storages are not public
+		if authenticator != nil && !isLoggedIn(sessions, r) {
+			redirectToLogin(w, r)
+			return
+		}
+
+		m, ok := store.(*multi.Store)
+		if !ok {
+			http.Error(w, "there are no secondary storages", http.StatusNotFound)
+			return
+		}
+		writeJson(w, m.Status())
+	}).Methods("GET")
+
+	router.HandleFunc("/api/v1/cache", func(w http.ResponseWriter, r *http.Request) {
+		if authenticator != nil && !isLoggedIn(sessions, r) {
+			redirectToLogin(w, r)
+			return
+		}
+
+		c, ok := store.(*cache.Store)
+		if !ok {
+			http.Error(w, "the storage is not cached", http.StatusNotFound)
+			return
+		}
+		writeJson(w, c.Stats())
+	}).Methods("GET")
+
+	router.HandleFunc("/archive", func(w http.ResponseWriter, r *http.Request) {
+		q, _ := storage.Query().Build()
+		counts, err := storage.AggregateContext(r.Context(), store, storage.ByMonth, *q)
+		if err != nil {
+			storageError(w, err)
+			return
+		}
+
+		m := make(map[string]interface{})
+		m["title"] = "Archive"
+		m["years"] = archiveFromCounts(counts)
+		templates.ExecuteTemplate(w, "archive", m)
+	}).Methods("GET")
+
+	router.HandleFunc("/{year:[0-9]{4}}/", func(w http.ResponseWriter, r *http.Request) {
+		renderArchive(templates, w, r, store)
+	}).Methods("GET")
+
+	router.HandleFunc("/{year:[0-9]{4}}/{month:[0-9]{2}}/", func(w http.ResponseWriter, r *http.Request) {
+		renderArchive(templates, w, r, store)
+	}).Methods("GET")
+
+	router.HandleFunc("/{year:[0-9]{4}}/{month:[0-9]{2}}/{day:[0-9]{2}}/", func(w http.ResponseWriter, r *http.Request) {
+		renderArchive(templates, w, r, store)
+	}).Methods("GET")
+
+	router.HandleFunc("/{year:[0-9]{4}}/{month:[0-9]{2}}/{day:[0-9]{2}}/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		day, _, err := archivePeriod(mux.Vars(r))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		p, isOld, err := storage.FindBySlug(store, day, mux.Vars(r)["slug"])
+		if err != nil {
+			storageError(w, err)
+			return
+		} else if p == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if isOld {
+			http.Redirect(w, r, p.Permalink(), http.StatusMovedPermanently)
+			return
+		}
+
+		m := make(map[string]interface{})
+		m["title"] = p.Title
+		m["posts"] = []post.Post{*p}
+		templates.ExecuteTemplate(w, "posts", m)
+	}).Methods("GET")
+
 	router.HandleFunc("/posts/new", func(w http.ResponseWriter, r *http.Request) {
 		if authenticator != nil && !isLoggedIn(sessions, r) {
 			redirectToLogin(w, r)
@@ -390,9 +809,9 @@ func main() {
 
 	router.HandleFunc("/posts/{id}", func(w http.ResponseWriter, r *http.Request) {
 		id := mux.Vars(r)["id"]
-		p, _ := store.FindById(id)
-		if p == nil {
-			http.Error(w, "post not found", http.StatusNotFound)
+		p, err := storage.WithContext(store).FindByIdContext(r.Context(), id)
+		if err != nil {
+			storageError(w, err)
 			return
 		}
 
@@ -406,7 +825,7 @@ func main() {
 				templates.ExecuteTemplate(w, "posts", m)
 			}
 		} else if r.Method == "HEAD" {
-			// already handle by p == nil above
+			// already handled by the error above
 		} else if r.Method == "POST" {
 			if authenticator != nil && !isLoggedIn(sessions, r) {
 				redirectToLogin(w, r)
@@ -414,43 +833,95 @@ func main() {
 			}
 
 			var newPost post.Post
-			if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+			isForm := r.Header.Get("Content-Type") == "application/x-www-form-urlencoded"
+			if isForm {
 				newPost.Title = r.FormValue("title")
 				newPost.Content = r.FormValue("content")
-
-				http.Redirect(w, r, "/", http.StatusSeeOther)
 			} else { // assume it's JSON
 				err := json.NewDecoder(r.Body).Decode(&newPost)
 				if err != nil {
 					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
 				}
-				w.WriteHeader(http.StatusAccepted)
-				writeJson(w, newPost)
 			}
 
-			if newPost.Title != "" {
-				p.Title = newPost.Title
+			if newPost.Title != "" && newPost.Title != p.Title {
+				err := storage.Retitle(store, p, newPost.Title)
+				if err != nil {
+					storageError(w, err)
+					return
+				}
 			}
 			if newPost.Content != "" {
 				p.Content = newPost.Content
 			}
-			store.Update(*p)
-			json.NewEncoder(w).Encode(p)
+			// when and by whom is up to the server, not the request
+			p.Updated = time.Now()
+			p.UpdatedBy = currentUser(sessions, r)
+			err := storage.WithContext(storage.As(store, p.UpdatedBy)).UpdateContext(r.Context(), *p)
+			if err != nil {
+				storageError(w, err)
+				return
+			}
+
+			if isForm {
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+			} else {
+				w.WriteHeader(http.StatusAccepted)
+				writeJson(w, p)
+			}
 		} else if r.Method == "DELETE" {
 			if authenticator != nil && !isLoggedIn(sessions, r) {
 				redirectToLogin(w, r)
 				return
 			}
 
-			err := store.Delete(id)
+			err := storage.WithContext(storage.As(store, currentUser(sessions, r))).DeleteContext(r.Context(), id)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusNotFound)
+				storageError(w, err)
 			}
 		} else {
 			notImplemented(w)
 		}
 	})
 
+	router.HandleFunc("/posts/{id}/history", func(w http.ResponseWriter, r *http.Request) {
+		historian, ok := store.(storage.Historian)
+		if !ok {
+			http.Error(w, "the storage keeps no history", http.StatusNotImplemented)
+			return
+		}
+
+		// deleted posts have a history as well
+		revisions, err := historian.History(mux.Vars(r)["id"])
+		if err != nil {
+			storageError(w, err)
+			return
+		}
+		writeJson(w, revisions)
+	}).Methods("GET")
+
+	router.HandleFunc("/posts/{id}/blame", func(w http.ResponseWriter, r *http.Request) {
+		historian, ok := store.(storage.Historian)
+		if !ok {
+			http.Error(w, "the storage keeps no history", http.StatusNotImplemented)
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		if _, err := storage.WithContext(store).FindByIdContext(r.Context(), id); err != nil {
+			storageError(w, err)
+			return
+		}
+
+		lines, err := historian.Blame(id)
+		if err != nil {
+			storageError(w, err)
+			return
+		}
+		writeJson(w, lines)
+	}).Methods("GET")
+
 	router.HandleFunc("/posts/{id}/edit", func(w http.ResponseWriter, r *http.Request) {
 		if authenticator != nil && !isLoggedIn(sessions, r) {
 			redirectToLogin(w, r)
@@ -458,15 +929,16 @@ func main() {
 		}
 
 		id := mux.Vars(r)["id"]
-		post, _ := store.FindById(id)
-		if post != nil {
-			m := make(map[string]interface{})
-			m["title"] = "Edit post"
-			m["post"] = post
-			templates.ExecuteTemplate(w, "post_form", m)
-		} else {
-			http.NotFound(w, r)
+		post, err := storage.WithContext(store).FindByIdContext(r.Context(), id)
+		if err != nil {
+			storageError(w, err)
+			return
 		}
+
+		m := make(map[string]interface{})
+		m["title"] = "Edit post"
+		m["post"] = post
+		templates.ExecuteTemplate(w, "post_form", m)
 	})
 
 	if Environment == "development" {
@@ -474,7 +946,81 @@ func main() {
 		router.PathPrefix("/assets").Handler(http.StripPrefix("/assets", http.FileServer(http.Dir("assets"))))
 	}
 
-	http.Handle("/", router)
+	return router
+}
+
+func main() {
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
+	pflag.Parse()
+
+	var store storage.Store
+	storageUrls := strings.Split(*storageUrl, ",")
+	if len(storageUrls) > 1 {
+		multiUrl := fmt.Sprintf("multi://?queue=%s&read=%s&primary=%s", url.QueryEscape(*replicationQueue), url.QueryEscape(*readPolicy), url.QueryEscape(storageUrls[0]))
+		for _, storageUrl := range storageUrls[1:] {
+			multiUrl = fmt.Sprintf("%s&secondary=%s", multiUrl, url.QueryEscape(storageUrl))
+		}
+		var err error
+		store, err = storage.Open(multiUrl)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		var err error
+		store, err = storage.Open(*storageUrl)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *webhooksPath != "" {
+		err := startWebhooks(*webhooksPath, store)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *backupInterval > 0 {
+		startBackups(store, backup.Kind(*storageUrl), *backupDir, *backupInterval, *backupKeep, *backupMaxAge)
+	}
+
+	var authenticator auth.Auth
+	if authUrl != nil && *authUrl != "" {
+		a, err := auth.Open(*authUrl)
+		if err != nil {
+			log.Fatal(err)
+		}
+		authenticator = a
+		requestAuth = a
+	}
+
+	if *apiTokensPath != "" {
+		tokens, err := loadApiTokens(*apiTokensPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		apiTokens = tokens
+	}
+
+	idGenerator, err := ids.Get(*idFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	templBasePath, err := getTemplateBasePath(*templateBase)
+	if err != nil || templBasePath == "" {
+		log.Print("Could not get template base path!")
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Using template base path: %s\n", templBasePath)
+	templates := templates.Templates(templBasePath, *assetBase)
+
+	router := newRouter(store, templates, authenticator, idGenerator)
+	http.Handle("/", withTimeout(router, *requestTimeout))
 
 	host := getEnv("HOST", "localhost")
 	port := getEnv("PORT", "5000")
@@ -0,0 +1,48 @@
+// pluggable generators for post ids
+package ids
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+type Generator interface {
+	// a new unique id for a post created at `t`
+	New(t time.Time) (string, error)
+}
+
+var registeredGenerators = map[string]Generator{}
+
+func Register(name string, generator Generator) {
+	if _, alreadyExists := registeredGenerators[name]; !alreadyExists {
+		registeredGenerators[name] = generator
+	} else {
+		log.Fatal("duplicate id generator:", name)
+	}
+}
+
+func Get(name string) (Generator, error) {
+	if generator, ok := registeredGenerators[name]; ok {
+		return generator, nil
+	} else {
+		return nil, errors.New(fmt.Sprintf("no such id generator: %s (available: %v)", name, Names()))
+	}
+}
+
+func Names() []string {
+	names := make([]string, 0, len(registeredGenerators))
+	for name := range registeredGenerators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register("ulid", NewULID())
+	Register("uuidv7", UUIDv7{})
+	Register("md5", MD5{})
+}
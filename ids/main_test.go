@@ -0,0 +1,64 @@
+package ids
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	tu "../util/testing"
+)
+
+func TestGet(t *testing.T) {
+	for _, name := range []string{"ulid", "uuidv7", "md5"} {
+		_, err := Get(name)
+		tu.RequireNil(t, err)
+	}
+
+	_, err := Get("serial")
+	tu.ExpectNotNil(t, err)
+}
+
+func TestULID(t *testing.T) {
+	expectSortedAndUnique(t, NewULID(), 26)
+}
+
+func TestUUIDv7(t *testing.T) {
+	g := UUIDv7{}
+	id, err := g.New(time.Now())
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(id), 36)
+	tu.ExpectEqual(t, id[14], byte('7'))
+
+	// only sorted across milliseconds
+	var previous string
+	for i := 0; i < 10; i++ {
+		id, err := g.New(time.Now().Add(time.Duration(i) * time.Millisecond))
+		tu.RequireNil(t, err)
+		if id <= previous {
+			t.Errorf("%s should sort after %s", id, previous)
+		}
+		previous = id
+	}
+}
+
+func expectSortedAndUnique(t *testing.T, g Generator, length int) {
+	// all in the same millisecond
+	now := time.Now()
+	generated := make([]string, 1000)
+	seen := map[string]bool{}
+	for i := range generated {
+		id, err := g.New(now)
+		tu.RequireNil(t, err)
+		tu.RequireEqual(t, len(id), length)
+		if seen[id] {
+			t.Fatal("duplicate id:", id)
+		}
+		seen[id] = true
+		generated[i] = id
+	}
+
+	tu.ExpectEqual(t, sort.StringsAreSorted(generated), true)
+
+	later, _ := g.New(now.Add(time.Millisecond))
+	tu.ExpectEqual(t, later > generated[len(generated)-1], true)
+}
@@ -0,0 +1,22 @@
+package ids
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// the ids gol used to generate: the md5 sum of the creation time in
+// nanoseconds.  may collide and doesn't sort, only kept for compatibility.
+type MD5 struct{}
+
+func (g MD5) New(t time.Time) (string, error) {
+	buf := new(bytes.Buffer)
+	err := binary.Write(buf, binary.LittleEndian, t.UnixNano())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", md5.Sum(buf.Bytes())), nil
+}
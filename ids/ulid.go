@@ -0,0 +1,85 @@
+package ids
+
+import (
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+)
+
+// see https://github.com/ulid/spec
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// 48 bits of milliseconds since the epoch and 80 random bits, encoded as
+// 26 characters that sort by creation time.
+//
+// ids generated in the same millisecond by the same generator increment
+// the random part, so they sort in the order they were created.
+type ULID struct {
+	mu       sync.Mutex
+	lastMs   uint64
+	lastRand [10]byte
+}
+
+func NewULID() *ULID {
+	return &ULID{}
+}
+
+func (g *ULID) New(t time.Time) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	if ms <= g.lastMs && g.lastMs != 0 {
+		// same (or earlier, if the clock went backwards) millisecond
+		ms = g.lastMs
+		if !increment(g.lastRand[:]) {
+			return "", errors.New("ulid: random part overflowed")
+		}
+	} else {
+		_, err := rand.Read(g.lastRand[:])
+		if err != nil {
+			return "", err
+		}
+		g.lastMs = ms
+	}
+
+	var id [16]byte
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> uint(40-8*i))
+	}
+	copy(id[6:], g.lastRand[:])
+	return encodeCrockford(id), nil
+}
+
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodes 128 bits as 26 characters, 5 bits each (the first one only has
+// 3 bits)
+func encodeCrockford(id [16]byte) string {
+	var out [26]byte
+	// work on the bits from the right, carrying over what's left
+	var acc uint32
+	var bits uint
+	pos := len(out) - 1
+	for i := len(id) - 1; i >= 0; i-- {
+		acc |= uint32(id[i]) << bits
+		bits += 8
+		for bits >= 5 {
+			out[pos] = crockford[acc&31]
+			pos--
+			acc >>= 5
+			bits -= 5
+		}
+	}
+	out[pos] = crockford[acc&31]
+	return string(out[:])
+}
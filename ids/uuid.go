@@ -0,0 +1,30 @@
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// a uuid version 7 (RFC 9562): 48 bits of milliseconds since the epoch
+// followed by random bits.  its string form sorts by creation time.
+type UUIDv7 struct{}
+
+func (g UUIDv7) New(t time.Time) (string, error) {
+	var id [16]byte
+	_, err := rand.Read(id[6:])
+	if err != nil {
+		return "", err
+	}
+
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> uint(40-8*i))
+	}
+	id[6] = 0x70 | (id[6] & 0x0f) // version 7
+	id[8] = 0x80 | (id[8] & 0x3f) // variant 10
+
+	h := hex.EncodeToString(id[:])
+	return fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32]), nil
+}
@@ -1,15 +1,110 @@
 package post
 
 import (
+	"fmt"
+	"regexp"
 	"sort"
+	"strings"
 	"time"
 )
 
 type Post struct {
-	Id      string    `json:"id"`
-	Title   string    `json:"title"`
-	Content string    `json:"content"`
-	Created time.Time `json:"created"`
+	Id        string    `json:"id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated,omitzero"` // zero if never edited
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	Slug      string    `json:"slug,omitempty"`
+	OldSlugs  []string  `json:"old_slugs,omitempty"` // redirect to the current slug
+}
+
+func (p Post) Edited() bool {
+	return !p.Updated.IsZero()
+}
+
+// when the post was last changed (or created)
+func (p Post) LastModified() time.Time {
+	if p.Edited() {
+		return p.Updated
+	}
+	return p.Created
+}
+
+const maxSlugLength = 60
+
+// a human-readable url component, e.g. "introducing-the-query-interface"
+func Slugify(title string) string {
+	slug := make([]rune, 0, len(title))
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') {
+			if dash && len(slug) > 0 {
+				slug = append(slug, '-')
+			}
+			dash = false
+			slug = append(slug, r)
+		} else {
+			dash = true
+		}
+	}
+
+	// cut long slugs at a word boundary
+	if len(slug) > maxSlugLength {
+		slug = slug[:maxSlugLength+1]
+		if i := strings.LastIndex(string(slug), "-"); i > 0 {
+			slug = slug[:i]
+		} else {
+			slug = slug[:maxSlugLength]
+		}
+	}
+
+	if len(slug) == 0 {
+		return "post"
+	}
+	return string(slug)
+}
+
+// the slug the post is reachable under, posts created before slugs
+// existed don't have one stored
+func (p Post) PermalinkSlug() string {
+	if p.Slug != "" {
+		return p.Slug
+	}
+	return Slugify(p.Title)
+}
+
+// e.g. /2015/09/14/introducing-the-query-interface (dates are in utc)
+func (p Post) Permalink() string {
+	return fmt.Sprintf("%s/%s", p.Created.UTC().Format("/2006/01/02"), p.PermalinkSlug())
+}
+
+func (p Post) HasOldSlug(slug string) bool {
+	for _, s := range p.OldSlugs {
+		if s == slug {
+			return true
+		}
+	}
+	return false
+}
+
+var tagRegexp = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_-]+)`)
+
+// the tags of a post are the #hashtags in its title and content, in
+// lowercase and in order of their first appearance
+func (p Post) Tags() []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, text := range []string{p.Title, p.Content} {
+		for _, m := range tagRegexp.FindAllStringSubmatch(text, -1) {
+			tag := strings.ToLower(m[1])
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
 }
 
 type ByDate []Post
@@ -18,6 +113,14 @@ func (p ByDate) Len() int           { return len(p) }
 func (p ByDate) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 func (p ByDate) Less(i, j int) bool { return p[i].Created.UnixNano() < p[j].Created.UnixNano() }
 
+type ByUpdated []Post
+
+func (p ByUpdated) Len() int      { return len(p) }
+func (p ByUpdated) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p ByUpdated) Less(i, j int) bool {
+	return p[i].LastModified().UnixNano() < p[j].LastModified().UnixNano()
+}
+
 type ByTitle []Post
 
 func (p ByTitle) Len() int           { return len(p) }
@@ -0,0 +1,70 @@
+package post
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTags(t *testing.T) {
+	p := Post{
+		Title:   "Deploying #gol",
+		Content: "# Notes\n\nwent fine. #ops #Deploy, see issue#12 and #ops again.\n#gol",
+	}
+
+	tags := strings.Join(p.Tags(), ",")
+	if tags != "gol,ops,deploy" {
+		t.Errorf("unexpected tags: %s", tags)
+	}
+
+	if len(Post{Content: "no tags here"}.Tags()) != 0 {
+		t.Error("expected no tags")
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	slugs := map[string]string{
+		"Introducing the Query Interface": "introducing-the-query-interface",
+		"  Hello, World!  ":               "hello-world",
+		"gol 0.2.0 -- now with sqlite":    "gol-0-2-0-now-with-sqlite",
+		"Überraschung":                    "berraschung",
+		"???":                             "post",
+		strings.Repeat("long ", 20):       strings.TrimSuffix(strings.Repeat("long-", 12), "-"),
+	}
+
+	for title, expected := range slugs {
+		if slug := Slugify(title); slug != expected {
+			t.Errorf("Slugify(%#v) = %#v, expected %#v", title, slug, expected)
+		}
+	}
+}
+
+func TestPermalink(t *testing.T) {
+	p := Post{
+		Title:   "Introducing the query interface",
+		Created: time.Date(2015, 9, 14, 1, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60)),
+	}
+	if p.Permalink() != "/2015/09/13/introducing-the-query-interface" {
+		t.Error("unexpected permalink:", p.Permalink())
+	}
+
+	p.Slug = "introducing-queries"
+	if p.Permalink() != "/2015/09/13/introducing-queries" {
+		t.Error("unexpected permalink:", p.Permalink())
+	}
+}
+
+func TestByUpdated(t *testing.T) {
+	t1 := time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)
+	posts := []Post{
+		Post{Id: "1", Created: t1, Updated: t1.Add(3 * time.Hour)},
+		Post{Id: "2", Created: t1.Add(time.Hour)},
+		Post{Id: "3", Created: t1.Add(2 * time.Hour)},
+	}
+
+	Sort(ByUpdated(posts))
+	ids := posts[0].Id + posts[1].Id + posts[2].Id
+	if ids != "231" {
+		t.Error("unexpected order:", ids)
+	}
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"../post"
+	"./query"
+)
+
+// the groupings supported by `Aggregate`
+const (
+	ByDay   = "day"
+	ByMonth = "month"
+	ByYear  = "year"
+	ByTag   = "tag"
+)
+
+// the number of posts in one group (e.g. `{"2015-09", 3}` when grouping
+// by month)
+type Count struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// implemented by stores that can count posts themselves
+type Aggregator interface {
+	Aggregate(by string, q query.Query) ([]Count, error)
+}
+
+// counts the posts matching `q`, grouped by day, month, year (in utc) or
+// by tag, sorted by key
+//
+// uses the store's own implementation if it has one.
+func Aggregate(s Store, by string, q query.Query) ([]Count, error) {
+	if err := validGrouping(by); err != nil {
+		return nil, err
+	}
+
+	if a, ok := s.(Aggregator); ok {
+		return a.Aggregate(by, q)
+	}
+
+	posts, err := s.Find(q)
+	if err != nil {
+		return nil, err
+	}
+	return CountPosts(posts, by)
+}
+
+// groups and counts the given posts, for stores that don't have a
+// more efficient way to do that
+func CountPosts(posts []post.Post, by string) ([]Count, error) {
+	if err := validGrouping(by); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, p := range posts {
+		for _, key := range groupKeys(p, by) {
+			counts[key] += 1
+		}
+	}
+
+	result := make([]Count, 0, len(counts))
+	for key, n := range counts {
+		result = append(result, Count{key, n})
+	}
+	sort.Sort(byKey(result))
+	return result, nil
+}
+
+func groupKeys(p post.Post, by string) []string {
+	created := p.Created.UTC()
+	switch by {
+	case ByDay:
+		return []string{created.Format("2006-01-02")}
+	case ByMonth:
+		return []string{created.Format("2006-01")}
+	case ByYear:
+		return []string{created.Format("2006")}
+	case ByTag:
+		return p.Tags()
+	}
+	return nil
+}
+
+func validGrouping(by string) error {
+	switch by {
+	case ByDay, ByMonth, ByYear, ByTag:
+		return nil
+	default:
+		return InvalidQuery(errors.New(fmt.Sprintf("can't group by %#v, must be one of day, month, year or tag", by)))
+	}
+}
+
+type byKey []Count
+
+func (c byKey) Len() int           { return len(c) }
+func (c byKey) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byKey) Less(i, j int) bool { return c[i].Key < c[j].Key }
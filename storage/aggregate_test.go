@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"../post"
+	tu "../util/testing"
+)
+
+func TestCountPosts(t *testing.T) {
+	posts := []post.Post{
+		post.Post{Id: "1", Content: "#ops", Created: time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)},
+		post.Post{Id: "2", Content: "#ops #db", Created: time.Date(2015, 9, 14, 23, 0, 0, 0, time.UTC)},
+		post.Post{Id: "3", Content: "", Created: time.Date(2015, 10, 1, 8, 0, 0, 0, time.UTC)},
+		// still the 14th in utc
+		post.Post{Id: "4", Content: "#db", Created: time.Date(2015, 9, 15, 1, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60))},
+	}
+
+	counts, err := CountPosts(posts, ByDay)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(counts), 2)
+	tu.ExpectEqual(t, counts[0], Count{"2015-09-14", 3})
+	tu.ExpectEqual(t, counts[1], Count{"2015-10-01", 1})
+
+	counts, _ = CountPosts(posts, ByMonth)
+	tu.RequireEqual(t, len(counts), 2)
+	tu.ExpectEqual(t, counts[0], Count{"2015-09", 3})
+	tu.ExpectEqual(t, counts[1], Count{"2015-10", 1})
+
+	counts, _ = CountPosts(posts, ByYear)
+	tu.RequireEqual(t, len(counts), 1)
+	tu.ExpectEqual(t, counts[0], Count{"2015", 4})
+
+	counts, _ = CountPosts(posts, ByTag)
+	tu.RequireEqual(t, len(counts), 2)
+	tu.ExpectEqual(t, counts[0], Count{"db", 2})
+	tu.ExpectEqual(t, counts[1], Count{"ops", 2})
+
+	_, err = CountPosts(posts, "week")
+	tu.ExpectNotNil(t, err)
+}
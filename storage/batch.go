@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"../post"
+)
+
+// what a change in a batch does
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// one change in a batch.  creates and updates need the post, deletes only
+// the id.
+type Op struct {
+	Op   string     `json:"op"`
+	Id   string     `json:"id,omitempty"`
+	Post *post.Post `json:"post,omitempty"`
+}
+
+// the id of the post `o` changes
+func (o Op) PostId() string {
+	if o.Post != nil {
+		return o.Post.Id
+	}
+	return o.Id
+}
+
+// the type of the event for `o`, e.g. `Created`
+func (o Op) EventType() string {
+	switch o.Op {
+	case OpCreate:
+		return Created
+	case OpUpdate:
+		return Updated
+	default:
+		return Deleted
+	}
+}
+
+// implemented by stores that can apply many changes at once
+type Batcher interface {
+	// applies `ops` in order, all of them or none.  returns a `BatchError`
+	// if one of them fails.
+	Batch(ctx context.Context, ops []Op) error
+}
+
+// the change of a batch that failed
+type BatchError struct {
+	// of the change in the batch
+	Index int
+	Op    Op
+	Err   error
+}
+
+func (e BatchError) Error() string {
+	return fmt.Sprintf("change %d (%s %s): %s", e.Index, e.Op.Op, e.Op.PostId(), e.Err)
+}
+
+func (e BatchError) Unwrap() error { return e.Err }
+
+// checks that the changes are complete, before anything is applied
+func CheckOps(ops []Op) error {
+	for i, op := range ops {
+		var reason string
+		switch {
+		case op.Op != OpCreate && op.Op != OpUpdate && op.Op != OpDelete:
+			reason = fmt.Sprintf("unknown op %#v, must be one of %s, %s or %s", op.Op, OpCreate, OpUpdate, OpDelete)
+		case op.Op != OpDelete && op.Post == nil:
+			reason = fmt.Sprintf("%s needs a post", op.Op)
+		case op.PostId() == "":
+			reason = "the id is missing"
+		}
+		if reason != "" {
+			return BatchError{Index: i, Op: op, Err: InvalidOpError{Reason: reason}}
+		}
+	}
+	return nil
+}
+
+// applies `ops` to `s`, atomically if `s` is a `Batcher`.  otherwise one
+// change after the other, undoing the ones already made if one fails.
+// others can see the changes before that, and undoing may fail as well.
+func Batch(ctx context.Context, s Store, ops []Op) error {
+	err := CheckOps(ops)
+	if err != nil {
+		return err
+	}
+
+	if b, ok := s.(Batcher); ok {
+		return b.Batch(ctx, ops)
+	}
+	return applyEach(ctx, WithContext(s), ops)
+}
+
+func applyEach(ctx context.Context, s ContextStore, ops []Op) error {
+	undo := make([]Op, 0, len(ops))
+	for i, op := range ops {
+		var old *post.Post
+		if op.Op != OpCreate {
+			old, _ = s.FindByIdContext(ctx, op.PostId())
+		}
+
+		err := Apply(ctx, s, op)
+		if err != nil {
+			// the batch is given up, but undoing shouldn't be
+			for j := len(undo) - 1; j >= 0; j-- {
+				undoErr := Apply(context.Background(), s, undo[j])
+				if undoErr != nil {
+					log.Printf("Error: could not undo a change of a failed batch (%s %s): %s", undo[j].Op, undo[j].PostId(), undoErr)
+				}
+			}
+			return BatchError{Index: i, Op: op, Err: err}
+		}
+
+		switch {
+		case op.Op == OpCreate:
+			undo = append(undo, Op{Op: OpDelete, Id: op.PostId()})
+		case old == nil:
+			// nothing to restore
+		case op.Op == OpUpdate:
+			undo = append(undo, Op{Op: OpUpdate, Post: old})
+		case op.Op == OpDelete:
+			undo = append(undo, Op{Op: OpCreate, Post: old})
+		}
+	}
+	return nil
+}
+
+// applies a single change
+func Apply(ctx context.Context, s ContextStore, op Op) error {
+	switch op.Op {
+	case OpCreate:
+		return s.CreateContext(ctx, *op.Post)
+	case OpUpdate:
+		return s.UpdateContext(ctx, *op.Post)
+	case OpDelete:
+		return s.DeleteContext(ctx, op.PostId())
+	default:
+		return InvalidOpError{Reason: fmt.Sprintf("unknown op %#v", op.Op)}
+	}
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"../post"
+	tu "../util/testing"
+	"./query"
+)
+
+// a store that can't apply batches itself
+type mapStore map[string]post.Post
+
+func (s mapStore) Find(q query.Query) ([]post.Post, error) { return s.FindAll() }
+
+func (s mapStore) FindById(id string) (*post.Post, error) {
+	p, ok := s[id]
+	if !ok {
+		return nil, NotFoundError{Id: id}
+	}
+	return &p, nil
+}
+
+func (s mapStore) FindAll() ([]post.Post, error) {
+	posts := make([]post.Post, 0, len(s))
+	for _, p := range s {
+		posts = append(posts, p)
+	}
+	return posts, nil
+}
+
+func (s mapStore) Create(p post.Post) error {
+	if _, ok := s[p.Id]; ok {
+		return ConflictError{Id: p.Id}
+	}
+	s[p.Id] = p
+	return nil
+}
+
+func (s mapStore) Update(p post.Post) error {
+	if _, ok := s[p.Id]; !ok {
+		return NotFoundError{Id: p.Id}
+	}
+	s[p.Id] = p
+	return nil
+}
+
+func (s mapStore) Delete(id string) error {
+	if _, ok := s[id]; !ok {
+		return NotFoundError{Id: id}
+	}
+	delete(s, id)
+	return nil
+}
+
+func (s mapStore) Close() error { return nil }
+
+func TestBatch(t *testing.T) {
+	s := mapStore{"1": {Id: "1", Title: "cats"}, "2": {Id: "2", Title: "dogs"}}
+
+	err := Batch(context.Background(), s, []Op{
+		{Op: OpCreate, Post: &post.Post{Id: "3", Title: "birds"}},
+		{Op: OpUpdate, Post: &post.Post{Id: "1", Title: "more cats"}},
+		{Op: OpDelete, Id: "2"},
+	})
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(s), 2)
+	tu.ExpectEqual(t, s["1"].Title, "more cats")
+	tu.ExpectEqual(t, s["3"].Title, "birds")
+}
+
+func TestBatchUndo(t *testing.T) {
+	s := mapStore{"1": {Id: "1", Title: "cats"}, "2": {Id: "2", Title: "dogs"}}
+
+	err := Batch(context.Background(), s, []Op{
+		{Op: OpCreate, Post: &post.Post{Id: "3", Title: "birds"}},
+		{Op: OpUpdate, Post: &post.Post{Id: "1", Title: "more cats"}},
+		{Op: OpDelete, Id: "2"},
+		{Op: OpCreate, Post: &post.Post{Id: "1"}},
+	})
+	tu.ExpectEqual(t, errors.Is(err, ErrConflict), true)
+	var batchErr BatchError
+	tu.RequireEqual(t, errors.As(err, &batchErr), true)
+	tu.ExpectEqual(t, batchErr.Index, 3)
+
+	tu.ExpectEqual(t, s, mapStore{"1": {Id: "1", Title: "cats"}, "2": {Id: "2", Title: "dogs"}})
+}
+
+func TestCheckOps(t *testing.T) {
+	invalid := [][]Op{
+		{{Op: "rename", Id: "1"}},
+		{{Op: OpCreate, Id: "1"}},
+		{{Op: OpDelete}},
+		{{Op: OpUpdate, Post: &post.Post{}}},
+	}
+	for _, ops := range invalid {
+		s := mapStore{}
+		err := Batch(context.Background(), s, ops)
+		tu.ExpectEqual(t, errors.Is(err, ErrInvalidOp), true)
+	}
+}
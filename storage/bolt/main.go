@@ -0,0 +1,286 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	storage ".."
+	"../../post"
+	"go.etcd.io/bbolt"
+)
+
+type Backend struct{}
+
+// posts are stored as json in the `posts` bucket, by id.  the buckets
+// `created`, `updated` and `title` are indexes for sorting and ranges,
+// their keys are the indexed value and the id (see `indexKey`).
+type Store struct {
+	db *bbolt.DB
+}
+
+var (
+	postsBucket = []byte("posts")
+	indexes     = map[string]func(p post.Post) []byte{
+		"created": func(p post.Post) []byte { return timeKey(p.Created) },
+		"updated": func(p post.Post) []byte { return timeKey(p.LastModified()) },
+		"title":   func(p post.Post) []byte { return []byte(p.Title) },
+	}
+)
+
+func init() {
+	storage.Register("bolt", Backend{})
+}
+
+func (b Backend) Open(u *url.URL) (storage.Store, error) {
+	path := u.Host + u.Path
+
+	// only one process may have the database open
+	db, err := bbolt.Open(path, 0644, &bbolt.Options{Timeout: time.Second})
+	if err == bbolt.ErrTimeout {
+		return nil, errors.New(fmt.Sprintf("%s is locked, is another gol using it?", path))
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(postsBucket)
+		if err != nil {
+			return err
+		}
+		for name := range indexes {
+			_, err = tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return storage.Store(&Store{db: db}), nil
+}
+
+// times sort by their utc string, e.g. 2015-09-14T10:00:00.000000000
+func timeKey(t time.Time) []byte {
+	return []byte(t.UTC().Format("2006-01-02T15:04:05.000000000"))
+}
+
+// the value and the id, separated by a zero byte, so that posts with the
+// same value have different keys
+func indexKey(value []byte, id string) []byte {
+	key := make([]byte, 0, len(value)+1+len(id))
+	key = append(key, value...)
+	key = append(key, 0)
+	return append(key, id...)
+}
+
+// splits a key made by `indexKey`
+func splitKey(key []byte) (value []byte, id []byte) {
+	i := bytes.LastIndexByte(key, 0)
+	return key[:i], key[i+1:]
+}
+
+func getPost(tx *bbolt.Tx, id []byte) (*post.Post, error) {
+	data := tx.Bucket(postsBucket).Get(id)
+	if data == nil {
+		return nil, storage.NotFoundError{Id: string(id)}
+	}
+
+	var p post.Post
+	err := json.Unmarshal(data, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func putPost(tx *bbolt.Tx, p post.Post) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket(postsBucket).Put([]byte(p.Id), data)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range indexes {
+		err = tx.Bucket([]byte(name)).Put(indexKey(value(p), p.Id), nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deletePost(tx *bbolt.Tx, p post.Post) error {
+	for name, value := range indexes {
+		err := tx.Bucket([]byte(name)).Delete(indexKey(value(p), p.Id))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(postsBucket).Delete([]byte(p.Id))
+}
+
+// `Find` is implemented in `./query.go`
+
+func (s *Store) FindById(id string) (*post.Post, error) {
+	var p *post.Post
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		p, err = getPost(tx, []byte(id))
+		return err
+	})
+	return p, err
+}
+
+func (s *Store) FindAll() ([]post.Post, error) {
+	posts := []post.Post{}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(postsBucket).ForEach(func(id, data []byte) error {
+			var p post.Post
+			err := json.Unmarshal(data, &p)
+			if err != nil {
+				return err
+			}
+			posts = append(posts, p)
+			return nil
+		})
+	})
+	return posts, err
+}
+
+func (s *Store) Create(p post.Post) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return create(tx, p)
+	})
+}
+
+func (s *Store) Update(updatedPost post.Post) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return update(context.Background(), tx, updatedPost)
+	})
+}
+
+func (s *Store) Delete(id string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return remove(tx, id)
+	})
+}
+
+// applies all changes in one write transaction
+func (s *Store) Batch(ctx context.Context, ops []storage.Op) error {
+	err := storage.CheckOps(ops)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		for i, op := range ops {
+			var err error
+			switch op.Op {
+			case storage.OpCreate:
+				err = create(tx, *op.Post)
+			case storage.OpUpdate:
+				err = update(ctx, tx, *op.Post)
+			case storage.OpDelete:
+				err = remove(tx, op.PostId())
+			}
+			if err == nil {
+				err = ctx.Err()
+			}
+			if err != nil {
+				return storage.BatchError{Index: i, Op: op, Err: err}
+			}
+		}
+		return nil
+	})
+}
+
+func create(tx *bbolt.Tx, p post.Post) error {
+	if tx.Bucket(postsBucket).Get([]byte(p.Id)) != nil {
+		return storage.ConflictError{Id: p.Id}
+	}
+	taken, err := slugTaken(tx, p)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return storage.ConflictError{Id: p.Id, Slug: p.Slug}
+	}
+	return putPost(tx, p)
+}
+
+// whether another post created on the same day already has the slug of `p`
+func slugTaken(tx *bbolt.Tx, p post.Post) (bool, error) {
+	if p.Slug == "" {
+		return false, nil
+	}
+
+	day := []byte(p.Created.UTC().Format("2006-01-02"))
+	c := tx.Bucket([]byte("created")).Cursor()
+	for key, _ := c.Seek(day); key != nil && bytes.HasPrefix(key, day); key, _ = c.Next() {
+		_, id := splitKey(key)
+		other, err := getPost(tx, id)
+		if err != nil {
+			return false, err
+		}
+		if storage.SlugsCollide(p, *other) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func update(ctx context.Context, tx *bbolt.Tx, updatedPost post.Post) error {
+	oldPost, err := getPost(tx, []byte(updatedPost.Id))
+	if err != nil {
+		return err
+	}
+
+	// the index keys of the old version are replaced
+	err = deletePost(tx, *oldPost)
+	if err != nil {
+		return err
+	}
+
+	storage.MarkUpdatedContext(ctx, &updatedPost, *oldPost)
+	p := *oldPost
+	p.Title = updatedPost.Title
+	p.Content = updatedPost.Content
+	p.Updated = updatedPost.Updated
+	p.UpdatedBy = updatedPost.UpdatedBy
+	p.Slug = updatedPost.Slug
+	p.OldSlugs = updatedPost.OldSlugs
+	if p.Slug != oldPost.Slug {
+		taken, err := slugTaken(tx, p)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return storage.ConflictError{Id: p.Id, Slug: p.Slug}
+		}
+	}
+	return putPost(tx, p)
+}
+
+func remove(tx *bbolt.Tx, id string) error {
+	p, err := getPost(tx, []byte(id))
+	if err != nil {
+		return err
+	}
+	return deletePost(tx, *p)
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,265 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	storage ".."
+	"../../post"
+	tu "../../util/testing"
+	"../memory"
+	"../query"
+)
+
+func openStore(t *testing.T) (*Store, string) {
+	tmpPath, err := ioutil.TempDir("", "gol_bolt_test")
+	tu.RequireNil(t, err)
+
+	u, err := url.Parse("bolt://" + path.Join(tmpPath, "posts.db"))
+	tu.RequireNil(t, err)
+	store, err := Backend{}.Open(u)
+	tu.RequireNil(t, err)
+	return store.(*Store), tmpPath
+}
+
+func examplePosts() []post.Post {
+	day := func(d int) time.Time {
+		return time.Date(2015, 9, d, 10, 0, 0, 0, time.UTC)
+	}
+	return []post.Post{
+		{Id: "1", Title: "cats", Content: "meow", Created: day(1)},
+		{Id: "2", Title: "birds", Content: "tweet, meow?", Created: day(3), Updated: day(20)},
+		{Id: "3", Title: "dogs", Content: "woof", Created: day(2).In(time.FixedZone("CEST", 2*60*60))},
+		{Id: "4", Title: "another cat", Content: "meow!", Created: day(10), Updated: day(11)},
+		{Id: "5", Title: "fish", Content: "...", Created: day(14)},
+	}
+}
+
+// the memory backend defines what queries mean
+func TestSameAsMemory(t *testing.T) {
+	s, tmpPath := openStore(t)
+	defer os.RemoveAll(tmpPath)
+	defer s.Close()
+
+	posts := examplePosts()
+	for _, p := range posts {
+		tu.RequireNil(t, s.Create(p))
+	}
+	m := memory.FromPosts(posts)
+
+	day := func(d int) time.Time {
+		return time.Date(2015, 9, d, 0, 0, 0, 0, time.UTC)
+	}
+	queries := []query.Builder{
+		storage.Query(),
+		storage.Query().Reverse(),
+		storage.Query().Find("id", "3"),
+		storage.Query().Find("title", "birds"),
+		storage.Query().Find("title", "bird"),
+		storage.Query().Start(1).Count(2),
+		storage.Query().Start(1).Count(2).Reverse(),
+		storage.Query().Start(10),
+		storage.Query().Count(0),
+		storage.Query().Match("content", "meow"),
+		storage.Query().Match("content", "meow").Start(1).Count(1).Reverse(),
+		storage.Query().Match("text", "Cat meow"),
+		storage.Query().SortBy("title"),
+		storage.Query().SortBy("title").Reverse().Count(3),
+		storage.Query().SortBy("updated"),
+		storage.Query().Range(day(2), day(10).Add(10*time.Hour)),
+		storage.Query().Range(day(2), day(10).Add(10*time.Hour)).Reverse(),
+		storage.Query().Range(day(2), day(3)).SortBy("title"),
+		storage.Query().Since(day(3)),
+		storage.Query().Until(day(3)).Reverse(),
+		storage.Query().Since(day(11)).RangeBy("updated").SortBy("updated"),
+		storage.Query().Since(day(11)).RangeBy("updated").Reverse(),
+	}
+
+	for i, b := range queries {
+		q, err := b.Build()
+		tu.RequireNil(t, err)
+
+		expected, err := m.Find(*q)
+		tu.RequireNil(t, err)
+		actual, err := s.Find(*q)
+		tu.RequireNil(t, err)
+
+		tu.RequireEqual(t, len(actual), len(expected))
+		for j := range expected {
+			if actual[j].Id != expected[j].Id {
+				t.Errorf("query %d: expected %s at %d, got %s", i, expected[j].Id, j, actual[j].Id)
+			}
+		}
+	}
+
+	_, err := s.Find(query.Query{Start: -1, Count: -1, SortBy: "content"})
+	tu.ExpectNotNil(t, err)
+}
+
+func TestUniqueSlugs(t *testing.T) {
+	store, tmpPath := openStore(t)
+	defer os.RemoveAll(tmpPath)
+	defer store.Close()
+
+	day := time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)
+	tu.RequireNil(t, store.Create(post.Post{Id: "1", Title: "cats", Slug: "cats", Created: day}))
+	tu.RequireNil(t, store.Create(post.Post{Id: "2", Title: "dogs", Slug: "dogs", Created: day}))
+
+	// the same day in utc
+	cest := time.FixedZone("CEST", 2*60*60)
+	err := store.Create(post.Post{Id: "3", Title: "cats", Slug: "cats", Created: time.Date(2015, 9, 15, 1, 0, 0, 0, cest)})
+	tu.ExpectEqual(t, err, storage.ConflictError{Id: "3", Slug: "cats"})
+	err = store.Update(post.Post{Id: "2", Title: "cats", Slug: "cats", Created: day})
+	tu.ExpectEqual(t, err, storage.ConflictError{Id: "2", Slug: "cats"})
+
+	// on another day, or without a slug
+	tu.RequireNil(t, store.Create(post.Post{Id: "4", Title: "cats", Slug: "cats", Created: day.AddDate(0, 0, 1)}))
+	tu.RequireNil(t, store.Create(post.Post{Id: "5", Title: "cats", Created: day}))
+	tu.RequireNil(t, store.Create(post.Post{Id: "6", Title: "cats", Created: day}))
+}
+
+func TestCreateUpdateDelete(t *testing.T) {
+	s, tmpPath := openStore(t)
+	defer os.RemoveAll(tmpPath)
+	defer s.Close()
+
+	p := examplePosts()[0]
+	tu.RequireNil(t, s.Create(p))
+	tu.ExpectNotNil(t, s.Create(p))
+
+	p.Title = "kittens"
+	tu.RequireNil(t, s.Update(p))
+
+	found, err := s.FindById(p.Id)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, found.Title, "kittens")
+	tu.ExpectEqual(t, found.Edited(), true)
+
+	// the old title is not in the index anymore
+	q, _ := storage.Query().Find("title", "cats").Build()
+	posts, err := s.Find(*q)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(posts), 0)
+
+	tu.RequireNil(t, s.Delete(p.Id))
+	tu.ExpectNotNil(t, s.Delete(p.Id))
+
+	posts, err = s.FindAll()
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(posts), 0)
+
+	q, _ = storage.Query().SortBy("title").Build()
+	posts, err = s.Find(*q)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(posts), 0)
+}
+
+func TestLocked(t *testing.T) {
+	s, tmpPath := openStore(t)
+	defer os.RemoveAll(tmpPath)
+	defer s.Close()
+
+	u, _ := url.Parse("bolt://" + path.Join(tmpPath, "posts.db"))
+	_, err := Backend{}.Open(u)
+	tu.ExpectNotNil(t, err)
+}
+
+func TestEach(t *testing.T) {
+	s, tmpPath := openStore(t)
+	defer os.RemoveAll(tmpPath)
+	defer s.Close()
+
+	for _, p := range examplePosts() {
+		tu.RequireNil(t, s.Create(p))
+	}
+
+	q, _ := storage.Query().Reverse().Build()
+	var ids []string
+	err := storage.Each(context.Background(), s, *q, func(p post.Post) error {
+		ids = append(ids, p.Id)
+		if len(ids) == 3 {
+			return storage.ErrStop
+		}
+		return nil
+	})
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, ids, []string{"5", "4", "2"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = s.Each(ctx, *q, func(p post.Post) error { return nil })
+	tu.ExpectEqual(t, err, context.Canceled)
+}
+
+func TestBatch(t *testing.T) {
+	s, tmpPath := openStore(t)
+	defer os.RemoveAll(tmpPath)
+	defer s.Close()
+
+	ps := examplePosts()
+	tu.RequireNil(t, s.Create(ps[0]))
+
+	// the last change fails, the transaction is rolled back
+	err := s.Batch(context.Background(), []storage.Op{
+		{Op: storage.OpCreate, Post: &ps[1]},
+		{Op: storage.OpDelete, Id: ps[0].Id},
+		{Op: storage.OpUpdate, Post: &ps[2]},
+	})
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrNotFound), true)
+	posts, _ := s.FindAll()
+	tu.ExpectEqual(t, len(posts), 1)
+
+	kittens := ps[0]
+	kittens.Title = "kittens"
+	err = s.Batch(context.Background(), []storage.Op{
+		{Op: storage.OpCreate, Post: &ps[1]},
+		{Op: storage.OpCreate, Post: &ps[2]},
+		{Op: storage.OpUpdate, Post: &kittens},
+		{Op: storage.OpDelete, Id: ps[1].Id},
+	})
+	tu.RequireNil(t, err)
+
+	q, _ := storage.Query().SortBy("title").Build()
+	posts, err = s.Find(*q)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(posts), 2)
+	tu.ExpectEqual(t, posts[0].Title, "dogs")
+	tu.ExpectEqual(t, posts[1].Title, "kittens")
+}
+
+func TestEachPages(t *testing.T) {
+	s, tmpPath := openStore(t)
+	defer os.RemoveAll(tmpPath)
+	defer s.Close()
+
+	n := 2*pageSize + 50
+	for i := 0; i < n; i++ {
+		tu.RequireNil(t, s.Create(post.Post{
+			Id:      fmt.Sprintf("%03d", i),
+			Title:   "post",
+			Created: time.Date(2015, 9, 1, 0, i, 0, 0, time.UTC),
+		}))
+	}
+
+	// no transaction is open while `f` runs, so it may change the store
+	q, _ := storage.Query().Reverse().Start(5).Count(uint(n - 10)).Build()
+	var ids []string
+	err := s.Each(context.Background(), *q, func(p post.Post) error {
+		ids = append(ids, p.Id)
+		return s.Delete(p.Id)
+	})
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(ids), n-10)
+	tu.ExpectEqual(t, ids[0], fmt.Sprintf("%03d", n-6))
+	tu.ExpectEqual(t, ids[len(ids)-1], "005")
+
+	posts, _ := s.FindAll()
+	tu.ExpectEqual(t, len(posts), 10)
+}
@@ -0,0 +1,260 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	storage ".."
+	"../../post"
+	"../memory"
+	"../query"
+	"go.etcd.io/bbolt"
+)
+
+func (s *Store) Find(q query.Query) ([]post.Post, error) {
+	posts := make([]post.Post, 0, 10)
+	err := s.Each(context.Background(), q, func(p post.Post) error {
+		posts = append(posts, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// posts are read this many at a time
+const pageSize = 100
+
+// reads the posts a page at a time, each in its own read transaction, so
+// that none is open while `f` runs.  `f` may take long (e.g. writing to a
+// slow client), and an open read transaction keeps the file from shrinking
+// and its pages from being reused meanwhile.
+func (s *Store) Each(ctx context.Context, q query.Query, f func(p post.Post) error) error {
+	if q.Find != nil {
+		var posts []post.Post
+		err := s.db.View(func(tx *bbolt.Tx) (err error) {
+			posts, err = runFind(tx, q)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return eachPost(posts, f)
+	}
+
+	page := newPager(q)
+	for {
+		var posts []post.Post
+		err := s.db.View(func(tx *bbolt.Tx) (err error) {
+			posts, err = page.next(ctx, tx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		err = eachPost(posts, f)
+		if err != nil {
+			return err
+		}
+		if page.done {
+			return nil
+		}
+	}
+}
+
+func eachPost(posts []post.Post, f func(p post.Post) error) error {
+	for _, p := range posts {
+		err := f(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runFind(tx *bbolt.Tx, q query.Query) ([]post.Post, error) {
+	value, ok := q.Find.Value.(string)
+	if !ok {
+		return nil, storage.InvalidQuery(errors.New(fmt.Sprintf("%s must be a string", q.Find.Name)))
+	}
+
+	switch q.Find.Name {
+	case "id":
+		p, err := getPost(tx, []byte(value))
+		if err != nil {
+			return nil, err
+		}
+		return []post.Post{*p}, nil
+	case "title":
+		// the first post with that title
+		prefix := indexKey([]byte(value), "")
+		key, _ := tx.Bucket([]byte("title")).Cursor().Seek(prefix)
+		if key == nil || !bytes.HasPrefix(key, prefix) {
+			return []post.Post{}, nil
+		}
+		_, id := splitKey(key)
+		p, err := getPost(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		return []post.Post{*p}, nil
+	default:
+		return nil, storage.InvalidQuery(errors.New(fmt.Sprint("unsupported field: ", q.Find.Name)))
+	}
+}
+
+// reads the posts of a query a page at a time
+type pager struct {
+	q     query.Query
+	start int
+	count int
+	// the posts matched so far, including the ones before `start`
+	n int
+	// the index key of the last post read, nil for the first page
+	after []byte
+	// whether there are no more posts
+	done bool
+}
+
+func newPager(q query.Query) *pager {
+	start := 0
+	if q.Start != -1 {
+		start = q.Start
+	}
+	count := -1
+	if q.Count != -1 {
+		count = q.Count
+	}
+	return &pager{q: q, start: start, count: count, done: count == 0}
+}
+
+// walks the index for the sort order, from where the last page ended.  if
+// the range is for the same date, only the part of the index in the range
+// is read.
+func (pg *pager) next(ctx context.Context, tx *bbolt.Tx) ([]post.Post, error) {
+	q := pg.q
+	if pg.done {
+		return nil, nil
+	}
+
+	if _, ok := indexes[q.SortBy]; !ok {
+		return nil, storage.InvalidQuery(errors.New(fmt.Sprintf("sorting by %s not supported", q.SortBy)))
+	}
+	index := tx.Bucket([]byte(q.SortBy))
+
+	var from, to []byte
+	if q.SortBy == rangeBy(q) {
+		if q.RangeStart != nil {
+			from = timeKey(*q.RangeStart)
+		}
+		if q.RangeEnd != nil {
+			to = timeKey(*q.RangeEnd)
+		}
+	}
+
+	posts := make([]post.Post, 0, pageSize)
+	pg.done = true
+	var err error
+	walk(index, from, to, pg.after, q.Reverse, func(key, id []byte) bool {
+		err = ctx.Err()
+		if err != nil {
+			return false
+		}
+
+		if len(posts) == pageSize {
+			pg.done = false
+			return false
+		}
+		// keys are only valid in the transaction
+		pg.after = append(pg.after[:0], key...)
+
+		var p *post.Post
+		p, err = getPost(tx, id)
+		if err != nil {
+			return false
+		}
+
+		// conditions not covered by the index
+		if !memory.Matches(q, *p) {
+			return true
+		}
+		if pg.n >= pg.start {
+			posts = append(posts, *p)
+		}
+		pg.n += 1
+		return pg.count == -1 || pg.n < pg.start+pg.count
+	})
+	return posts, err
+}
+
+func rangeBy(q query.Query) string {
+	if q.RangeBy == "updated" {
+		return "updated"
+	}
+	return "created"
+}
+
+// calls `f` with the keys in `index` and their ids, in order (or
+// reversed), until it returns false.  `from` and `to` limit the indexed
+// values (both are inclusive), nil means no limit.  the walk starts after
+// the key `after` if it is not nil.
+func walk(index *bbolt.Bucket, from, to, after []byte, reverse bool, f func(key, id []byte) bool) {
+	c := index.Cursor()
+
+	inRange := func(key []byte) bool {
+		value, _ := splitKey(key)
+		if from != nil && bytes.Compare(value, from) < 0 {
+			return false
+		}
+		return to == nil || bytes.Compare(value, to) <= 0
+	}
+
+	var key []byte
+	if !reverse {
+		if after != nil {
+			key, _ = c.Seek(after)
+			if bytes.Equal(key, after) {
+				key, _ = c.Next()
+			}
+		} else if from != nil {
+			key, _ = c.Seek(from)
+		} else {
+			key, _ = c.First()
+		}
+		for ; key != nil && inRange(key); key, _ = c.Next() {
+			_, id := splitKey(key)
+			if !f(key, id) {
+				return
+			}
+		}
+	} else {
+		if after != nil {
+			// the key before `after`, which might be gone by now
+			key, _ = c.Seek(after)
+			if key == nil {
+				key, _ = c.Last()
+			} else {
+				key, _ = c.Prev()
+			}
+		} else if to != nil {
+			// the first key after all keys with the value `to`
+			key, _ = c.Seek(append(append([]byte{}, to...), 1))
+			if key == nil {
+				key, _ = c.Last()
+			} else {
+				key, _ = c.Prev()
+			}
+		} else {
+			key, _ = c.Last()
+		}
+		for ; key != nil && inRange(key); key, _ = c.Prev() {
+			_, id := splitKey(key)
+			if !f(key, id) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// the least recently used entries are evicted once there are `size` of
+// them, entries older than `ttl` are not used anymore (never if 0)
+type lru struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+	// increases with every invalidation, results read before that are
+	// not cached
+	generation uint64
+	stats      Stats
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// how well the cache works
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Size      int    `json:"size"`
+	// whether changes made elsewhere are seen right away, otherwise
+	// only after `ttl`
+	Watching bool `json:"watching"`
+}
+
+func newLru(size int, ttl time.Duration) *lru {
+	return &lru{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// the value for `key` and the generation to pass to `put` if there is none
+func (c *lru) get(key string) (interface{}, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if ok && c.ttl != 0 && time.Now().After(el.Value.(*entry).expires) {
+		c.removeElement(el)
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses += 1
+		return nil, c.generation, false
+	}
+
+	c.stats.Hits += 1
+	c.order.MoveToFront(el)
+	return el.Value.(*entry).value, c.generation, true
+}
+
+// keeps `value`, unless something was invalidated since `generation`
+func (c *lru) put(key string, value interface{}, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation || c.size == 0 {
+		return
+	}
+
+	e := &entry{key, value, time.Now().Add(c.ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+		c.stats.Evictions += 1
+	}
+}
+
+// removes the entries for which `matches` is true
+func (c *lru) invalidate(matches func(key string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation += 1
+	for key, el := range c.entries {
+		if matches(key) {
+			c.removeElement(el)
+		}
+	}
+}
+
+func (c *lru) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
+
+func (c *lru) setWatching(watching bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Watching = watching
+}
+
+func (c *lru) statistics() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	stats.Size = c.size
+	return stats
+}
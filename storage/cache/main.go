@@ -0,0 +1,310 @@
+// a store that keeps what another one found in memory
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	storage ".."
+	"../../post"
+	"../query"
+)
+
+type Backend struct{}
+
+// `cache://?backend=<url>` caches the results of `Find` and `FindById` of
+// the backend in an lru:
+//
+//	?size=1000   entries at most
+//	?ttl=1m      how long entries are used, 0 for as long as they are valid
+//
+// changes made through the cache invalidate the entries they affect: the
+// post itself and all queries.  changes made elsewhere are seen with the
+// change feed of the backend, if it has one, and otherwise once the
+// entries expired.
+type Store struct {
+	backend storage.Store
+	cache   *lru
+	// stops watching the backend
+	cancel context.CancelFunc
+}
+
+func init() {
+	storage.Register("cache", Backend{})
+}
+
+func (b Backend) Open(u *url.URL) (storage.Store, error) {
+	params := u.Query()
+	backendUrl := params.Get("backend")
+	if backendUrl == "" {
+		return nil, errors.New("no backend store specified")
+	}
+
+	size := 1000
+	if s := params.Get("size"); s != "" {
+		var err error
+		size, err = strconv.Atoi(s)
+		if err != nil || size < 0 {
+			return nil, errors.New(fmt.Sprintf("invalid cache size: %#v", s))
+		}
+	}
+	ttl := time.Minute
+	if t := params.Get("ttl"); t != "" {
+		var err error
+		ttl, err = time.ParseDuration(t)
+		if err != nil || ttl < 0 {
+			return nil, errors.New(fmt.Sprintf("invalid ttl: %#v", t))
+		}
+	}
+
+	backend, err := storage.Open(backendUrl)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error opening backend store '%s': %s", storage.Redact(backendUrl), err))
+	}
+	return storage.Store(New(backend, size, ttl)), nil
+}
+
+// caches `backend`, which is closed along with the cache
+func New(backend storage.Store, size int, ttl time.Duration) *Store {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Store{
+		backend: backend,
+		cache:   newLru(size, ttl),
+		cancel:  cancel,
+	}
+	if watcher, ok := storage.CanWatch(backend); ok {
+		s.cache.setWatching(true)
+		// before returning, so that no change is missed
+		events := watcher.Watch(ctx)
+		go s.watch(ctx, watcher, events)
+	}
+	return s
+}
+
+// invalidates the entries changed elsewhere, until `ctx` is done
+func (s *Store) watch(ctx context.Context, watcher storage.Watcher, events <-chan storage.Event) {
+	for {
+		var seq uint64
+		for e := range events {
+			seq = e.Seq
+			s.invalidate(e.Id)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if seq == 0 {
+			// closed right away, the backend was closed
+			log.Println("Error: stopped watching the cached store, changes made elsewhere are seen after the ttl")
+			s.cache.setWatching(false)
+			return
+		}
+
+		// too slow, changes might have been missed
+		s.cache.invalidate(func(string) bool { return true })
+		var err error
+		events, err = watcher.WatchFrom(ctx, seq)
+		if err != nil {
+			events = watcher.Watch(ctx)
+		}
+	}
+}
+
+// the entries a change of the post with `id` affects
+func (s *Store) invalidate(id string) {
+	s.cache.invalidate(func(key string) bool {
+		return key == idKey(id) || strings.HasPrefix(key, "find:")
+	})
+}
+
+func idKey(id string) string {
+	return "id:" + id
+}
+
+func queryKey(q query.Query) string {
+	var find interface{}
+	if q.Find != nil {
+		find = *q.Find
+	}
+	var start, end interface{}
+	if q.RangeStart != nil {
+		start = q.RangeStart.UnixNano()
+	}
+	if q.RangeEnd != nil {
+		end = q.RangeEnd.UnixNano()
+	}
+	return fmt.Sprintf("find:%#v %d %d %#v %v %v %s %s %t", find, q.Start, q.Count, q.Matches, start, end, q.RangeBy, q.SortBy, q.Reverse)
+}
+
+// the hits and misses so far
+func (s *Store) Stats() Stats {
+	return s.cache.statistics()
+}
+
+func (s *Store) Find(q query.Query) ([]post.Post, error) {
+	return s.FindContext(context.Background(), q)
+}
+
+// the posts are copied, callers may change them
+func (s *Store) FindContext(ctx context.Context, q query.Query) ([]post.Post, error) {
+	key := queryKey(q)
+	cached, generation, ok := s.cache.get(key)
+	if ok {
+		return append([]post.Post(nil), cached.([]post.Post)...), nil
+	}
+
+	posts, err := storage.WithContext(s.backend).FindContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.put(key, append([]post.Post(nil), posts...), generation)
+	return posts, nil
+}
+
+func (s *Store) FindById(id string) (*post.Post, error) {
+	return s.FindByIdContext(context.Background(), id)
+}
+
+func (s *Store) FindByIdContext(ctx context.Context, id string) (*post.Post, error) {
+	cached, generation, ok := s.cache.get(idKey(id))
+	if ok {
+		p := cached.(post.Post)
+		return &p, nil
+	}
+
+	p, err := storage.WithContext(s.backend).FindByIdContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.put(idKey(id), *p, generation)
+	return p, nil
+}
+
+func (s *Store) FindAll() ([]post.Post, error) {
+	return s.FindAllContext(context.Background())
+}
+
+func (s *Store) FindAllContext(ctx context.Context) ([]post.Post, error) {
+	key := "find:all"
+	cached, generation, ok := s.cache.get(key)
+	if ok {
+		return append([]post.Post(nil), cached.([]post.Post)...), nil
+	}
+
+	posts, err := storage.WithContext(s.backend).FindAllContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.put(key, append([]post.Post(nil), posts...), generation)
+	return posts, nil
+}
+
+// from the cache if the query is, otherwise streamed from the backend
+// without caching, there might be a lot of posts
+func (s *Store) Each(ctx context.Context, q query.Query, f func(p post.Post) error) error {
+	cached, _, ok := s.cache.get(queryKey(q))
+	if !ok {
+		return storage.Each(ctx, s.backend, q, f)
+	}
+
+	for _, p := range cached.([]post.Post) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := f(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// not cached
+func (s *Store) Aggregate(by string, q query.Query) ([]storage.Count, error) {
+	return s.AggregateContext(context.Background(), by, q)
+}
+
+func (s *Store) AggregateContext(ctx context.Context, by string, q query.Query) ([]storage.Count, error) {
+	return storage.AggregateContext(ctx, s.backend, by, q)
+}
+
+// changes invalidate the cache even if they failed, they might have been
+// made anyway
+func (s *Store) Create(p post.Post) error {
+	return s.CreateContext(context.Background(), p)
+}
+
+func (s *Store) CreateContext(ctx context.Context, p post.Post) error {
+	defer s.invalidate(p.Id)
+	return storage.WithContext(s.backend).CreateContext(ctx, p)
+}
+
+func (s *Store) Update(p post.Post) error {
+	return s.UpdateContext(context.Background(), p)
+}
+
+func (s *Store) UpdateContext(ctx context.Context, p post.Post) error {
+	defer s.invalidate(p.Id)
+	return storage.WithContext(s.backend).UpdateContext(ctx, p)
+}
+
+func (s *Store) Delete(id string) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *Store) DeleteContext(ctx context.Context, id string) error {
+	defer s.invalidate(id)
+	return storage.WithContext(s.backend).DeleteContext(ctx, id)
+}
+
+func (s *Store) Batch(ctx context.Context, ops []storage.Op) error {
+	defer func() {
+		for _, op := range ops {
+			s.invalidate(op.PostId())
+		}
+	}()
+	return storage.Batch(ctx, s.backend, ops)
+}
+
+// whether the backend can be watched, the store only tells about changes
+// if it can
+func (s *Store) Watchable() bool {
+	_, ok := storage.CanWatch(s.backend)
+	return ok
+}
+
+// the changes of the backend
+func (s *Store) Watch(ctx context.Context) <-chan storage.Event {
+	watcher, ok := storage.CanWatch(s.backend)
+	if !ok {
+		events := make(chan storage.Event)
+		close(events)
+		return events
+	}
+	return watcher.Watch(ctx)
+}
+
+func (s *Store) WatchFrom(ctx context.Context, seq uint64) (<-chan storage.Event, error) {
+	watcher, ok := storage.CanWatch(s.backend)
+	if !ok {
+		return nil, errors.New("the cached store can not be watched")
+	}
+	return watcher.WatchFrom(ctx, seq)
+}
+
+// the cache, making changes to the backend as `user`.  it shares the
+// entries with `s`.
+func (s *Store) As(user string) storage.Store {
+	c := *s
+	c.backend = storage.As(s.backend, user)
+	return &c
+}
+
+func (s *Store) Close() error {
+	s.cancel()
+	return s.backend.Close()
+}
@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	storage ".."
+	"../../post"
+	tu "../../util/testing"
+	"../memory"
+	"../query"
+)
+
+// a store that can't be watched, counting the reads
+type countingStore struct {
+	posts *memory.Store
+	reads int
+}
+
+func newCountingStore() *countingStore {
+	return &countingStore{posts: &memory.Store{}}
+}
+
+func (s *countingStore) Find(q query.Query) ([]post.Post, error) {
+	s.reads += 1
+	return s.posts.Find(q)
+}
+
+func (s *countingStore) FindById(id string) (*post.Post, error) {
+	s.reads += 1
+	return s.posts.FindById(id)
+}
+
+func (s *countingStore) FindAll() ([]post.Post, error) {
+	s.reads += 1
+	return s.posts.FindAll()
+}
+
+func (s *countingStore) Create(p post.Post) error { return s.posts.Create(p) }
+func (s *countingStore) Update(p post.Post) error { return s.posts.Update(p) }
+func (s *countingStore) Delete(id string) error   { return s.posts.Delete(id) }
+func (s *countingStore) Close() error             { return nil }
+
+func titleQuery(t *testing.T, title string) query.Query {
+	q, err := storage.Query().Find("title", title).Build()
+	tu.RequireNil(t, err)
+	return *q
+}
+
+func TestOpen(t *testing.T) {
+	s, err := storage.Open("cache://?backend=memory://&size=10&ttl=1s")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, s.(*Store).Stats().Size, 10)
+	tu.ExpectNil(t, s.Close())
+
+	for _, rawUrl := range []string{
+		"cache://",
+		"cache://?backend=nosuchbackend://",
+		"cache://?backend=memory://&size=-1",
+		"cache://?backend=memory://&ttl=soon",
+	} {
+		_, err = storage.Open(rawUrl)
+		tu.ExpectNotNil(t, err)
+	}
+}
+
+func TestHitsAndMisses(t *testing.T) {
+	backend := newCountingStore()
+	s := New(backend, 10, 0)
+	defer s.Close()
+	tu.RequireNil(t, s.Create(post.Post{Id: "1", Title: "cats"}))
+
+	for i := 0; i < 3; i++ {
+		p, err := s.FindById("1")
+		tu.RequireNil(t, err)
+		tu.ExpectEqual(t, p.Title, "cats")
+		posts, err := s.Find(titleQuery(t, "cats"))
+		tu.RequireNil(t, err)
+		tu.ExpectEqual(t, len(posts), 1)
+	}
+	tu.ExpectEqual(t, backend.reads, 2)
+
+	// errors are not cached
+	_, err := s.FindById("2")
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrNotFound), true)
+	_, err = s.FindById("2")
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrNotFound), true)
+	tu.ExpectEqual(t, backend.reads, 4)
+
+	// changing what was found doesn't change the cache
+	p, _ := s.FindById("1")
+	p.Title = "dogs"
+	p, _ = s.FindById("1")
+	tu.ExpectEqual(t, p.Title, "cats")
+
+	stats := s.Stats()
+	tu.ExpectEqual(t, stats.Hits, uint64(6))
+	tu.ExpectEqual(t, stats.Misses, uint64(4))
+	tu.ExpectEqual(t, stats.Entries, 2)
+	tu.ExpectEqual(t, stats.Watching, false)
+}
+
+func TestWritesInvalidate(t *testing.T) {
+	backend := newCountingStore()
+	s := New(backend, 10, 0)
+	defer s.Close()
+	tu.RequireNil(t, s.Create(post.Post{Id: "1", Title: "cats"}))
+	tu.RequireNil(t, s.Create(post.Post{Id: "2", Title: "dogs"}))
+	s.FindById("1")
+	s.FindById("2")
+	s.Find(titleQuery(t, "birds"))
+
+	tu.RequireNil(t, s.Create(post.Post{Id: "3", Title: "birds"}))
+	posts, _ := s.Find(titleQuery(t, "birds"))
+	tu.ExpectEqual(t, len(posts), 1)
+
+	tu.RequireNil(t, s.Update(post.Post{Id: "1", Title: "kittens"}))
+	p, _ := s.FindById("1")
+	tu.ExpectEqual(t, p.Title, "kittens")
+
+	tu.RequireNil(t, s.Batch(context.Background(), []storage.Op{{Op: storage.OpDelete, Id: "3"}}))
+	posts, _ = s.Find(titleQuery(t, "birds"))
+	tu.ExpectEqual(t, len(posts), 0)
+
+	// other posts stay cached
+	reads := backend.reads
+	s.FindById("2")
+	tu.ExpectEqual(t, backend.reads, reads)
+}
+
+func TestChangeFeedInvalidates(t *testing.T) {
+	backend := &memory.Store{}
+	s := New(backend, 10, 0)
+	defer s.Close()
+	tu.ExpectEqual(t, s.Stats().Watching, true)
+
+	tu.RequireNil(t, backend.Create(post.Post{Id: "1", Title: "cats"}))
+	s.FindById("1")
+
+	// changed without the cache
+	tu.RequireNil(t, backend.Update(post.Post{Id: "1", Title: "kittens"}))
+	for i := 0; i < 500; i++ {
+		p, _ := s.FindById("1")
+		if p.Title == "kittens" {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("the change was not seen")
+}
+
+func TestWatchable(t *testing.T) {
+	s := New(&memory.Store{}, 10, 0)
+	defer s.Close()
+	_, ok := storage.CanWatch(s)
+	tu.ExpectEqual(t, ok, true)
+
+	// the cache implements `Watcher` either way
+	s = New(newCountingStore(), 10, 0)
+	defer s.Close()
+	_, ok = storage.CanWatch(s)
+	tu.ExpectEqual(t, ok, false)
+	_, err := s.WatchFrom(context.Background(), 0)
+	tu.ExpectNotNil(t, err)
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	backend := newCountingStore()
+	s := New(backend, 2, 0)
+	defer s.Close()
+	for _, id := range []string{"1", "2", "3"} {
+		tu.RequireNil(t, backend.Create(post.Post{Id: id}))
+	}
+
+	s.FindById("1")
+	s.FindById("2")
+	s.FindById("1")
+	s.FindById("3")
+	reads := backend.reads
+
+	s.FindById("1")
+	s.FindById("3")
+	tu.ExpectEqual(t, backend.reads, reads)
+	s.FindById("2")
+	tu.ExpectEqual(t, backend.reads, reads+1)
+	tu.ExpectEqual(t, s.Stats().Evictions, uint64(2))
+}
+
+func TestExpires(t *testing.T) {
+	backend := newCountingStore()
+	s := New(backend, 10, 10*time.Millisecond)
+	defer s.Close()
+	tu.RequireNil(t, backend.Create(post.Post{Id: "1", Title: "cats"}))
+
+	s.FindById("1")
+	tu.RequireNil(t, backend.Update(post.Post{Id: "1", Title: "kittens"}))
+	p, _ := s.FindById("1")
+	tu.ExpectEqual(t, p.Title, "cats")
+
+	time.Sleep(20 * time.Millisecond)
+	p, _ = s.FindById("1")
+	tu.ExpectEqual(t, p.Title, "kittens")
+	tu.ExpectEqual(t, s.Stats().Entries, 1)
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+
+	"../post"
+	"./query"
+)
+
+// a store whose operations stop when `ctx` is done, e.g. because the
+// client of a request went away.  they return `ctx.Err()` then.
+//
+// get one for any store with `WithContext`.
+type ContextStore interface {
+	Store
+
+	FindContext(ctx context.Context, q query.Query) ([]post.Post, error)
+	FindByIdContext(ctx context.Context, id string) (*post.Post, error)
+	FindAllContext(ctx context.Context) ([]post.Post, error)
+
+	CreateContext(ctx context.Context, p post.Post) error
+	UpdateContext(ctx context.Context, p post.Post) error
+	DeleteContext(ctx context.Context, id string) error
+}
+
+// implemented by stores that can count posts themselves and stop doing
+// so when `ctx` is done
+type ContextAggregator interface {
+	AggregateContext(ctx context.Context, by string, q query.Query) ([]Count, error)
+}
+
+// `s` itself if it supports contexts, otherwise `s` wrapped so that
+// callers stop waiting for it when `ctx` is done.  the wrapped operation
+// still runs to the end in that case, a change might still be made.
+func WithContext(s Store) ContextStore {
+	if cs, ok := s.(ContextStore); ok {
+		return cs
+	}
+	return contextStore{s}
+}
+
+type contextStore struct {
+	Store
+}
+
+type result struct {
+	value interface{}
+	err   error
+}
+
+// the result of `f`, or `ctx.Err()` if `ctx` is done first
+func wait(ctx context.Context, f func() (interface{}, error)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make(chan result, 1)
+	go func() {
+		value, err := f()
+		results <- result{value, err}
+	}()
+
+	select {
+	case r := <-results:
+		return r.value, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s contextStore) FindContext(ctx context.Context, q query.Query) ([]post.Post, error) {
+	posts, err := wait(ctx, func() (interface{}, error) {
+		return s.Find(q)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posts.([]post.Post), nil
+}
+
+func (s contextStore) FindByIdContext(ctx context.Context, id string) (*post.Post, error) {
+	p, err := wait(ctx, func() (interface{}, error) {
+		return s.FindById(id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p.(*post.Post), nil
+}
+
+func (s contextStore) FindAllContext(ctx context.Context) ([]post.Post, error) {
+	posts, err := wait(ctx, func() (interface{}, error) {
+		return s.FindAll()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posts.([]post.Post), nil
+}
+
+func (s contextStore) CreateContext(ctx context.Context, p post.Post) error {
+	_, err := wait(ctx, func() (interface{}, error) {
+		return nil, s.Create(p)
+	})
+	return err
+}
+
+func (s contextStore) UpdateContext(ctx context.Context, p post.Post) error {
+	_, err := wait(ctx, func() (interface{}, error) {
+		return nil, s.Update(p)
+	})
+	return err
+}
+
+func (s contextStore) DeleteContext(ctx context.Context, id string) error {
+	_, err := wait(ctx, func() (interface{}, error) {
+		return nil, s.Delete(id)
+	})
+	return err
+}
+
+// like `Aggregate`, but stops when `ctx` is done
+func AggregateContext(ctx context.Context, s Store, by string, q query.Query) ([]Count, error) {
+	if err := validGrouping(by); err != nil {
+		return nil, err
+	}
+
+	if a, ok := s.(ContextAggregator); ok {
+		return a.AggregateContext(ctx, by, q)
+	}
+	if _, ok := s.(Aggregator); ok {
+		counts, err := wait(ctx, func() (interface{}, error) {
+			return Aggregate(s, by, q)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return counts.([]Count), nil
+	}
+
+	posts, err := WithContext(s).FindContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return CountPosts(posts, by)
+}
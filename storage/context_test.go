@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"../post"
+	tu "../util/testing"
+	"./query"
+)
+
+// a store that answers when `release` is closed
+type slowStore struct {
+	release chan bool
+	created chan post.Post
+}
+
+func newSlowStore() *slowStore {
+	return &slowStore{make(chan bool), make(chan post.Post, 1)}
+}
+
+func (s *slowStore) Find(q query.Query) ([]post.Post, error) {
+	<-s.release
+	return []post.Post{{Id: "1"}}, nil
+}
+
+func (s *slowStore) FindById(id string) (*post.Post, error) {
+	<-s.release
+	return nil, NotFoundError{Id: id}
+}
+
+func (s *slowStore) FindAll() ([]post.Post, error) {
+	return s.Find(query.Query{})
+}
+
+func (s *slowStore) Create(p post.Post) error {
+	<-s.release
+	s.created <- p
+	return nil
+}
+
+func (s *slowStore) Update(p post.Post) error { return nil }
+func (s *slowStore) Delete(id string) error   { return nil }
+func (s *slowStore) Close() error             { return nil }
+
+func TestWithContext(t *testing.T) {
+	s := newSlowStore()
+	cs := WithContext(s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := cs.FindContext(ctx, query.Query{})
+	tu.ExpectEqual(t, err, context.DeadlineExceeded)
+
+	// not even started
+	_, err = cs.FindByIdContext(ctx, "1")
+	tu.ExpectEqual(t, err, context.DeadlineExceeded)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go cancel()
+	tu.ExpectEqual(t, cs.CreateContext(ctx, post.Post{Id: "2"}), context.Canceled)
+
+	// the change is made anyway
+	close(s.release)
+	tu.ExpectEqual(t, (<-s.created).Id, "2")
+
+	posts, err := cs.FindContext(context.Background(), query.Query{})
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(posts), 1)
+	_, err = cs.FindByIdContext(context.Background(), "3")
+	tu.ExpectEqual(t, errors.Is(err, ErrNotFound), true)
+
+	// already supports contexts
+	tu.ExpectEqual(t, WithContext(cs), cs)
+}
+
+func TestAggregateContext(t *testing.T) {
+	s := newSlowStore()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := AggregateContext(ctx, s, ByMonth, query.Query{})
+	tu.ExpectEqual(t, err, context.DeadlineExceeded)
+
+	_, err = AggregateContext(context.Background(), s, "color", query.Query{})
+	tu.ExpectEqual(t, errors.Is(err, ErrInvalidQuery), true)
+
+	close(s.release)
+	counts, err := AggregateContext(context.Background(), s, ByMonth, query.Query{})
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(counts), 1)
+}
@@ -0,0 +1,314 @@
+package dir
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"../../post"
+)
+
+// posts are written with yaml front matter:
+//
+//	---
+//	id: "01DXF6DT000000000000000000"
+//	title: "Hello, World!"
+//	created: 2015-09-14T10:00:00Z
+//	slug: "hello-world"
+//	tags: ["intro"]
+//	---
+//
+//	the content, with #intro as a hashtag.
+//
+// toml front matter (between `+++` lines, with `key = value`) can be read as
+// well.  only the subset of both formats needed for the fields of a post is
+// supported: strings, dates and lists of strings.
+//
+// `tags` are written for readers of the files only, tags are always derived
+// from the hashtags in the post.  unknown keys are ignored.
+
+// the post as a markdown file with front matter
+func Marshal(p post.Post) []byte {
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	writeField(&b, "id", strconv.Quote(p.Id))
+	writeField(&b, "title", strconv.Quote(p.Title))
+	writeField(&b, "created", p.Created.Format(time.RFC3339Nano))
+	if p.Edited() {
+		writeField(&b, "updated", p.Updated.Format(time.RFC3339Nano))
+	}
+	if p.UpdatedBy != "" {
+		writeField(&b, "updated_by", strconv.Quote(p.UpdatedBy))
+	}
+	if p.Slug != "" {
+		writeField(&b, "slug", strconv.Quote(p.Slug))
+	}
+	if len(p.OldSlugs) > 0 {
+		writeField(&b, "old_slugs", quoteList(p.OldSlugs))
+	}
+	if tags := p.Tags(); len(tags) > 0 {
+		writeField(&b, "tags", quoteList(tags))
+	}
+	b.WriteString("---\n\n")
+	b.WriteString(p.Content)
+	// like editors do, so that adding to the last line doesn't change it
+	// for diffs and blame.  `Unmarshal` drops it again.
+	b.WriteString("\n")
+	return b.Bytes()
+}
+
+func writeField(b *bytes.Buffer, key, value string) {
+	b.WriteString(key)
+	b.WriteString(": ")
+	b.WriteString(value)
+	b.WriteString("\n")
+}
+
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// reads a post written by `Marshal` or by hand.  fields missing from the
+// front matter are left as they are in `p`.
+func Unmarshal(data []byte, p *post.Post) error {
+	text := strings.Replace(string(data), "\r\n", "\n", -1)
+
+	var delim, sep string
+	switch {
+	case strings.HasPrefix(text, "---\n"):
+		delim, sep = "---", ":"
+	case strings.HasPrefix(text, "+++\n"):
+		delim, sep = "+++", "="
+	default:
+		// no front matter at all
+		p.Content = strings.TrimSuffix(text, "\n")
+		return nil
+	}
+
+	rest := text[len(delim)+1:]
+	var header string
+	if strings.HasPrefix(rest, delim+"\n") || rest == delim {
+		header, rest = "", strings.TrimPrefix(rest[len(delim):], "\n")
+	} else {
+		end := strings.Index(rest, "\n"+delim+"\n")
+		if end == -1 {
+			if !strings.HasSuffix(rest, "\n"+delim) {
+				return errors.New("front matter is not closed")
+			}
+			end = len(rest) - len(delim) - 1
+		}
+		header = rest[:end]
+		rest = rest[end+len(delim)+1:]
+		rest = strings.TrimPrefix(rest, "\n")
+	}
+	// `Marshal` separates the content with an empty line and ends it with
+	// a newline
+	p.Content = strings.TrimSuffix(strings.TrimPrefix(rest, "\n"), "\n")
+
+	fields, err := parseFields(header, sep)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range fields {
+		switch key {
+		case "id":
+			p.Id = value.String()
+		case "title":
+			p.Title = value.String()
+		case "created":
+			p.Created, err = parseTime(value.String())
+		case "updated":
+			p.Updated, err = parseTime(value.String())
+		case "updated_by":
+			p.UpdatedBy = value.String()
+		case "slug":
+			p.Slug = value.String()
+		case "old_slugs":
+			p.OldSlugs = value.list
+		}
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid %s: %s", key, err))
+		}
+	}
+
+	return nil
+}
+
+// a string or a list of strings
+type fieldValue struct {
+	str    string
+	list   []string
+	isList bool
+}
+
+func (v fieldValue) String() string {
+	if v.isList {
+		return strings.Join(v.list, ", ")
+	}
+	return v.str
+}
+
+func parseFields(header string, sep string) (map[string]fieldValue, error) {
+	fields := make(map[string]fieldValue)
+	lines := strings.Split(header, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		parts := strings.SplitN(line, sep, 2)
+		if len(parts) != 2 {
+			return nil, errors.New(fmt.Sprintf("line %d: expected `key%s value`", i+1, sep))
+		}
+		key := strings.TrimSpace(parts[0])
+		raw := strings.TrimSpace(parts[1])
+
+		var value fieldValue
+		var err error
+		switch {
+		case raw == "" && sep == ":":
+			// a yaml list with one `- item` per line
+			value.isList = true
+			for i+1 < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i+1]), "-") {
+				i++
+				var item string
+				item, err = parseScalar(strings.TrimSpace(strings.TrimSpace(lines[i])[1:]))
+				if err != nil {
+					break
+				}
+				value.list = append(value.list, item)
+			}
+		case strings.HasPrefix(raw, "["):
+			value.isList = true
+			value.list, err = parseList(raw)
+		default:
+			value.str, err = parseScalar(raw)
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %d: %s", i+1, err))
+		}
+
+		fields[key] = value
+	}
+
+	return fields, nil
+}
+
+// a quoted or a plain string.  comments after plain strings are removed.
+func parseScalar(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+
+	switch raw[0] {
+	case '"':
+		end := closingQuote(raw)
+		if end == -1 {
+			return "", errors.New("unterminated string")
+		}
+		return strconv.Unquote(raw[:end+1])
+	case '\'':
+		// single quotes are escaped by doubling them in yaml, toml
+		// doesn't allow them in literal strings at all
+		var s bytes.Buffer
+		for i := 1; i < len(raw); i++ {
+			if raw[i] == '\'' {
+				if i+1 < len(raw) && raw[i+1] == '\'' {
+					s.WriteByte('\'')
+					i++
+					continue
+				}
+				return s.String(), nil
+			}
+			s.WriteByte(raw[i])
+		}
+		return "", errors.New("unterminated string")
+	}
+
+	if i := strings.Index(raw, " #"); i != -1 {
+		raw = raw[:i]
+	}
+	return strings.TrimSpace(raw), nil
+}
+
+// the index of the quote ending the double-quoted string at the start of
+// `raw`, or -1
+func closingQuote(raw string) int {
+	for i := 1; i < len(raw); i++ {
+		switch raw[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// a flow list like `[a, "b", 'c']`
+func parseList(raw string) ([]string, error) {
+	end := strings.LastIndex(raw, "]")
+	if end == -1 {
+		return nil, errors.New("unterminated list")
+	}
+	inner := strings.TrimSpace(raw[1:end])
+
+	items := []string{}
+	for inner != "" {
+		var n int
+		switch inner[0] {
+		case '"':
+			n = closingQuote(inner) + 1
+		case '\'':
+			n = strings.Index(inner[1:], "'") + 2
+		default:
+			n = strings.Index(inner, ",")
+			if n == -1 {
+				n = len(inner)
+			}
+		}
+		if n <= 0 {
+			return nil, errors.New("unterminated string")
+		}
+
+		item, err := parseScalar(strings.TrimSpace(inner[:n]))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		inner = strings.TrimSpace(inner[n:])
+		inner = strings.TrimSpace(strings.TrimPrefix(inner, ","))
+	}
+	return items, nil
+}
+
+var timeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// dates without a timezone are in utc
+func parseTime(s string) (time.Time, error) {
+	for _, format := range timeFormats {
+		t, err := time.Parse(format, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New(fmt.Sprintf("unknown date format: %q", s))
+}
@@ -0,0 +1,416 @@
+package dir
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	storage ".."
+	"../../post"
+	"../../util/file"
+	"../memory"
+	"../query"
+)
+
+type Backend struct{}
+
+// each post is a markdown file named `YYYY-MM-DD-slug.md` in the directory,
+// see `./frontmatter.go` for the format.  other files are ignored.  the posts are kept in memory for
+// `Find`.
+//
+// the directory is checked for changes every two seconds (or as set with
+// `?poll=<duration>`, `?poll=0` disables it), so that files edited, added
+// or removed by hand show up without restarting gol.
+//
+// files are renamed when the slug of their post changes, unless
+// `?rename=false` is given (the slug is in the front matter either way).
+type Store struct {
+	// changes by gol and by the watcher are applied one at a time
+	mu    sync.Mutex
+	path  string
+	files map[string]fileState // by file name
+	names map[string]string    // file names by post id
+	index *memory.Store
+
+	// whether the name follows the slug
+	rename bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// what the file looked like when it was last read or written
+type fileState struct {
+	// empty if the file was skipped
+	id      string
+	modTime time.Time
+	size    int64
+}
+
+func init() {
+	storage.Register("dir", Backend{})
+}
+
+func (b Backend) Open(u *url.URL) (storage.Store, error) {
+	path := u.Host + u.Path
+	if path == "" {
+		return nil, errors.New("dir: no directory given, use dir://path/to/posts")
+	}
+
+	interval := 2 * time.Second
+	if poll := u.Query().Get("poll"); poll != "" {
+		var err error
+		interval, err = time.ParseDuration(poll)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("dir: invalid poll interval %q: %s", poll, err))
+		}
+	}
+
+	rename := true
+	if r := u.Query().Get("rename"); r != "" {
+		var err error
+		rename, err = strconv.ParseBool(r)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("dir: invalid value for rename %q", r))
+		}
+	}
+
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		path:   path,
+		rename: rename,
+		files:  make(map[string]fileState),
+		names:  make(map[string]string),
+		index:  memory.FromPosts(nil),
+	}
+	err = s.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	if interval > 0 {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.watch(interval, s.stop)
+	}
+
+	return storage.Store(s), nil
+}
+
+func (s *Store) watch(interval time.Duration, stop chan struct{}) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			err := s.scan()
+			s.mu.Unlock()
+			if err != nil {
+				log.Printf("dir: could not check %s for changes: %s", s.path, err)
+			}
+		}
+	}
+}
+
+// brings the index up to date with the files in the directory.  files that
+// can't be read are skipped (and logged), they are tried again once they
+// have changed.
+func (s *Store) scan() error {
+	infos, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		return err
+	}
+
+	present := make(map[string]os.FileInfo)
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !isPostFile(name) {
+			continue
+		}
+		present[name] = info
+	}
+
+	// removals first, a renamed file keeps its id
+	for name, state := range s.files {
+		if _, ok := present[name]; !ok {
+			s.forget(name, state)
+		}
+	}
+
+	for name, info := range present {
+		state, known := s.files[name]
+		if known && state.modTime.Equal(info.ModTime()) && state.size == info.Size() {
+			continue
+		}
+		if known {
+			s.forget(name, state)
+		}
+
+		skipped := fileState{modTime: info.ModTime(), size: info.Size()}
+
+		p, err := readPost(filepath.Join(s.path, name))
+		if err != nil {
+			log.Printf("dir: skipping %s: %s", name, err)
+			s.files[name] = skipped
+			continue
+		}
+
+		if other, ok := s.names[p.Id]; ok {
+			log.Printf("dir: skipping %s: it has the same id as %s", name, other)
+			s.files[name] = skipped
+			continue
+		}
+
+		s.put(name, info, *p)
+	}
+
+	return nil
+}
+
+// removes the post in the file `name` from the index
+func (s *Store) forget(name string, state fileState) {
+	delete(s.files, name)
+	if state.id == "" || s.names[state.id] != name {
+		return
+	}
+	delete(s.names, state.id)
+	s.index.Delete(state.id)
+
+	// a skipped file might have had the same id
+	for name, state := range s.files {
+		if state.id == "" {
+			delete(s.files, name)
+		}
+	}
+}
+
+// adds or replaces the post in the index
+func (s *Store) put(name string, info os.FileInfo, p post.Post) {
+	s.index.Put(p)
+	s.names[p.Id] = name
+	s.files[name] = fileState{
+		id:      p.Id,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+}
+
+// only files named like posts are read, others (like a README) are left
+// alone
+func isPostFile(name string) bool {
+	if filepath.Ext(name) != ".md" || len(name) < len("2006-01-02-.md") || name[10] != '-' {
+		return false
+	}
+	_, err := time.Parse("2006-01-02", name[:10])
+	return err == nil
+}
+
+// files without front matter get their id, date and title from the name
+func readPost(path string) (*post.Post, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), ".md")
+	created, _ := time.Parse("2006-01-02", name[:10])
+	p := post.Post{
+		Id:      name,
+		Title:   strings.Replace(name[11:], "-", " ", -1),
+		Created: created,
+	}
+
+	err = Unmarshal(data, &p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Id == "" {
+		return nil, errors.New("empty id")
+	}
+	return &p, nil
+}
+
+// e.g. 2015-09-14-introducing-the-query-interface.md (the date is in utc)
+func FileName(p post.Post) string {
+	return fmt.Sprintf("%s-%s.md", p.Created.UTC().Format("2006-01-02"), cleanSlug(p.PermalinkSlug()))
+}
+
+// slugs are not necessarily made by `post.Slugify` (e.g. encrypted ones),
+// so everything that is not allowed in file names becomes a dash
+func cleanSlug(slug string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '-'
+		}
+		return r
+	}, slug)
+}
+
+// `name`, or `name` with a number appended if another file has that name.
+// `own` is the current name of the file of the post, if any.
+func (s *Store) uniqueName(name string, own string) string {
+	base := strings.TrimSuffix(name, ".md")
+	for i := 2; ; i++ {
+		if name == own {
+			return name
+		}
+		_, known := s.files[name]
+		_, err := os.Stat(filepath.Join(s.path, name))
+		if !known && os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d.md", base, i)
+	}
+}
+
+// the name of the file of the post in the directory
+func (s *Store) FileOf(id string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name, ok := s.names[id]
+	return name, ok
+}
+
+func (s *Store) write(name string, p post.Post) (os.FileInfo, error) {
+	path := filepath.Join(s.path, name)
+	err := file.WriteAtomic(path, Marshal(p), 0644)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(path)
+}
+
+func (s *Store) Find(q query.Query) ([]post.Post, error) {
+	return s.index.Find(q)
+}
+
+func (s *Store) Aggregate(by string, q query.Query) ([]storage.Count, error) {
+	return s.index.Aggregate(by, q)
+}
+
+func (s *Store) FindById(id string) (*post.Post, error) {
+	return s.index.FindById(id)
+}
+
+func (s *Store) FindAll() ([]post.Post, error) {
+	return s.index.FindAll()
+}
+
+func (s *Store) Create(p post.Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.names[p.Id]; ok {
+		return storage.ConflictError{Id: p.Id}
+	}
+	if s.index.SlugTaken(p) {
+		return storage.ConflictError{Id: p.Id, Slug: p.Slug}
+	}
+
+	name := s.uniqueName(FileName(p), "")
+	info, err := s.write(name, p)
+	if err != nil {
+		return err
+	}
+
+	s.put(name, info, p)
+	return nil
+}
+
+func (s *Store) Update(updatedPost post.Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name, ok := s.names[updatedPost.Id]
+	if !ok {
+		return storage.NotFoundError{Id: updatedPost.Id}
+	}
+	old, err := s.index.FindById(updatedPost.Id)
+	if err != nil {
+		return err
+	}
+
+	// the same fields as the other backends change
+	storage.MarkUpdated(&updatedPost, *old)
+	p := *old
+	p.Title = updatedPost.Title
+	p.Content = updatedPost.Content
+	p.Updated = updatedPost.Updated
+	p.UpdatedBy = updatedPost.UpdatedBy
+	p.Slug = updatedPost.Slug
+	p.OldSlugs = updatedPost.OldSlugs
+	if p.Slug != old.Slug && s.index.SlugTaken(p) {
+		return storage.ConflictError{Id: p.Id, Slug: p.Slug}
+	}
+
+	// the name follows the slug
+	newName := name
+	if s.rename {
+		newName = s.uniqueName(FileName(p), name)
+	}
+	info, err := s.write(newName, p)
+	if err != nil {
+		return err
+	}
+	if newName != name {
+		err = os.Remove(filepath.Join(s.path, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(s.files, name)
+	}
+
+	s.put(newName, info, p)
+	return nil
+}
+
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name, ok := s.names[id]
+	if !ok {
+		return storage.NotFoundError{Id: id}
+	}
+
+	err := os.Remove(filepath.Join(s.path, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	s.forget(name, s.files[name])
+	return file.SyncDir(s.path)
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	stop := s.stop
+	s.stop = nil
+	s.mu.Unlock()
+
+	// the watcher needs the lock to finish
+	if stop != nil {
+		close(stop)
+		<-s.done
+	}
+	return nil
+}
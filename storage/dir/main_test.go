@@ -0,0 +1,270 @@
+package dir
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"../../post"
+	tu "../../util/testing"
+	"../query"
+)
+
+func tempDir(t *testing.T) string {
+	path, err := ioutil.TempDir("", "gol_dir_test")
+	tu.RequireNil(t, err)
+	return path
+}
+
+func openDir(t *testing.T, path string) *Store {
+	u, err := url.Parse("dir://" + path + "?poll=0")
+	tu.RequireNil(t, err)
+	store, err := Backend{}.Open(u)
+	tu.RequireNil(t, err)
+	return store.(*Store)
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	tu.RequireNil(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
+
+func rescan(t *testing.T, s *Store) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tu.RequireNil(t, s.scan())
+}
+
+func TestMarshal(t *testing.T) {
+	p := post.Post{
+		Id:        "01",
+		Title:     "Hello, \"World\"!",
+		Content:   "about #gol\n\n---\n\nand more",
+		Created:   time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC),
+		Updated:   time.Date(2015, 9, 15, 10, 0, 0, 0, time.UTC),
+		UpdatedBy: "jane",
+		Slug:      "hello-world",
+		OldSlugs:  []string{"hello", "hi"},
+	}
+
+	var read post.Post
+	tu.RequireNil(t, Unmarshal(Marshal(p), &read))
+	tu.ExpectEqual(t, read, p)
+
+	tu.ExpectEqual(t, FileName(p), "2015-09-14-hello-world.md")
+}
+
+func TestUnmarshal(t *testing.T) {
+	created := time.Date(2015, 9, 14, 0, 0, 0, 0, time.UTC)
+
+	yaml := `---
+id: abc
+title: 'It''s here' # a comment
+created: 2015-09-14
+old_slugs:
+  - one
+  - "two"
+tags: [ignored]
+unknown: value
+---
+content
+`
+	var p post.Post
+	tu.RequireNil(t, Unmarshal([]byte(yaml), &p))
+	tu.ExpectEqual(t, p, post.Post{
+		Id:       "abc",
+		Title:    "It's here",
+		Created:  created,
+		OldSlugs: []string{"one", "two"},
+		// the final newline is the file's, not the post's
+		Content: "content",
+	})
+
+	toml := "+++\r\nid = \"abc\"\r\ntitle = \"TOML\"\r\ncreated = 2015-09-14\r\nold_slugs = ['one', \"two\"]\r\n+++\r\ncontent"
+	p = post.Post{}
+	tu.RequireNil(t, Unmarshal([]byte(toml), &p))
+	tu.ExpectEqual(t, p, post.Post{
+		Id:       "abc",
+		Title:    "TOML",
+		Created:  created,
+		OldSlugs: []string{"one", "two"},
+		Content:  "content",
+	})
+
+	tu.ExpectNotNil(t, Unmarshal([]byte("---\nid: abc\n"), &p))
+	tu.ExpectNotNil(t, Unmarshal([]byte("---\ncreated: yesterday\n---\n"), &p))
+	tu.ExpectNotNil(t, Unmarshal([]byte("---\ntitle: \"open\n---\n"), &p))
+}
+
+func TestStore(t *testing.T) {
+	path := tempDir(t)
+	defer os.RemoveAll(path)
+
+	s := openDir(t, path)
+	defer s.Close()
+
+	p := post.Post{
+		Id:      "01",
+		Title:   "First post",
+		Content: "#hello",
+		Created: time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC),
+	}
+	tu.RequireNil(t, s.Create(p))
+	tu.ExpectNotNil(t, s.Create(p))
+
+	name := filepath.Join(path, "2015-09-14-first-post.md")
+	_, err := os.Stat(name)
+	tu.RequireNil(t, err)
+
+	// another post with the same slug on the same day
+	other := p
+	other.Id = "02"
+	tu.RequireNil(t, s.Create(other))
+	_, err = os.Stat(filepath.Join(path, "2015-09-14-first-post-2.md"))
+	tu.RequireNil(t, err)
+
+	// changing the slug renames the file
+	p.Title = "Renamed"
+	p.Slug = "renamed"
+	tu.RequireNil(t, s.Update(p))
+	_, err = os.Stat(name)
+	tu.ExpectEqual(t, os.IsNotExist(err), true)
+
+	renamed := filepath.Join(path, "2015-09-14-renamed.md")
+	data, err := ioutil.ReadFile(renamed)
+	tu.RequireNil(t, err)
+	var read post.Post
+	tu.RequireNil(t, Unmarshal(data, &read))
+	tu.ExpectEqual(t, read.Title, "Renamed")
+	tu.ExpectEqual(t, read.Edited(), true)
+
+	// everything survives a restart
+	s.Close()
+	s = openDir(t, path)
+	posts, err := s.FindAll()
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(posts), 2)
+
+	found, err := s.FindById("01")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, found.Title, "Renamed")
+
+	tu.RequireNil(t, s.Delete("01"))
+	tu.ExpectNotNil(t, s.Delete("01"))
+	_, err = os.Stat(renamed)
+	tu.ExpectEqual(t, os.IsNotExist(err), true)
+}
+
+func TestFileName(t *testing.T) {
+	p := post.Post{Title: "Hello, World!", Created: time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)}
+	tu.ExpectEqual(t, FileName(p), "2015-09-14-hello-world.md")
+
+	// e.g. encrypted slugs
+	p.Slug = "../a/b\\c:d"
+	tu.ExpectEqual(t, FileName(p), "2015-09-14-..-a-b-c-d.md")
+}
+
+func TestWatch(t *testing.T) {
+	path := tempDir(t)
+	defer os.RemoveAll(path)
+
+	s := openDir(t, path)
+	defer s.Close()
+
+	// a new file without front matter
+	name := filepath.Join(path, "2015-09-14-by-hand.md")
+	writeFile(t, name, "written by #hand")
+	rescan(t, s)
+
+	p, err := s.FindById("2015-09-14-by-hand")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, p.Title, "by hand")
+	tu.ExpectEqual(t, p.Created, time.Date(2015, 9, 14, 0, 0, 0, 0, time.UTC))
+	tu.ExpectEqual(t, p.Tags(), []string{"hand"})
+
+	// edited
+	writeFile(t, name, "---\ntitle: Edited by hand\n---\nchanged")
+	rescan(t, s)
+	q := query.Default
+	q.Matches = []query.Field{{Name: "content", Value: "changed"}}
+	posts, err := s.Find(q)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(posts), 1)
+	tu.ExpectEqual(t, posts[0].Title, "Edited by hand")
+
+	// renamed, the id in the front matter is kept
+	writeFile(t, name, "---\nid: by-hand\n---\nchanged")
+	rescan(t, s)
+	renamed := filepath.Join(path, "2015-09-14-moved.md")
+	tu.RequireNil(t, os.Rename(name, renamed))
+	rescan(t, s)
+	p, err = s.FindById("by-hand")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, p.Title, "moved")
+
+	// invalid files are skipped
+	writeFile(t, filepath.Join(path, "2015-09-14-broken.md"), "---\ntitle: \"open\n---\n")
+	rescan(t, s)
+	posts, _ = s.FindAll()
+	tu.ExpectEqual(t, len(posts), 1)
+
+	// other files are ignored
+	writeFile(t, filepath.Join(path, "README.md"), "# my blog")
+	rescan(t, s)
+	posts, _ = s.FindAll()
+	tu.ExpectEqual(t, len(posts), 1)
+
+	// removed
+	tu.RequireNil(t, os.Remove(renamed))
+	rescan(t, s)
+	posts, _ = s.FindAll()
+	tu.ExpectEqual(t, len(posts), 0)
+}
+
+func TestPolling(t *testing.T) {
+	path := tempDir(t)
+	defer os.RemoveAll(path)
+
+	u, _ := url.Parse("dir://" + path + "?poll=10ms")
+	s, err := Backend{}.Open(u)
+	tu.RequireNil(t, err)
+	defer s.Close()
+
+	writeFile(t, filepath.Join(path, "2015-09-14-polled.md"), "content")
+
+	var posts []post.Post
+	for i := 0; i < 100 && len(posts) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		posts, _ = s.FindAll()
+	}
+	tu.ExpectEqual(t, len(posts), 1)
+}
+
+func TestKeepNames(t *testing.T) {
+	path := tempDir(t)
+	defer os.RemoveAll(path)
+
+	u, err := url.Parse("dir://" + path + "?poll=0&rename=false")
+	tu.RequireNil(t, err)
+	store, err := Backend{}.Open(u)
+	tu.RequireNil(t, err)
+	s := store.(*Store)
+	defer s.Close()
+
+	p := post.Post{Id: "01", Title: "First post", Created: time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)}
+	tu.RequireNil(t, s.Create(p))
+	p.Title = "Renamed"
+	p.Slug = "renamed"
+	tu.RequireNil(t, s.Update(p))
+
+	name, _ := s.FileOf("01")
+	tu.ExpectEqual(t, name, "2015-09-14-first-post.md")
+	found, err := s.FindById("01")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, found.Slug, "renamed")
+
+	_, err = Backend{}.Open(&url.URL{Scheme: "dir", Path: path, RawQuery: "rename=maybe"})
+	tu.ExpectNotNil(t, err)
+}
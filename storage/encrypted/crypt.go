@@ -0,0 +1,189 @@
+package encrypted
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"../../post"
+)
+
+// encrypted values look like `gol-encrypted:v1:<key id>:<nonce and
+// ciphertext in url-safe base64>`, everything else is plaintext.  slugs are
+// encrypted as well and end up in urls and file names (e.g. with `dir`).
+const prefix = "gol-encrypted:v1:"
+
+type key struct {
+	// the first bytes of the sha256 of the key, in hex
+	id   string
+	aead cipher.AEAD
+}
+
+// a key is 32 random bytes in base64, e.g. from `head -c 32 /dev/urandom |
+// base64`
+func parseKey(encoded string) (*key, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("a key must be 32 bytes in base64")
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(raw)
+	return &key{hex.EncodeToString(hash[:4]), aead}, nil
+}
+
+// one key per line, empty lines and lines starting with `#` are skipped
+func readKeys(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			keys = append(keys, line)
+		}
+	}
+	return keys, nil
+}
+
+// the first key encrypts, all of them decrypt
+type keyring struct {
+	current *key
+	byId    map[string]*key
+}
+
+func newKeyring(encoded []string) (*keyring, error) {
+	if len(encoded) == 0 {
+		return nil, errors.New("no key given")
+	}
+
+	r := &keyring{byId: make(map[string]*key)}
+	for i, e := range encoded {
+		k, err := parseKey(e)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("key %d: %s", i+1, err))
+		}
+		if i == 0 {
+			r.current = k
+		}
+		r.byId[k.id] = k
+	}
+	return r, nil
+}
+
+// `data` binds the ciphertext to the post and field it belongs to, so
+// that it can't be moved elsewhere
+func (r *keyring) encrypt(plaintext, data string) (string, error) {
+	nonce := make([]byte, r.current.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := r.current.aead.Seal(nonce, nonce, []byte(plaintext), []byte(data))
+	return prefix + r.current.id + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (r *keyring) decrypt(value, data string) (string, error) {
+	if !strings.HasPrefix(value, prefix) {
+		// written before the store was encrypted
+		return value, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, prefix), ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("invalid encrypted value")
+	}
+	k, ok := r.byId[parts[0]]
+	if !ok {
+		return "", errors.New(fmt.Sprintf("encrypted with key %s, which is not given", parts[0]))
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return "", errors.New("invalid encrypted value")
+	}
+	n := k.aead.NonceSize()
+	plaintext, err := k.aead.Open(nil, sealed[:n], sealed[n:], []byte(data))
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("could not decrypt with key %s: %s", k.id, err))
+	}
+	return string(plaintext), nil
+}
+
+// whether `value` would be encrypted differently now
+func (r *keyring) isCurrent(value string) bool {
+	return strings.HasPrefix(value, prefix+r.current.id+":")
+}
+
+// the fields of `p` that are encrypted, with what to bind them to
+func fields(p *post.Post) map[string]*string {
+	fs := map[string]*string{
+		"title":   &p.Title,
+		"content": &p.Content,
+		"slug":    &p.Slug,
+	}
+	for i := range p.OldSlugs {
+		fs[fmt.Sprint("old_slugs.", i)] = &p.OldSlugs[i]
+	}
+	return fs
+}
+
+func (r *keyring) encryptPost(p post.Post) (post.Post, error) {
+	p.OldSlugs = append([]string(nil), p.OldSlugs...)
+	for name, value := range fields(&p) {
+		if *value == "" && name == "slug" {
+			// no slug stored yet, see `post.PermalinkSlug`
+			continue
+		}
+		encrypted, err := r.encrypt(*value, p.Id+"/"+name)
+		if err != nil {
+			return p, err
+		}
+		*value = encrypted
+	}
+	return p, nil
+}
+
+func (r *keyring) decryptPost(p post.Post) (post.Post, error) {
+	p.OldSlugs = append([]string(nil), p.OldSlugs...)
+	for name, value := range fields(&p) {
+		decrypted, err := r.decrypt(*value, p.Id+"/"+name)
+		if err != nil {
+			return p, errors.New(fmt.Sprintf("post %s: %s: %s", p.Id, name, err))
+		}
+		*value = decrypted
+	}
+	return p, nil
+}
+
+// whether all fields of `p` are encrypted with the current key
+func (r *keyring) isCurrentPost(p post.Post) bool {
+	for name, value := range fields(&p) {
+		if name == "slug" && *value == "" {
+			continue
+		}
+		if !r.isCurrent(*value) {
+			return false
+		}
+	}
+	return true
+}
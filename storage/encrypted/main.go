@@ -0,0 +1,347 @@
+// a store that encrypts posts before another one stores them
+package encrypted
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+
+	storage ".."
+	"../../post"
+	"../memory"
+	"../query"
+)
+
+type Backend struct{}
+
+// `encrypted://?backend=<url>&key=<key>` encrypts the title, content and
+// slugs of posts with aes-gcm before the backend stores them.  ids and
+// dates stay readable, they are needed to find posts.
+//
+// to rotate keys, give the new key first and the old ones after it
+// (`?key=<new>&key=<old>`), or in a file with one key per line
+// (`?keys=<path>`, these come after the ones in the url).  the first key
+// encrypts, all of them decrypt.  `Reencrypt` (`gol encrypted
+// reencrypt`) then encrypts all posts with the new key, after which the
+// old ones can be dropped.
+//
+// the backend can't search what it can't read: queries on the title or
+// content, by slug or sorted by title get all posts in the date range from
+// the backend and are answered in memory.
+type Store struct {
+	backend storage.Store
+	keys    *keyring
+}
+
+func init() {
+	storage.Register("encrypted", Backend{})
+}
+
+func (b Backend) Open(u *url.URL) (storage.Store, error) {
+	params := u.Query()
+	backendUrl := params.Get("backend")
+	if backendUrl == "" {
+		return nil, errors.New("no backend store specified")
+	}
+
+	keys := params["key"]
+	if path := params.Get("keys"); path != "" {
+		fromFile, err := readKeys(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fromFile...)
+	}
+	ring, err := newKeyring(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, err := storage.Open(backendUrl)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error opening backend store '%s': %s", storage.Redact(backendUrl), err))
+	}
+	return storage.Store(&Store{backend, ring}), nil
+}
+
+// whether the backend can answer `q` without reading the posts
+func backendCanAnswer(q query.Query) bool {
+	if q.Find != nil && q.Find.Name != "id" {
+		return false
+	}
+	for _, m := range q.Matches {
+		if m.Name != "id" {
+			return false
+		}
+	}
+	return q.SortBy != "title"
+}
+
+// the posts `q` could match, by date
+func dateRange(q query.Query) query.Query {
+	r := query.Default
+	r.RangeStart = q.RangeStart
+	r.RangeEnd = q.RangeEnd
+	r.RangeBy = q.RangeBy
+	return r
+}
+
+func (s *Store) decryptAll(posts []post.Post) ([]post.Post, error) {
+	decrypted := make([]post.Post, 0, len(posts))
+	for _, p := range posts {
+		p, err := s.keys.decryptPost(p)
+		if err != nil {
+			return nil, err
+		}
+		decrypted = append(decrypted, p)
+	}
+	return decrypted, nil
+}
+
+func (s *Store) Find(q query.Query) ([]post.Post, error) {
+	return s.FindContext(context.Background(), q)
+}
+
+func (s *Store) FindContext(ctx context.Context, q query.Query) ([]post.Post, error) {
+	backendQuery := q
+	if !backendCanAnswer(q) {
+		backendQuery = dateRange(q)
+	}
+
+	posts, err := storage.WithContext(s.backend).FindContext(ctx, backendQuery)
+	if err != nil {
+		return nil, err
+	}
+	posts, err = s.decryptAll(posts)
+	if err != nil {
+		return nil, err
+	}
+
+	if backendCanAnswer(q) {
+		return posts, nil
+	}
+	return memory.FromPosts(posts).Find(q)
+}
+
+// streams from the backend if it can answer `q` by itself
+func (s *Store) Each(ctx context.Context, q query.Query, f func(p post.Post) error) error {
+	if !backendCanAnswer(q) {
+		posts, err := s.FindContext(ctx, q)
+		if err != nil {
+			return err
+		}
+		for _, p := range posts {
+			if err := f(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return storage.Each(ctx, s.backend, q, func(p post.Post) error {
+		p, err := s.keys.decryptPost(p)
+		if err != nil {
+			return err
+		}
+		return f(p)
+	})
+}
+
+func (s *Store) FindById(id string) (*post.Post, error) {
+	return s.FindByIdContext(context.Background(), id)
+}
+
+func (s *Store) FindByIdContext(ctx context.Context, id string) (*post.Post, error) {
+	p, err := storage.WithContext(s.backend).FindByIdContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := s.keys.decryptPost(*p)
+	if err != nil {
+		return nil, err
+	}
+	return &decrypted, nil
+}
+
+func (s *Store) FindAll() ([]post.Post, error) {
+	return s.FindAllContext(context.Background())
+}
+
+func (s *Store) FindAllContext(ctx context.Context) ([]post.Post, error) {
+	posts, err := storage.WithContext(s.backend).FindAllContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptAll(posts)
+}
+
+func (s *Store) Create(p post.Post) error {
+	return s.CreateContext(context.Background(), p)
+}
+
+func (s *Store) CreateContext(ctx context.Context, p post.Post) error {
+	encrypted, err := s.keys.encryptPost(p)
+	if err != nil {
+		return err
+	}
+	return storage.WithContext(s.backend).CreateContext(ctx, encrypted)
+}
+
+func (s *Store) Update(p post.Post) error {
+	return s.UpdateContext(context.Background(), p)
+}
+
+func (s *Store) UpdateContext(ctx context.Context, p post.Post) error {
+	encrypted, err := s.keys.encryptPost(p)
+	if err != nil {
+		return err
+	}
+	return storage.WithContext(s.backend).UpdateContext(ctx, encrypted)
+}
+
+func (s *Store) Delete(id string) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *Store) DeleteContext(ctx context.Context, id string) error {
+	return storage.WithContext(s.backend).DeleteContext(ctx, id)
+}
+
+func (s *Store) Batch(ctx context.Context, ops []storage.Op) error {
+	err := storage.CheckOps(ops)
+	if err != nil {
+		return err
+	}
+
+	encrypted := make([]storage.Op, len(ops))
+	for i, op := range ops {
+		encrypted[i] = op
+		if op.Post == nil {
+			continue
+		}
+		p, err := s.keys.encryptPost(*op.Post)
+		if err != nil {
+			return storage.BatchError{Index: i, Op: op, Err: err}
+		}
+		encrypted[i].Post = &p
+	}
+	return storage.Batch(ctx, s.backend, encrypted)
+}
+
+// encrypts the posts that are not encrypted with the first key yet,
+// returns how many there were.  the posts are updated in batches, which
+// are atomic if the backend supports it, keeping their update time and
+// editor (see `storage.KeepUpdated`).
+//
+// to the backend these are updates like any other: watchers (and so
+// webhooks) are told about them, and stores with history record them.
+// backends without `storage.Batch` (`dir`, `git`) and `gol` set the update
+// time to now anyway.
+func (s *Store) Reencrypt(ctx context.Context) (int, error) {
+	var outdated []post.Post
+	err := storage.Each(ctx, s.backend, query.Default, func(p post.Post) error {
+		if !s.keys.isCurrentPost(p) {
+			outdated = append(outdated, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	const batchSize = 100
+	done := 0
+	for len(outdated) > 0 {
+		n := batchSize
+		if len(outdated) < n {
+			n = len(outdated)
+		}
+
+		ops := make([]storage.Op, 0, n)
+		for _, p := range outdated[:n] {
+			decrypted, err := s.keys.decryptPost(p)
+			if err != nil {
+				return done, err
+			}
+			encrypted, err := s.keys.encryptPost(decrypted)
+			if err != nil {
+				return done, err
+			}
+			ops = append(ops, storage.Op{Op: storage.OpUpdate, Post: &encrypted})
+		}
+
+		err = storage.Batch(storage.KeepUpdated(ctx), s.backend, ops)
+		if err != nil {
+			return done, err
+		}
+		done += n
+		outdated = outdated[n:]
+	}
+	return done, nil
+}
+
+// whether the backend can be watched, the store only tells about changes
+// if it can
+func (s *Store) Watchable() bool {
+	_, ok := storage.CanWatch(s.backend)
+	return ok
+}
+
+// the changes of the backend, decrypted
+func (s *Store) Watch(ctx context.Context) <-chan storage.Event {
+	watcher, ok := storage.CanWatch(s.backend)
+	if !ok {
+		events := make(chan storage.Event)
+		close(events)
+		return events
+	}
+	return s.decryptEvents(ctx, watcher.Watch(ctx))
+}
+
+func (s *Store) WatchFrom(ctx context.Context, seq uint64) (<-chan storage.Event, error) {
+	watcher, ok := storage.CanWatch(s.backend)
+	if !ok {
+		return nil, errors.New("the encrypted store can not be watched")
+	}
+	events, err := watcher.WatchFrom(ctx, seq)
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptEvents(ctx, events), nil
+}
+
+// closed when `events` is or when `ctx` is done
+func (s *Store) decryptEvents(ctx context.Context, events <-chan storage.Event) <-chan storage.Event {
+	decrypted := make(chan storage.Event)
+	go func() {
+		defer close(decrypted)
+		for e := range events {
+			if e.Post != nil {
+				p, err := s.keys.decryptPost(*e.Post)
+				if err != nil {
+					log.Printf("Error: could not decrypt the post of event %d: %s", e.Seq, err)
+					e.Post = nil
+				} else {
+					e.Post = &p
+				}
+			}
+			select {
+			case decrypted <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return decrypted
+}
+
+// the store, making changes to the backend as `user`
+func (s *Store) As(user string) storage.Store {
+	return &Store{storage.As(s.backend, user), s.keys}
+}
+
+func (s *Store) Close() error {
+	return s.backend.Close()
+}
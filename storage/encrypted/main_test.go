@@ -0,0 +1,249 @@
+package encrypted
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	storage ".."
+	"../../post"
+	tu "../../util/testing"
+	_ "../dir"
+	_ "../json"
+	"../memory"
+)
+
+var (
+	oldKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	newKey = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+func newStore(t *testing.T, backend storage.Store, keys ...string) *Store {
+	ring, err := newKeyring(keys)
+	tu.RequireNil(t, err)
+	return &Store{backend, ring}
+}
+
+func examplePosts() []post.Post {
+	day := time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)
+	return []post.Post{
+		{Id: "1", Title: "the server is down", Content: "since 10:00 #incident", Created: day, Slug: "the-server-is-down"},
+		{Id: "2", Title: "it is back", Content: "the disk was full #incident", Created: day.Add(time.Hour)},
+		{Id: "3", Title: "a quiet day", Content: "nothing happened", Created: day.AddDate(0, 0, 1)},
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "gol_encrypted_test")
+	tu.RequireNil(t, err)
+	defer os.RemoveAll(tmpPath)
+	jsonPath := path.Join(tmpPath, "posts.json")
+	keysPath := path.Join(tmpPath, "keys")
+	tu.RequireNil(t, ioutil.WriteFile(keysPath, []byte("# rotated 2015-09-14\n"+oldKey+"\n"), 0600))
+
+	params := url.Values{}
+	params.Set("backend", "json://"+jsonPath)
+	params.Set("key", newKey)
+	params.Set("keys", keysPath)
+	s, err := storage.Open("encrypted://?" + params.Encode())
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(s.(*Store).keys.byId), 2)
+
+	for _, p := range examplePosts() {
+		tu.RequireNil(t, s.Create(p))
+	}
+	tu.RequireNil(t, s.Close())
+
+	// nothing readable on disk
+	data, err := ioutil.ReadFile(jsonPath)
+	tu.RequireNil(t, err)
+	for _, secret := range []string{"server", "down", "incident"} {
+		tu.ExpectEqual(t, strings.Contains(string(data), secret), false)
+	}
+
+	for _, rawUrl := range []string{
+		"encrypted://?backend=memory://",
+		"encrypted://?key=" + url.QueryEscape(newKey),
+		"encrypted://?backend=memory://&key=short",
+		"encrypted://?backend=memory://&keys=/no/such/file",
+	} {
+		_, err = storage.Open(rawUrl)
+		tu.ExpectNotNil(t, err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	backend := &memory.Store{}
+	s := newStore(t, backend, newKey)
+	p := examplePosts()[0]
+	p.OldSlugs = []string{"server-down"}
+	tu.RequireNil(t, s.Create(p))
+
+	raw, err := backend.FindById("1")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, strings.HasPrefix(raw.Title, prefix), true)
+	tu.ExpectEqual(t, strings.HasPrefix(raw.Content, prefix), true)
+	tu.ExpectEqual(t, strings.HasPrefix(raw.Slug, prefix), true)
+	tu.ExpectEqual(t, strings.HasPrefix(raw.OldSlugs[0], prefix), true)
+
+	found, err := s.FindById("1")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, *found, p)
+
+	p.Content = "since 10:15"
+	tu.RequireNil(t, s.Update(p))
+	found, _ = s.FindById("1")
+	tu.ExpectEqual(t, found.Content, "since 10:15")
+	tu.ExpectEqual(t, found.Edited(), true)
+
+	// moved to another post, the ciphertext is refused
+	raw, _ = backend.FindById("1")
+	raw.Id = "2"
+	// on another day, the slug is taken on this one
+	raw.Created = raw.Created.AddDate(0, 0, 1)
+	tu.RequireNil(t, backend.Create(*raw))
+	_, err = s.FindById("2")
+	tu.ExpectNotNil(t, err)
+}
+
+// encrypted slugs end up in the file names of `dir` (and `git`)
+func TestOverDir(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "gol_encrypted_test")
+	tu.RequireNil(t, err)
+	defer os.RemoveAll(tmpPath)
+
+	backend, err := storage.Open("dir://" + tmpPath + "?poll=0")
+	tu.RequireNil(t, err)
+	s := newStore(t, backend, newKey)
+	defer s.Close()
+
+	created := time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 50; i++ {
+		p := post.Post{Id: fmt.Sprint(i), Title: fmt.Sprint("post ", i), Created: created}
+		p.Slug = post.Slugify(p.Title)
+		tu.RequireNil(t, s.Create(p))
+
+		found, err := s.FindById(p.Id)
+		tu.RequireNil(t, err)
+		tu.ExpectEqual(t, found.Slug, p.Slug)
+	}
+
+	files, err := ioutil.ReadDir(tmpPath)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(files), 50)
+	for _, f := range files {
+		tu.ExpectEqual(t, strings.Contains(f.Name(), "post"), false)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s := newStore(t, &memory.Store{}, newKey)
+	for _, p := range examplePosts() {
+		tu.RequireNil(t, s.Create(p))
+	}
+
+	q, _ := storage.Query().Match("text", "disk full").Build()
+	posts, err := s.Find(*q)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(posts), 1)
+	tu.ExpectEqual(t, posts[0].Id, "2")
+
+	q, _ = storage.Query().Find("title", "a quiet day").Build()
+	posts, err = s.Find(*q)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(posts), 1)
+	tu.ExpectEqual(t, posts[0].Id, "3")
+
+	q, _ = storage.Query().SortBy("title").Count(2).Build()
+	posts, err = s.Find(*q)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(posts), 2)
+	tu.ExpectEqual(t, posts[0].Title, "a quiet day")
+	tu.ExpectEqual(t, posts[1].Title, "it is back")
+
+	q, _ = storage.Query().Build()
+	counts, err := storage.Aggregate(s, storage.ByTag, *q)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, counts, []storage.Count{{Key: "incident", Count: 2}})
+
+	p, isOld, err := storage.FindBySlug(s, examplePosts()[0].Created, "the-server-is-down")
+	tu.RequireNil(t, err)
+	tu.RequireNotNil(t, p)
+	tu.ExpectEqual(t, isOld, false)
+	tu.ExpectEqual(t, p.Id, "1")
+}
+
+func TestReencrypt(t *testing.T) {
+	backend := &memory.Store{}
+	// written before encrypting
+	tu.RequireNil(t, backend.Create(examplePosts()[0]))
+	old := newStore(t, backend, oldKey)
+	tu.RequireNil(t, old.Create(examplePosts()[1]))
+	p := examplePosts()[1]
+	p.UpdatedBy = "jane"
+	tu.RequireNil(t, old.Update(p))
+	edited, _ := old.FindById("2")
+
+	rotated := newStore(t, backend, newKey, oldKey)
+	for _, p := range examplePosts()[:2] {
+		found, err := rotated.FindById(p.Id)
+		tu.RequireNil(t, err)
+		tu.ExpectEqual(t, found.Title, p.Title)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := backend.Watch(ctx)
+	n, err := rotated.Reencrypt(context.Background())
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, n, 2)
+	// the posts are updated, not deleted and created again
+	for i := 0; i < 2; i++ {
+		e := <-events
+		tu.ExpectEqual(t, e.Type, storage.Updated)
+	}
+	n, err = rotated.Reencrypt(context.Background())
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, n, 0)
+
+	// the old key is not needed anymore
+	s := newStore(t, backend, newKey)
+	found, err := s.FindById("1")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, found.Title, examplePosts()[0].Title)
+	tu.ExpectEqual(t, found.Edited(), false)
+	found, err = s.FindById("2")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, found.Updated.Equal(edited.Updated), true)
+	tu.ExpectEqual(t, found.UpdatedBy, "jane")
+
+	// a clear error without the key
+	_, err = newStore(t, backend, oldKey).FindById("1")
+	tu.RequireNotNil(t, err)
+	tu.ExpectEqual(t, strings.Contains(err.Error(), "which is not given"), true)
+}
+
+func TestWatch(t *testing.T) {
+	s := newStore(t, &memory.Store{}, newKey)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := s.Watch(ctx)
+
+	tu.RequireNil(t, s.Create(examplePosts()[0]))
+	e := <-events
+	tu.ExpectEqual(t, e.Type, storage.Created)
+	tu.ExpectEqual(t, e.Post.Title, examplePosts()[0].Title)
+
+	_, ok := storage.CanWatch(s)
+	tu.ExpectEqual(t, ok, true)
+	// a backend that can't be watched
+	_, ok = storage.CanWatch(newStore(t, struct{ storage.Store }{&memory.Store{}}, newKey))
+	tu.ExpectEqual(t, ok, false)
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"./query"
+)
+
+// what went wrong, check with `errors.Is(err, storage.ErrNotFound)`
+var (
+	ErrNotFound     = errors.New("post not found")
+	ErrConflict     = errors.New("post already exists")
+	ErrInvalidQuery = query.ErrInvalid
+	ErrInvalidOp    = errors.New("invalid change")
+)
+
+// there is no post with this id
+type NotFoundError struct {
+	Id string
+}
+
+func (e NotFoundError) Error() string        { return fmt.Sprintf("post %s not found", e.Id) }
+func (e NotFoundError) Is(target error) bool { return target == ErrNotFound }
+
+// there already is a post with this id, or with this slug on the same day
+// if `Slug` is set
+type ConflictError struct {
+	Id   string
+	Slug string
+}
+
+func (e ConflictError) Error() string {
+	if e.Slug != "" {
+		return fmt.Sprintf("another post already has the slug %s on that day", e.Slug)
+	}
+	return fmt.Sprintf("post with id %s already exists", e.Id)
+}
+
+func (e ConflictError) Is(target error) bool { return target == ErrConflict }
+
+// a change in a batch that can't be applied, see `CheckOps`
+type InvalidOpError struct {
+	Reason string
+}
+
+func (e InvalidOpError) Error() string        { return e.Reason }
+func (e InvalidOpError) Is(target error) bool { return target == ErrInvalidOp }
+
+// a query the storage can't answer, e.g. sorting by an unknown field
+type InvalidQueryError = query.Error
+
+func InvalidQuery(err error) error {
+	return InvalidQueryError{Err: err}
+}
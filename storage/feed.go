@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"../post"
+	"../util/file"
+)
+
+// where a `Feed` keeps its events, so that watchers can resume
+type EventLog interface {
+	Append(e Event) error
+	// the events after `seq` in order, `ErrEventsGone` if some of them
+	// are not kept anymore
+	Since(seq uint64) ([]Event, error)
+	// the sequence number of the last event, 0 if there were none
+	Last() (uint64, error)
+	Close() error
+}
+
+// events are sent to watchers that are this far behind before they are
+// dropped
+const watcherBuffer = 100
+
+// implements `Watcher` for backends, which call `Publish` after each
+// change
+type Feed struct {
+	mu       sync.Mutex
+	log      EventLog
+	seq      uint64
+	watchers map[chan Event]bool
+}
+
+func NewFeed(log EventLog) (*Feed, error) {
+	seq, err := log.Last()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Feed{
+		log:      log,
+		seq:      seq,
+		watchers: make(map[chan Event]bool),
+	}, nil
+}
+
+// records the change and sends it to all watchers.  `p` is nil for
+// deleted posts.
+func (f *Feed) Publish(typ string, id string, p *post.Post) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if p != nil {
+		copied := *p
+		p = &copied
+	}
+
+	f.seq += 1
+	e := Event{Seq: f.seq, Type: typ, Id: id, Post: p, Time: time.Now()}
+
+	// the change has already happened, failing to log it only affects
+	// watchers resuming later
+	err := f.log.Append(e)
+	if err != nil {
+		log.Printf("could not log event %d: %s", e.Seq, err)
+	}
+
+	f.send(e)
+}
+
+// numbers the events of changes that are about to be made, for backends
+// that log them together with the changes (e.g. in the same transaction),
+// so that no change is stored without its event.  once the changes are
+// stored the events are passed to `Send`, nothing else may be published
+// in between.
+func (f *Feed) Prepare(events []Event) []Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	prepared := make([]Event, len(events))
+	for i, e := range events {
+		if e.Post != nil {
+			copied := *e.Post
+			e.Post = &copied
+		}
+		e.Seq = f.seq + uint64(i) + 1
+		e.Time = now
+		prepared[i] = e
+	}
+	return prepared
+}
+
+// sends events from `Prepare` to all watchers, the backend has logged them
+func (f *Feed) Send(events []Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, e := range events {
+		f.seq = e.Seq
+		f.send(e)
+	}
+}
+
+func (f *Feed) send(e Event) {
+	for events := range f.watchers {
+		select {
+		case events <- e:
+		default:
+			// too slow, it can resume from the last event it has seen
+			delete(f.watchers, events)
+			close(events)
+		}
+	}
+}
+
+func (f *Feed) Watch(ctx context.Context) <-chan Event {
+	events, _ := f.watch(ctx, nil)
+	return events
+}
+
+func (f *Feed) WatchFrom(ctx context.Context, seq uint64) (<-chan Event, error) {
+	return f.watch(ctx, &seq)
+}
+
+func (f *Feed) watch(ctx context.Context, since *uint64) (<-chan Event, error) {
+	f.mu.Lock()
+
+	// read the old events and start watching at once, so that none are
+	// missed or sent twice
+	var backlog []Event
+	if since != nil && *since > f.seq {
+		// from before the events were lost, e.g. by a memory store
+		// that was restarted
+		f.mu.Unlock()
+		return nil, ErrEventsGone
+	}
+	if since != nil && *since < f.seq {
+		var err error
+		backlog, err = f.log.Since(*since)
+		if err != nil {
+			f.mu.Unlock()
+			return nil, err
+		}
+	}
+
+	events := make(chan Event, watcherBuffer)
+	if f.watchers == nil {
+		// closed already
+		close(events)
+	} else {
+		f.watchers[events] = true
+	}
+	f.mu.Unlock()
+
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		defer f.unwatch(events)
+
+		for _, e := range backlog {
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (f *Feed) unwatch(events chan Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.watchers[events] {
+		delete(f.watchers, events)
+		close(events)
+	}
+}
+
+// stops all watchers
+func (f *Feed) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for events := range f.watchers {
+		close(events)
+	}
+	f.watchers = nil
+	return f.log.Close()
+}
+
+// keeps the last `n` events in memory
+type memoryLog struct {
+	n      int
+	events []Event
+}
+
+func NewMemoryLog(n int) EventLog {
+	return &memoryLog{n: n}
+}
+
+func (l *memoryLog) Append(e Event) error {
+	l.events = append(l.events, e)
+	if len(l.events) > 2*l.n {
+		l.events = append([]Event{}, l.events[len(l.events)-l.n:]...)
+	}
+	return nil
+}
+
+func (l *memoryLog) Since(seq uint64) ([]Event, error) {
+	return eventsSince(l.events, seq)
+}
+
+func eventsSince(events []Event, seq uint64) ([]Event, error) {
+	if len(events) == 0 || events[0].Seq > seq+1 {
+		return nil, ErrEventsGone
+	}
+
+	for i, e := range events {
+		if e.Seq > seq {
+			return append([]Event{}, events[i:]...), nil
+		}
+	}
+	return []Event{}, nil
+}
+
+func (l *memoryLog) Last() (uint64, error) {
+	if len(l.events) == 0 {
+		return 0, nil
+	}
+	return l.events[len(l.events)-1].Seq, nil
+}
+
+func (l *memoryLog) Close() error {
+	return nil
+}
+
+// keeps the last `n` events in a file, one json object per line
+type fileLog struct {
+	memoryLog
+	path string
+	f    *os.File
+}
+
+func OpenFileLog(path string, n int) (EventLog, error) {
+	l := &fileLog{memoryLog: memoryLog{n: n}, path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var e Event
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			// the last line might be incomplete after a crash
+			log.Printf("skipping event in %s: %s", path, err)
+			continue
+		}
+		l.memoryLog.Append(e)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	l.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *fileLog) Append(e Event) error {
+	before := len(l.events)
+	l.memoryLog.Append(e)
+
+	if len(l.events) < before {
+		// trimmed, rewrite the file with the events that are left
+		return l.rewrite()
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = l.f.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+func (l *fileLog) rewrite() error {
+	var buf bytes.Buffer
+	for _, e := range l.events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+
+	err := file.WriteAtomic(l.path, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	l.f.Close()
+	l.f, err = os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+func (l *fileLog) Close() error {
+	return l.f.Close()
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"../post"
+	tu "../util/testing"
+)
+
+func receive(t *testing.T, events <-chan Event, n int) []Event {
+	received := make([]Event, 0, n)
+	for e := range events {
+		received = append(received, e)
+		if len(received) == n {
+			break
+		}
+	}
+	tu.RequireEqual(t, len(received), n)
+	return received
+}
+
+func TestFeed(t *testing.T) {
+	feed, err := NewFeed(NewMemoryLog(10))
+	tu.RequireNil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := feed.Watch(ctx)
+
+	p := post.Post{Id: "1", Title: "cats"}
+	feed.Publish(Created, p.Id, &p)
+	p.Title = "kittens"
+	feed.Publish(Updated, p.Id, &p)
+	feed.Publish(Deleted, p.Id, nil)
+
+	received := receive(t, events, 3)
+	tu.ExpectEqual(t, received[0].Seq, uint64(1))
+	tu.ExpectEqual(t, received[0].Type, Created)
+	tu.ExpectEqual(t, received[0].Post.Title, "cats")
+	tu.ExpectEqual(t, received[1].Post.Title, "kittens")
+	tu.ExpectEqual(t, received[2].Type, Deleted)
+	tu.ExpectEqual(t, received[2].Id, "1")
+	tu.ExpectEqual(t, received[2].Post == nil, true)
+
+	cancel()
+	for range events {
+	}
+
+	// resuming
+	events, err = feed.WatchFrom(context.Background(), 1)
+	tu.RequireNil(t, err)
+	received = receive(t, events, 2)
+	tu.ExpectEqual(t, received[0].Seq, uint64(2))
+	tu.ExpectEqual(t, received[1].Seq, uint64(3))
+
+	feed.Publish(Created, "2", &post.Post{Id: "2"})
+	received = receive(t, events, 1)
+	tu.ExpectEqual(t, received[0].Seq, uint64(4))
+
+	// closing stops watchers
+	tu.RequireNil(t, feed.Close())
+	_, ok := <-events
+	tu.ExpectEqual(t, ok, false)
+}
+
+func TestFeedEventsGone(t *testing.T) {
+	feed, _ := NewFeed(NewMemoryLog(2))
+	for i := 0; i < 5; i++ {
+		feed.Publish(Deleted, "1", nil)
+	}
+
+	_, err := feed.WatchFrom(context.Background(), 0)
+	tu.ExpectEqual(t, err, ErrEventsGone)
+	events, err := feed.WatchFrom(context.Background(), 3)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(receive(t, events, 2)), 2)
+
+	// nothing to resume
+	_, err = feed.WatchFrom(context.Background(), 5)
+	tu.ExpectNil(t, err)
+
+	// from another store
+	_, err = feed.WatchFrom(context.Background(), 6)
+	tu.ExpectEqual(t, err, ErrEventsGone)
+}
+
+func TestFeedDropsSlowWatchers(t *testing.T) {
+	feed, _ := NewFeed(NewMemoryLog(10))
+	events := feed.Watch(context.Background())
+
+	for i := 0; i < 2*watcherBuffer; i++ {
+		feed.Publish(Deleted, "1", nil)
+	}
+
+	var last uint64
+	for e := range events {
+		last = e.Seq
+	}
+	if last == 0 || last >= 2*watcherBuffer {
+		t.Errorf("expected the watcher to be dropped, got %d events", last)
+	}
+}
+
+func TestFileLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gol_events_test")
+	tu.RequireNil(t, err)
+	defer os.RemoveAll(dir)
+	logPath := path.Join(dir, "events")
+
+	l, err := OpenFileLog(logPath, 3)
+	tu.RequireNil(t, err)
+	feed, err := NewFeed(l)
+	tu.RequireNil(t, err)
+	for i := 0; i < 10; i++ {
+		feed.Publish(Created, "1", &post.Post{Id: "1", Title: "cats"})
+	}
+	tu.RequireNil(t, feed.Close())
+
+	// continues after a restart
+	l, err = OpenFileLog(logPath, 3)
+	tu.RequireNil(t, err)
+	feed, err = NewFeed(l)
+	tu.RequireNil(t, err)
+	defer feed.Close()
+	feed.Publish(Deleted, "1", nil)
+
+	events, err := feed.WatchFrom(context.Background(), 8)
+	tu.RequireNil(t, err)
+	received := receive(t, events, 3)
+	tu.ExpectEqual(t, received[0].Seq, uint64(9))
+	tu.ExpectEqual(t, received[0].Post.Title, "cats")
+	tu.ExpectEqual(t, received[2].Seq, uint64(11))
+	tu.ExpectEqual(t, received[2].Type, Deleted)
+
+	_, err = feed.WatchFrom(context.Background(), 1)
+	tu.ExpectEqual(t, err, ErrEventsGone)
+}
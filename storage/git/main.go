@@ -0,0 +1,330 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	storage ".."
+	"../../post"
+	"../dir"
+	"../query"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+type Backend struct{}
+
+// posts are stored like in the `dir` backend, in a git repository (which
+// is created if necessary).  every change is committed, authored by the
+// user making it (see `storage.As`).
+//
+// the history of a post comes from the commits made by gol, which name the
+// post in their message.  changes made by hand show up in the posts, but
+// have to be committed by hand.
+//
+// files keep the name they were created with when the slug changes (see
+// `dir`'s `?rename=false`), because blame doesn't follow renames.
+type Store struct {
+	// one commit per change
+	mu    sync.Mutex
+	path  string
+	repo  *git.Repository
+	files *dir.Store
+}
+
+func init() {
+	storage.Register("git", Backend{})
+}
+
+func (b Backend) Open(u *url.URL) (storage.Store, error) {
+	path := u.Host + u.Path
+	if path == "" {
+		return nil, errors.New("git: no repository given, use git://path/to/repo")
+	}
+
+	repo, err := git.PlainOpen(path)
+	if err == git.ErrRepositoryNotExists {
+		err = os.MkdirAll(path, 0755)
+		if err != nil {
+			return nil, err
+		}
+		repo, err = git.PlainInit(path, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// `?poll=` is passed on
+	params := u.Query()
+	params.Set("rename", "false")
+	files, err := dir.Backend{}.Open(&url.URL{Scheme: "dir", Path: path, RawQuery: params.Encode()})
+	if err != nil {
+		return nil, err
+	}
+
+	store := &Store{
+		path:  path,
+		repo:  repo,
+		files: files.(*dir.Store),
+	}
+	return storage.Store(store), nil
+}
+
+func (s *Store) Find(q query.Query) ([]post.Post, error) {
+	return s.files.Find(q)
+}
+
+func (s *Store) Aggregate(by string, q query.Query) ([]storage.Count, error) {
+	return s.files.Aggregate(by, q)
+}
+
+func (s *Store) FindById(id string) (*post.Post, error) {
+	return s.files.FindById(id)
+}
+
+func (s *Store) FindAll() ([]post.Post, error) {
+	return s.files.FindAll()
+}
+
+func (s *Store) Create(p post.Post) error {
+	return s.create("", p)
+}
+
+func (s *Store) Update(p post.Post) error {
+	return s.update("", p)
+}
+
+func (s *Store) Delete(id string) error {
+	return s.delete("", id)
+}
+
+func (s *Store) Close() error {
+	return s.files.Close()
+}
+
+func (s *Store) As(user string) storage.Store {
+	return &userStore{s, user}
+}
+
+// changes are committed as `user`
+type userStore struct {
+	*Store
+	user string
+}
+
+func (u *userStore) Create(p post.Post) error {
+	return u.Store.create(u.user, p)
+}
+
+func (u *userStore) Update(p post.Post) error {
+	return u.Store.update(u.user, p)
+}
+
+func (u *userStore) Delete(id string) error {
+	return u.Store.delete(u.user, id)
+}
+
+func (s *Store) create(user string, p post.Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.files.Create(p)
+	if err != nil {
+		return err
+	}
+
+	name, _ := s.files.FileOf(p.Id)
+	return s.commit(user, message("Create", p, name), name)
+}
+
+func (s *Store) update(user string, p post.Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.files.Update(p)
+	if err != nil {
+		return err
+	}
+
+	if user == "" {
+		user = p.UpdatedBy
+	}
+	name, _ := s.files.FileOf(p.Id)
+	return s.commit(user, message("Update", p, name), name)
+}
+
+func (s *Store) delete(user string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.files.FindById(id)
+	if err != nil {
+		return err
+	}
+	name, _ := s.files.FileOf(id)
+
+	err = s.files.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	return s.commit(user, message("Delete", *p, name), name)
+}
+
+// e.g.
+//
+//	Update "Hello, World!"
+//
+//	Post-Id: 01DXF6DT000000000000000000
+//	Post-File: 2015-09-14-hello-world.md
+func message(action string, p post.Post, name string) string {
+	return fmt.Sprintf("%s %q\n\nPost-Id: %s\nPost-File: %s\n", action, p.Title, p.Id, name)
+}
+
+// the values of the `Key: value` lines at the end of the message
+func trailers(message string) map[string]string {
+	values := make(map[string]string)
+	lines := strings.Split(strings.TrimSpace(message), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		parts := strings.SplitN(lines[i], ": ", 2)
+		if len(parts) != 2 || strings.Contains(parts[0], " ") {
+			break
+		}
+		values[parts[0]] = parts[1]
+	}
+	return values
+}
+
+// commits the changes to the files `names` (which may have been removed)
+func (s *Store) commit(user string, message string, names ...string) error {
+	wt, err := s.repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	staged := make(map[string]bool)
+	for _, name := range names {
+		if name == "" || staged[name] {
+			continue
+		}
+		staged[name] = true
+
+		_, err = os.Stat(filepath.Join(s.path, name))
+		if os.IsNotExist(err) {
+			_, err = wt.Remove(name)
+		} else {
+			_, err = wt.Add(name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if user == "" {
+		user = "gol"
+	}
+	// blame only knows the email
+	author := &object.Signature{Name: user, Email: user, When: time.Now()}
+
+	// there is always a change, but deleting the last post empties the
+	// index, which some versions of go-git take for an empty commit
+	_, err = wt.Commit(message, &git.CommitOptions{Author: author, AllowEmptyCommits: true})
+	if err != nil {
+		return errors.New(fmt.Sprintf("the post was saved, but could not be committed: %s", err))
+	}
+	return nil
+}
+
+func (s *Store) History(id string) ([]storage.Revision, error) {
+	revisions := []storage.Revision{}
+
+	commits, err := s.repo.Log(&git.LogOptions{})
+	if err == plumbing.ErrReferenceNotFound {
+		// nothing committed yet
+		return revisions, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer commits.Close()
+
+	err = commits.ForEach(func(c *object.Commit) error {
+		values := trailers(c.Message)
+		if values["Post-Id"] != id {
+			return nil
+		}
+
+		revision := storage.Revision{
+			Id:      c.Hash.String(),
+			Author:  c.Author.Name,
+			Date:    c.Author.When,
+			Message: strings.SplitN(c.Message, "\n", 2)[0],
+		}
+
+		f, err := c.File(values["Post-File"])
+		if err == object.ErrFileNotFound {
+			revisions = append(revisions, revision)
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		contents, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		p := post.Post{Id: id}
+		err = dir.Unmarshal([]byte(contents), &p)
+		if err != nil {
+			return err
+		}
+		revision.Post = &p
+
+		revisions = append(revisions, revision)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// the lines of the file of the post, as last committed
+func (s *Store) Blame(id string) ([]storage.BlameLine, error) {
+	name, ok := s.files.FileOf(id)
+	if !ok {
+		return nil, storage.NotFoundError{Id: id}
+	}
+
+	head, err := s.repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := git.Blame(c, name)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]storage.BlameLine, len(result.Lines))
+	for i, l := range result.Lines {
+		lines[i] = storage.BlameLine{
+			Revision: l.Hash.String(),
+			Author:   l.Author,
+			Date:     l.Date,
+			Text:     l.Text,
+		}
+	}
+	return lines, nil
+}
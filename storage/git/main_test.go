@@ -0,0 +1,89 @@
+package git
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	storage ".."
+	"../../post"
+	tu "../../util/testing"
+)
+
+func openRepo(t *testing.T) (*Store, string) {
+	path, err := ioutil.TempDir("", "gol_git_test")
+	tu.RequireNil(t, err)
+
+	u, err := url.Parse("git://" + path + "?poll=0")
+	tu.RequireNil(t, err)
+	store, err := Backend{}.Open(u)
+	tu.RequireNil(t, err)
+	return store.(*Store), path
+}
+
+func TestTrailers(t *testing.T) {
+	p := post.Post{Id: "01", Title: "Hello"}
+	values := trailers(message("Create", p, "2015-09-14-hello.md"))
+	tu.ExpectEqual(t, values, map[string]string{
+		"Post-Id":   "01",
+		"Post-File": "2015-09-14-hello.md",
+	})
+
+	tu.ExpectEqual(t, trailers("Fix a typo\n\nby hand"), map[string]string{})
+}
+
+func TestHistory(t *testing.T) {
+	s, path := openRepo(t)
+	defer os.RemoveAll(path)
+	defer s.Close()
+
+	revisions, err := s.History("01")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(revisions), 0)
+
+	p := post.Post{
+		Id:      "01",
+		Title:   "First post",
+		Content: "first",
+		Created: time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC),
+	}
+	tu.RequireNil(t, storage.As(s, "jane").Create(p))
+
+	p.Title = "Renamed"
+	p.Slug = "renamed"
+	p.Content = "first\nsecond"
+	p.UpdatedBy = "john"
+	tu.RequireNil(t, s.Update(p))
+
+	lines, err := s.Blame("01")
+	tu.RequireNil(t, err)
+	authors := make(map[string]string)
+	for _, l := range lines {
+		authors[l.Text] = l.Author
+	}
+	tu.ExpectEqual(t, authors["first"], "jane")
+	tu.ExpectEqual(t, authors["second"], "john")
+
+	tu.RequireNil(t, storage.As(s, "jane").Delete("01"))
+
+	revisions, err = s.History("01")
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(revisions), 3)
+
+	tu.ExpectEqual(t, revisions[0].Message, `Delete "Renamed"`)
+	tu.ExpectEqual(t, revisions[0].Author, "jane")
+	tu.ExpectNil(t, revisions[0].Post)
+
+	tu.ExpectEqual(t, revisions[1].Author, "john")
+	tu.RequireNotNil(t, revisions[1].Post)
+	tu.ExpectEqual(t, revisions[1].Post.Title, "Renamed")
+
+	tu.ExpectEqual(t, revisions[2].Message, `Create "First post"`)
+	tu.RequireNotNil(t, revisions[2].Post)
+	tu.ExpectEqual(t, revisions[2].Post.Content, "first")
+
+	_, err = s.Blame("01")
+	tu.ExpectNotNil(t, err)
+}
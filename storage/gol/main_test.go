@@ -0,0 +1,302 @@
+package gol
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	storage ".."
+	"../../post"
+	tu "../../util/testing"
+)
+
+// a remote gol that answers with `handler`, counting the requests
+type remote struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func newRemote(tls bool, handler http.HandlerFunc) *remote {
+	r := &remote{}
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		r.requests = append(r.requests, req)
+		r.mu.Unlock()
+		handler(w, req)
+	})
+	if tls {
+		r.Server = httptest.NewTLSServer(h)
+	} else {
+		r.Server = httptest.NewServer(h)
+	}
+	return r
+}
+
+func (r *remote) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func (r *remote) last() *http.Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests[len(r.requests)-1]
+}
+
+func open(t *testing.T, rawUrl string) *Store {
+	u, err := url.Parse(rawUrl)
+	tu.RequireNil(t, err)
+	s, err := Backend{}.Open(u)
+	tu.RequireNil(t, err)
+	store := s.(*Store)
+	store.minDelay = time.Millisecond
+	return store
+}
+
+func golUrl(r *remote) string {
+	return strings.Replace(r.URL, "http://", "gol://", 1)
+}
+
+func TestCredentials(t *testing.T) {
+	r := newRemote(false, func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode([]post.Post{{Id: "1", Title: "cats"}})
+	})
+	defer r.Close()
+
+	u, _ := url.Parse(golUrl(r))
+	u.User = url.UserPassword("alice", "secret")
+	s := open(t, u.String())
+	defer s.Close()
+	posts, err := s.FindAll()
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, posts[0].Title, "cats")
+	username, password, ok := r.last().BasicAuth()
+	tu.ExpectEqual(t, ok, true)
+	tu.ExpectEqual(t, username, "alice")
+	tu.ExpectEqual(t, password, "secret")
+
+	s = open(t, golUrl(r)+"?token=abc")
+	defer s.Close()
+	_, err = s.FindAll()
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, r.last().Header.Get("Authorization"), "Bearer abc")
+}
+
+func TestLoginRedirect(t *testing.T) {
+	r := newRemote(false, func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "/login?redirect_to=/posts", http.StatusSeeOther)
+	})
+	defer r.Close()
+
+	s := open(t, golUrl(r))
+	defer s.Close()
+	err := s.Create(post.Post{Id: "1", Title: "cats"})
+	var statusErr StatusError
+	tu.RequireEqual(t, errors.As(err, &statusErr), true)
+	tu.ExpectEqual(t, statusErr.Code, http.StatusUnauthorized)
+	tu.ExpectEqual(t, r.count(), 1)
+}
+
+func TestStatusErrors(t *testing.T) {
+	status := http.StatusNotFound
+	r := newRemote(false, func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "no such post", status)
+	})
+	defer r.Close()
+
+	s := open(t, golUrl(r))
+	defer s.Close()
+	_, err := s.FindById("1")
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrNotFound), true)
+	tu.ExpectEqual(t, strings.HasSuffix(err.Error(), "no such post"), true)
+
+	status = http.StatusConflict
+	err = s.Create(post.Post{Id: "1", Title: "cats"})
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrConflict), true)
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrNotFound), false)
+
+	status = http.StatusBadRequest
+	q, _ := storage.Query().Build()
+	_, err = s.Find(*q)
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrInvalidQuery), true)
+}
+
+func TestRetries(t *testing.T) {
+	failures := 2
+	var mu sync.Mutex
+	r := newRemote(false, func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			http.Error(w, "restarting", http.StatusServiceUnavailable)
+			return
+		}
+		if req.Method == "DELETE" {
+			// the earlier attempt went through after all
+			http.Error(w, "no such post", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(post.Post{Id: "1", Title: "cats"})
+	})
+	defer r.Close()
+
+	s := open(t, golUrl(r))
+	defer s.Close()
+	p, err := s.FindById("1")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, p.Title, "cats")
+	tu.ExpectEqual(t, r.count(), 3)
+
+	// not idempotent, not retried
+	failures = 1
+	err = s.Create(post.Post{Id: "2", Title: "dogs"})
+	tu.ExpectNotNil(t, err)
+	tu.ExpectEqual(t, r.count(), 4)
+
+	failures = 1
+	tu.ExpectNil(t, s.Delete("1"))
+	tu.ExpectEqual(t, r.count(), 6)
+
+	failures = 10
+	s = open(t, golUrl(r)+"?retries=1")
+	defer s.Close()
+	_, err = s.FindById("1")
+	tu.ExpectNotNil(t, err)
+	tu.ExpectEqual(t, r.count(), 8)
+}
+
+func TestTimeout(t *testing.T) {
+	done := make(chan bool)
+	r := newRemote(false, func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+		}
+	})
+	defer r.Close()
+	defer close(done)
+
+	s := open(t, golUrl(r)+"?timeout=10ms&retries=0")
+	defer s.Close()
+	start := time.Now()
+	_, err := s.FindAll()
+	tu.ExpectNotNil(t, err)
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("expected to time out, took %s", time.Since(start))
+	}
+}
+
+func TestHttpsWithCA(t *testing.T) {
+	r := newRemote(true, func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode([]post.Post{})
+	})
+	defer r.Close()
+
+	ca, err := ioutil.TempFile("", "gol_ca")
+	tu.RequireNil(t, err)
+	defer os.Remove(ca.Name())
+	pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: r.Certificate().Raw})
+	ca.Close()
+
+	httpsUrl := strings.Replace(r.URL, "https://", "gol+https://", 1)
+	s := open(t, httpsUrl+"?retries=0")
+	defer s.Close()
+	_, err = s.FindAll()
+	tu.ExpectNotNil(t, err)
+
+	s = open(t, httpsUrl+"?ca="+url.QueryEscape(ca.Name()))
+	defer s.Close()
+	posts, err := s.FindAll()
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(posts), 0)
+}
+
+func TestOpenInvalid(t *testing.T) {
+	for _, rawUrl := range []string{"gol://localhost?timeout=soon", "gol://localhost?retries=-1", "gol://localhost?ca=/does/not/exist"} {
+		u, _ := url.Parse(rawUrl)
+		_, err := Backend{}.Open(u)
+		tu.ExpectNotNil(t, err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	r := newRemote(false, func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "restarting", http.StatusServiceUnavailable)
+	})
+	defer r.Close()
+
+	s := open(t, golUrl(r)+"?retries=100")
+	defer s.Close()
+	s.minDelay = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := s.FindAllContext(ctx)
+	tu.ExpectEqual(t, errors.Is(err, context.DeadlineExceeded), true)
+	if r.count() > 5 {
+		t.Errorf("expected to stop retrying, got %d requests", r.count())
+	}
+}
+
+func TestEach(t *testing.T) {
+	r := newRemote(false, func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`[{"id": "1", "title": "cats"}, {"id": "2", "title": "dogs"}, {"id": "3"`))
+	})
+	defer r.Close()
+
+	s := open(t, golUrl(r))
+	defer s.Close()
+	q, _ := storage.Query().Build()
+	var titles []string
+	err := s.Each(context.Background(), *q, func(p post.Post) error {
+		titles = append(titles, p.Title)
+		return nil
+	})
+	// what arrived before the response broke off
+	tu.ExpectNotNil(t, err)
+	tu.ExpectEqual(t, titles, []string{"cats", "dogs"})
+	tu.ExpectEqual(t, r.count(), 1)
+}
+
+func TestBatch(t *testing.T) {
+	var ops []storage.Op
+	r := newRemote(false, func(w http.ResponseWriter, req *http.Request) {
+		json.NewDecoder(req.Body).Decode(&ops)
+		if len(ops) > 1 {
+			http.Error(w, "change 1 (create 1): conflict", http.StatusConflict)
+		}
+	})
+	defer r.Close()
+
+	s := open(t, golUrl(r))
+	defer s.Close()
+
+	err := s.Batch(context.Background(), []storage.Op{{Op: storage.OpDelete, Id: "1"}})
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, r.last().Method, "POST")
+	tu.ExpectEqual(t, r.last().URL.Path, "/api/v1/posts:batch")
+	tu.ExpectEqual(t, ops, []storage.Op{{Op: storage.OpDelete, Id: "1"}})
+
+	err = s.Batch(context.Background(), []storage.Op{
+		{Op: storage.OpDelete, Id: "1"},
+		{Op: storage.OpCreate, Post: &post.Post{Id: "1"}},
+	})
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrConflict), true)
+
+	// invalid changes are not sent
+	err = s.Batch(context.Background(), []storage.Op{{Op: storage.OpCreate, Id: "1"}})
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrInvalidOp), true)
+	tu.ExpectEqual(t, r.count(), 2)
+}
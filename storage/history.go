@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"time"
+
+	"../post"
+)
+
+// implemented by stores that keep old versions of posts
+type Historian interface {
+	// all versions of the post, newest first
+	History(id string) ([]Revision, error)
+	// who last changed each line of the post
+	Blame(id string) ([]BlameLine, error)
+}
+
+type Revision struct {
+	Id      string    `json:"id"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	Message string    `json:"message"`
+	// nil if the post was deleted in this revision
+	Post *post.Post `json:"post,omitempty"`
+}
+
+type BlameLine struct {
+	Revision string    `json:"revision"`
+	Author   string    `json:"author"`
+	Date     time.Time `json:"date"`
+	Text     string    `json:"text"`
+}
+
+// implemented by stores that record who made a change
+type Attributed interface {
+	// the store, with all changes made by `user`
+	As(user string) Store
+}
+
+// `s` making changes as `user`, if it records that.  `s` itself
+// otherwise.
+func As(s Store, user string) Store {
+	if a, ok := s.(Attributed); ok && user != "" {
+		return a.As(user)
+	}
+	return s
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"../post"
+	"./query"
+)
+
+// implemented by stores that can read posts one at a time instead of all
+// at once
+type Iterator interface {
+	// calls `f` with the posts matching `q`, in order.  the next post is
+	// read once `f` returned, iteration stops at the first error, which is
+	// returned.  `f` must not change the store.
+	Each(ctx context.Context, q query.Query, f func(p post.Post) error) error
+}
+
+// returned by `f` to stop early, `Each` returns nil then
+var ErrStop = errors.New("stop iterating")
+
+// calls `f` with the posts of `s` matching `q`, one at a time if `s` can
+// do that (see `Iterator`)
+func Each(ctx context.Context, s Store, q query.Query, f func(p post.Post) error) error {
+	err := each(ctx, s, q, f)
+	if err == ErrStop {
+		return nil
+	}
+	return err
+}
+
+func each(ctx context.Context, s Store, q query.Query, f func(p post.Post) error) error {
+	if it, ok := s.(Iterator); ok {
+		return it.Each(ctx, q, f)
+	}
+
+	posts, err := WithContext(s).FindContext(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, p := range posts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := f(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
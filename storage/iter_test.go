@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"../post"
+	tu "../util/testing"
+	"./query"
+)
+
+func TestEach(t *testing.T) {
+	s := newSlowStore()
+	close(s.release)
+
+	var ids []string
+	err := Each(context.Background(), s, query.Query{}, func(p post.Post) error {
+		ids = append(ids, p.Id)
+		return ErrStop
+	})
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, ids, []string{"1"})
+
+	oops := errors.New("oops")
+	err = Each(context.Background(), s, query.Query{}, func(p post.Post) error {
+		return oops
+	})
+	tu.ExpectEqual(t, err, oops)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = Each(ctx, s, query.Query{}, func(p post.Post) error {
+		t.Error("expected no posts after cancelling")
+		return nil
+	})
+	tu.ExpectEqual(t, err, context.Canceled)
+}
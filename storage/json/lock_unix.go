@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package json
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// an advisory lock, released by the os if gol crashes
+type fileLock struct {
+	f *os.File
+}
+
+func lockFile(path string) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errors.New(fmt.Sprintf("%s is locked, is another gol using it?", path))
+		}
+		return nil, err
+	}
+
+	return &fileLock{f}, nil
+}
+
+func (l *fileLock) Unlock() error {
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	if err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
@@ -0,0 +1,34 @@
+//go:build windows
+// +build windows
+
+package json
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// a lock file that exists while gol is using the store.  unlike on unix,
+// it has to be removed manually if gol crashes.
+type fileLock struct {
+	path string
+	f    *os.File
+}
+
+func lockFile(path string) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, errors.New(fmt.Sprintf("%s exists, is another gol using it?", path))
+		}
+		return nil, err
+	}
+
+	return &fileLock{path, f}, nil
+}
+
+func (l *fileLock) Unlock() error {
+	l.f.Close()
+	return os.Remove(l.path)
+}
@@ -1,24 +1,44 @@
 package json
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"sync"
 
 	storage ".."
 	"../../post"
+	"../../util/file"
 	"../memory"
 	"../query"
 )
 
 type Backend struct{}
 
+// all posts are kept in memory, every change rewrites the whole file.
+//
+// writes go to a temporary file first, which then replaces the old one,
+// so that a crash never leaves a half-written file behind.  the previous
+// version is kept as `<path>.bak`.  a lock file (`<path>.lock`) prevents
+// other instances of gol from writing to the same file.  the last changes
+// are kept in `<path>.events` for watchers.
 type Store struct {
+	// changes are written in order, readers never see a change that
+	// could not be written
+	mu            sync.RWMutex
 	path          string
+	lock          *fileLock
 	memoryBackend *memory.Store
+	feed          *storage.Feed
 }
 
+const keepEvents = 1000
+
 func init() {
 	storage.Register("json", Backend{})
 }
@@ -26,42 +46,102 @@ func init() {
 func (m Backend) Open(u *url.URL) (storage.Store, error) {
 	path := u.Host + u.Path
 
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+
 	// initialize with empty array if no such file
-	_, err := os.Stat(path)
+	_, err = os.Stat(path)
 	if err != nil {
-		err = ioutil.WriteFile(path, []byte("[]"), 0644)
+		err = file.WriteAtomic(path, []byte("[]"), 0644)
 		if err != nil {
+			lock.Unlock()
 			return nil, err
 		}
 	}
 
 	posts, err := readPosts(path)
 	if err != nil {
+		lock.Unlock()
+		if _, statErr := os.Stat(path + ".bak"); statErr == nil {
+			return nil, errors.New(fmt.Sprintf("%s (the previous version is in %s.bak)", err, path))
+		}
+		return nil, err
+	}
+
+	events, err := storage.OpenFileLog(path+".events", keepEvents)
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	feed, err := storage.NewFeed(events)
+	if err != nil {
+		events.Close()
+		lock.Unlock()
 		return nil, err
 	}
 
 	store := &Store{
 		path:          path,
+		lock:          lock,
 		memoryBackend: memory.FromPosts(posts),
+		feed:          feed,
 	}
 
 	return storage.Store(store), nil
 }
 
+// decodes one post at a time, so that the file isn't in memory next to
+// its posts.  the posts are all kept, the store serves them from memory.
 func readPosts(path string) ([]post.Post, error) {
-	postsJson, err := ioutil.ReadFile(path)
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
 	var posts []post.Post
-	err = json.Unmarshal(postsJson, &posts)
+	err = decodePosts(f, func(p post.Post) error {
+		posts = append(posts, p)
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, errors.New(fmt.Sprintf("could not read %s: %s", path, err))
 	}
 	return posts, nil
 }
 
+// calls `f` with the posts of the json array in `r`, as they are decoded
+func decodePosts(r io.Reader, f func(p post.Post) error) error {
+	dec := json.NewDecoder(r)
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		// `null`, no posts
+		return nil
+	}
+	if t != json.Delim('[') {
+		return errors.New(fmt.Sprintf("expected an array of posts, got %v", t))
+	}
+
+	for dec.More() {
+		var p post.Post
+		err = dec.Decode(&p)
+		if err != nil {
+			return err
+		}
+		err = f(p)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
 func writePosts(path string, posts []post.Post) error {
 	post.Reverse(post.ByDate(posts))
 	postsJson, err := json.MarshalIndent(posts, "", "\t")
@@ -69,39 +149,139 @@ func writePosts(path string, posts []post.Post) error {
 		return err
 	}
 
-	return ioutil.WriteFile(path, postsJson, 0644)
+	err = backup(path)
+	if err != nil {
+		return err
+	}
+
+	return file.WriteAtomic(path, postsJson, 0644)
+}
+
+// keeps the current version of `path` as `<path>.bak`
+func backup(path string) error {
+	bak := path + ".bak"
+	err := os.Remove(bak)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// a hard link is cheap and keeps the old contents after the rename
+	err = os.Link(path, bak)
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+
+	// not supported by the filesystem, copy instead
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return file.WriteAtomic(bak, data, 0644)
 }
 
 func (s *Store) Find(q query.Query) ([]post.Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.memoryBackend.Find(q)
 }
 
+func (s *Store) Aggregate(by string, q query.Query) ([]storage.Count, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.memoryBackend.Aggregate(by, q)
+}
+
 func (s *Store) FindById(id string) (*post.Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.memoryBackend.FindById(id)
 }
 
 func (s *Store) FindAll() ([]post.Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.memoryBackend.FindAll()
 }
 
 func (s *Store) Create(post post.Post) error {
-	s.memoryBackend.Create(post)
-	posts, _ := s.memoryBackend.FindAll()
-	return writePosts(s.path, posts)
+	op := storage.Op{Op: storage.OpCreate, Post: &post}
+	return s.change([]storage.Op{op}, func(m *memory.Store) error {
+		return m.Create(post)
+	})
 }
 
 func (s *Store) Update(updatedPost post.Post) error {
-	s.memoryBackend.Update(updatedPost)
-	posts, _ := s.memoryBackend.FindAll()
-	return writePosts(s.path, posts)
+	op := storage.Op{Op: storage.OpUpdate, Post: &updatedPost}
+	return s.change([]storage.Op{op}, func(m *memory.Store) error {
+		return m.Update(updatedPost)
+	})
 }
 
 func (s *Store) Delete(id string) error {
-	s.memoryBackend.Delete(id)
+	op := storage.Op{Op: storage.OpDelete, Id: id}
+	return s.change([]storage.Op{op}, func(m *memory.Store) error {
+		return m.Delete(id)
+	})
+}
+
+// applies all changes and writes the file once
+func (s *Store) Batch(ctx context.Context, ops []storage.Op) error {
+	return s.change(ops, func(m *memory.Store) error {
+		return m.Batch(ctx, ops)
+	})
+}
+
+// applies `f` and writes the result to disk.  if that fails, the change is
+// undone, so that memory and file don't diverge.  otherwise watchers are
+// told about the changes in `ops`.
+func (s *Store) change(ops []storage.Op, f func(m *memory.Store) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lock == nil {
+		return errors.New("store is closed")
+	}
+
+	before, _ := s.memoryBackend.FindAll()
+	err := f(s.memoryBackend)
+	if err != nil {
+		return err
+	}
+
 	posts, _ := s.memoryBackend.FindAll()
-	return writePosts(s.path, posts)
+	err = writePosts(s.path, posts)
+	if err != nil {
+		s.memoryBackend = memory.FromPosts(before)
+		return err
+	}
+
+	for _, op := range ops {
+		var p *post.Post
+		if op.Op != storage.OpDelete {
+			p, _ = s.memoryBackend.FindById(op.PostId())
+		}
+		s.feed.Publish(op.EventType(), op.PostId(), p)
+	}
+	return nil
+}
+
+func (s *Store) Watch(ctx context.Context) <-chan storage.Event {
+	return s.feed.Watch(ctx)
+}
+
+func (s *Store) WatchFrom(ctx context.Context, seq uint64) (<-chan storage.Event, error) {
+	return s.feed.WatchFrom(ctx, seq)
 }
 
 func (s *Store) Close() error {
-	return nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lock == nil {
+		return nil
+	}
+	s.feed.Close()
+	err := s.lock.Unlock()
+	s.lock = nil
+	return err
 }
@@ -1,14 +1,20 @@
 package json
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/url"
+	"os"
 	"path"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	storage ".."
 	"../../post"
+	tu "../../util/testing"
 )
 
 func TestOpen(t *testing.T) {
@@ -26,6 +32,161 @@ func TestOpen(t *testing.T) {
 	}
 }
 
+func TestLocked(t *testing.T) {
+	jsonPath, tearDown := tempPath(t)
+	defer tearDown()
+
+	store, err := openPath(jsonPath)
+	tu.RequireNil(t, err)
+
+	_, err = openPath(jsonPath)
+	tu.RequireNotNil(t, err)
+
+	store.Close()
+	store, err = openPath(jsonPath)
+	tu.RequireNil(t, err)
+	store.Close()
+}
+
+func TestWriteKeepsBackup(t *testing.T) {
+	jsonPath, tearDown := tempPath(t)
+	defer tearDown()
+
+	store, err := openPath(jsonPath)
+	tu.RequireNil(t, err)
+	tu.RequireNil(t, store.Create(post.Post{Id: "1", Created: time.Now()}))
+	tu.RequireNil(t, store.Create(post.Post{Id: "2", Created: time.Now()}))
+	store.Close()
+
+	posts, err := readPosts(jsonPath)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(posts), 2)
+
+	posts, err = readPosts(jsonPath + ".bak")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(posts), 1)
+
+	// no temporary files left behind
+	files, _ := filepath.Glob(jsonPath + ".tmp*")
+	tu.ExpectEqual(t, len(files), 0)
+}
+
+func TestCorruptedFile(t *testing.T) {
+	jsonPath, tearDown := tempPath(t)
+	defer tearDown()
+
+	tu.RequireNil(t, ioutil.WriteFile(jsonPath, []byte(`[{"id": "1", "tit`), 0644))
+	_, err := openPath(jsonPath)
+	tu.RequireNotNil(t, err)
+
+	tu.RequireNil(t, ioutil.WriteFile(jsonPath, []byte(`{"id": "1"}`), 0644))
+	_, err = openPath(jsonPath)
+	tu.RequireNotNil(t, err)
+
+	// the lock is released again
+	tu.RequireNil(t, ioutil.WriteFile(jsonPath, []byte(`[]`), 0644))
+	store, err := openPath(jsonPath)
+	tu.RequireNil(t, err)
+	store.Close()
+}
+
+func TestFailedWriteIsUndone(t *testing.T) {
+	jsonPath, tearDown := tempPath(t)
+	defer tearDown()
+
+	store, err := openPath(jsonPath)
+	tu.RequireNil(t, err)
+	defer store.Close()
+
+	// writing fails if the directory is gone
+	s := store.(*Store)
+	s.path = path.Join(jsonPath+".missing", "posts.json")
+	tu.RequireNotNil(t, store.Create(post.Post{Id: "1", Created: time.Now()}))
+
+	posts, _ := store.FindAll()
+	tu.ExpectEqual(t, len(posts), 0)
+}
+
+func TestBatch(t *testing.T) {
+	jsonPath, tearDown := tempPath(t)
+	defer tearDown()
+
+	store, err := openPath(jsonPath)
+	tu.RequireNil(t, err)
+	s := store.(*Store)
+
+	// the last change fails, nothing is written
+	err = s.Batch(context.Background(), []storage.Op{
+		{Op: storage.OpCreate, Post: &post.Post{Id: "1", Created: time.Now()}},
+		{Op: storage.OpDelete, Id: "2"},
+	})
+	tu.ExpectNotNil(t, err)
+	posts, _ := store.FindAll()
+	tu.ExpectEqual(t, len(posts), 0)
+
+	err = s.Batch(context.Background(), []storage.Op{
+		{Op: storage.OpCreate, Post: &post.Post{Id: "1", Created: time.Now()}},
+		{Op: storage.OpCreate, Post: &post.Post{Id: "2", Created: time.Now()}},
+		{Op: storage.OpCreate, Post: &post.Post{Id: "3", Created: time.Now()}},
+	})
+	tu.RequireNil(t, err)
+	store.Close()
+
+	posts, err = readPosts(jsonPath)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(posts), 3)
+
+	// written once, the backup is the file before the batch
+	posts, err = readPosts(jsonPath + ".bak")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(posts), 0)
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	jsonPath, tearDown := tempPath(t)
+	defer tearDown()
+
+	store, err := openPath(jsonPath)
+	tu.RequireNil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("%d", i)
+			store.Create(post.Post{Id: id, Created: time.Now()})
+			store.Update(post.Post{Id: id, Title: "updated"})
+			store.FindAll()
+		}(i)
+	}
+	wg.Wait()
+	store.Close()
+
+	posts, err := readPosts(jsonPath)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(posts), 20)
+	for _, p := range posts {
+		tu.ExpectEqual(t, p.Title, "updated")
+	}
+}
+
+func tempPath(t *testing.T) (string, func()) {
+	tmpPath, err := ioutil.TempDir("", "gol_json_test")
+	if err != nil {
+		t.Fatal("Could not create temporary directory", err)
+	}
+
+	return path.Join(tmpPath, "posts.json"), func() {
+		os.RemoveAll(tmpPath)
+	}
+}
+
+func openPath(jsonPath string) (storage.Store, error) {
+	u, _ := url.Parse(fmt.Sprintf("json://%s", jsonPath))
+	return Backend{}.Open(u)
+}
+
 func BenchmarkCreate(b *testing.B) {
 	backend := Backend{}
 	tmpPath, err := ioutil.TempDir("", "gol_json_test")
@@ -49,3 +210,29 @@ func BenchmarkCreate(b *testing.B) {
 		})
 	}
 }
+
+func TestWatchAfterReopen(t *testing.T) {
+	jsonPath, tearDown := tempPath(t)
+	defer tearDown()
+
+	store, err := openPath(jsonPath)
+	tu.RequireNil(t, err)
+	tu.RequireNil(t, store.Create(post.Post{Id: "1", Title: "cats"}))
+	tu.RequireNil(t, store.Update(post.Post{Id: "1", Title: "kittens"}))
+	tu.RequireNil(t, store.Close())
+
+	store, err = openPath(jsonPath)
+	tu.RequireNil(t, err)
+	defer store.Close()
+	tu.RequireNil(t, store.Delete("1"))
+
+	events, err := store.(storage.Watcher).WatchFrom(context.Background(), 1)
+	tu.RequireNil(t, err)
+	e := <-events
+	tu.ExpectEqual(t, e.Seq, uint64(2))
+	tu.ExpectEqual(t, e.Type, storage.Updated)
+	tu.ExpectEqual(t, e.Post.Title, "kittens")
+	e = <-events
+	tu.ExpectEqual(t, e.Seq, uint64(3))
+	tu.ExpectEqual(t, e.Type, storage.Deleted)
+}
@@ -1,12 +1,16 @@
 package storage
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net/url"
+	"strings"
+	"time"
 
 	"../post"
+	"./migrate"
 	"./query"
 )
 
@@ -32,6 +36,28 @@ func Open(rawUrl string) (Store, error) {
 	}
 }
 
+// `rawUrl` without secrets, for logs and status pages: the password and
+// the values of all parameters (tokens, keys and nested urls) are masked
+func Redact(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "<invalid url>"
+	}
+	params := u.Query()
+	for name := range params {
+		params[name] = []string{"xxxxx"}
+	}
+	u.RawQuery = params.Encode()
+
+	redacted := u.Redacted()
+	// `url.URL` drops the slashes of urls without a host, e.g.
+	// `encrypted://?key=...`
+	if strings.HasPrefix(rawUrl, u.Scheme+"://") && !strings.HasPrefix(redacted, u.Scheme+"://") {
+		redacted = u.Scheme + "://" + strings.TrimPrefix(redacted, u.Scheme+":")
+	}
+	return redacted
+}
+
 type Backend interface {
 	Open(url *url.URL) (Store, error)
 }
@@ -52,6 +78,61 @@ type Store interface {
 
 // Reload = Close + Open
 
+// implemented by stores that can copy themselves while in use
+type Snapshotter interface {
+	// writes a consistent copy of the store to the directory `dir` and
+	// returns the url to open it with
+	Snapshot(ctx context.Context, dir string) (string, error)
+}
+
+// implemented by stores with a versioned schema (see the migrate package)
+type Migrator interface {
+	Migrate() error
+	MigrationStatus() ([]migrate.Status, error)
+}
+
+// sets the time `updated` was changed to now, unless the caller already
+// set a newer time than the one of the `old` version (e.g. when
+// replicating an update, which should have the same time everywhere).
+//
+// backends call this in `Update`.
+func MarkUpdated(updated *post.Post, old post.Post) {
+	if !updated.Updated.After(old.Updated) {
+		updated.Updated = time.Now()
+	}
+}
+
+type keepUpdatedKey struct{}
+
+// a context for updates that keep the update time the posts bring, even if
+// it isn't newer than the stored one (e.g. when only their encryption
+// changes).  backends honor it in `Batch`.
+func KeepUpdated(ctx context.Context) context.Context {
+	return context.WithValue(ctx, keepUpdatedKey{}, true)
+}
+
+// like `MarkUpdated`, but leaves the update time alone for `KeepUpdated`
+// contexts
+func MarkUpdatedContext(ctx context.Context, updated *post.Post, old post.Post) {
+	if keep, _ := ctx.Value(keepUpdatedKey{}).(bool); keep {
+		return
+	}
+	MarkUpdated(updated, old)
+}
+
+// whether all words of `text` are in the title or the content of `p`,
+// ignoring case.  this is what `?match=text:...` means for backends
+// without full-text search.
+func MatchesText(p post.Post, text string) bool {
+	haystack := strings.ToLower(p.Title + "\n" + p.Content)
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if !strings.Contains(haystack, word) {
+			return false
+		}
+	}
+	return true
+}
+
 func Query() query.Builder {
 	return query.New()
 }
@@ -1,8 +1,9 @@
 package memory
 
 import (
-	"errors"
+	"context"
 	"net/url"
+	"sync"
 
 	storage ".."
 	"../../post"
@@ -10,10 +11,17 @@ import (
 
 type Backend struct{}
 
+// safe for concurrent use
 type Store struct {
+	mu    sync.RWMutex
 	posts []post.Post
+	// the last `keepEvents` changes, for watchers.  created on first use,
+	// so that the zero value is ready to use.
+	feed *storage.Feed
 }
 
+const keepEvents = 1000
+
 func init() {
 	storage.Register("memory", Backend{})
 }
@@ -31,37 +39,183 @@ func FromPosts(posts []post.Post) *Store {
 
 // `Find` is implemented in `./query.go`
 
+// returns a copy, use `Update` to change it
 func (s *Store) FindById(id string) (*post.Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.indexOf(id)
+	if i == -1 {
+		return nil, storage.NotFoundError{Id: id}
+	}
+	p := s.posts[i]
+	return &p, nil
+}
+
+func (s *Store) indexOf(id string) int {
 	for i, post := range s.posts {
 		if post.Id == id {
-			return &s.posts[i], nil
+			return i
 		}
 	}
+	return -1
+}
 
-	return nil, errors.New("post not found")
+// whether another post already has the slug of `p` on its day
+func (s *Store) SlugTaken(p post.Post) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.slugTaken(p)
+}
+
+func (s *Store) slugTaken(p post.Post) bool {
+	for _, other := range s.posts {
+		if storage.SlugsCollide(p, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// adds or replaces `p` without any checks and without telling watchers,
+// for stores that keep an index of posts stored elsewhere (e.g. `dir`)
+func (s *Store) Put(p post.Post) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := s.indexOf(p.Id); i != -1 {
+		s.posts[i] = p
+		return
+	}
+	s.posts = append(s.posts, p)
 }
 
 func (s *Store) FindAll() ([]post.Post, error) {
-	return s.posts, nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	posts := make([]post.Post, len(s.posts))
+	copy(posts, s.posts)
+	return posts, nil
 }
 
 func (s *Store) Create(post post.Post) error {
-	s.posts = append(s.posts, post)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.create(post)
+	if err != nil {
+		return err
+	}
+	s.events().Publish(storage.Created, post.Id, &post)
 	return nil
 }
 
 func (s *Store) Update(updatedPost post.Post) error {
-	oldPost, err := s.FindById(updatedPost.Id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.update(context.Background(), updatedPost)
+	if err != nil {
+		return err
+	}
+	s.events().Publish(storage.Updated, p.Id, p)
+	return nil
+}
+
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.delete(id)
+	if err != nil {
+		return err
+	}
+	s.events().Publish(storage.Deleted, id, nil)
+	return nil
+}
+
+// applies all changes or, if one fails, none of them
+func (s *Store) Batch(ctx context.Context, ops []storage.Op) error {
+	err := storage.CheckOps(ops)
 	if err != nil {
 		return err
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// `update` changes posts in place
+	before := make([]post.Post, len(s.posts))
+	copy(before, s.posts)
+
+	changed := make([]*post.Post, len(ops))
+	for i, op := range ops {
+		switch op.Op {
+		case storage.OpCreate:
+			err = s.create(*op.Post)
+			changed[i] = op.Post
+		case storage.OpUpdate:
+			changed[i], err = s.update(ctx, *op.Post)
+		case storage.OpDelete:
+			err = s.delete(op.PostId())
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			s.posts = before
+			return storage.BatchError{Index: i, Op: op, Err: err}
+		}
+	}
+
+	for i, op := range ops {
+		s.events().Publish(op.EventType(), op.PostId(), changed[i])
+	}
+	return nil
+}
+
+// the following must be called with `s.mu` locked for writing
+
+func (s *Store) create(post post.Post) error {
+	if s.indexOf(post.Id) != -1 {
+		return storage.ConflictError{Id: post.Id}
+	}
+	if s.slugTaken(post) {
+		return storage.ConflictError{Id: post.Id, Slug: post.Slug}
+	}
+
+	s.posts = append(s.posts, post)
+	return nil
+}
+
+// returns the stored post
+func (s *Store) update(ctx context.Context, updatedPost post.Post) (*post.Post, error) {
+	i := s.indexOf(updatedPost.Id)
+	if i == -1 {
+		return nil, storage.NotFoundError{Id: updatedPost.Id}
+	}
+	oldPost := &s.posts[i]
+	if updatedPost.Slug != oldPost.Slug {
+		changed := *oldPost
+		changed.Slug = updatedPost.Slug
+		if s.slugTaken(changed) {
+			return nil, storage.ConflictError{Id: changed.Id, Slug: changed.Slug}
+		}
+	}
+
+	storage.MarkUpdatedContext(ctx, &updatedPost, *oldPost)
 	oldPost.Title = updatedPost.Title
 	oldPost.Content = updatedPost.Content
-	return nil
+	oldPost.Updated = updatedPost.Updated
+	oldPost.UpdatedBy = updatedPost.UpdatedBy
+	oldPost.Slug = updatedPost.Slug
+	oldPost.OldSlugs = updatedPost.OldSlugs
+	p := *oldPost
+	return &p, nil
 }
 
-func (s *Store) Delete(id string) error {
+func (s *Store) delete(id string) error {
 	newPosts := make([]post.Post, 0, len(s.posts))
 	foundPost := false
 
@@ -74,13 +228,35 @@ func (s *Store) Delete(id string) error {
 	}
 
 	if !foundPost {
-		return errors.New("post not found")
+		return storage.NotFoundError{Id: id}
 	}
 
 	s.posts = newPosts
 	return nil
 }
 
+func (s *Store) events() *storage.Feed {
+	if s.feed == nil {
+		// an empty memory log never fails
+		s.feed, _ = storage.NewFeed(storage.NewMemoryLog(keepEvents))
+	}
+	return s.feed
+}
+
+func (s *Store) Watch(ctx context.Context) <-chan storage.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events().Watch(ctx)
+}
+
+func (s *Store) WatchFrom(ctx context.Context, seq uint64) (<-chan storage.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events().WatchFrom(ctx, seq)
+}
+
 func (s *Store) Close() error {
-	return nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events().Close()
 }
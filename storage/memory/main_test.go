@@ -1,12 +1,17 @@
 package memory
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"testing"
 	"time"
 
+	storage ".."
 	"../../post"
+	tu "../../util/testing"
+	"../query"
 )
 
 func TestOpen(t *testing.T) {
@@ -44,3 +49,194 @@ func BenchmarkCreate(b *testing.B) {
 		})
 	}
 }
+
+func TestSlugs(t *testing.T) {
+	day := time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)
+	store := &Store{}
+
+	first := post.Post{Id: "1", Title: "Introducing the query interface", Created: day}
+	tu.RequireNil(t, storage.AssignSlug(store, &first))
+	tu.ExpectEqual(t, first.Slug, "introducing-the-query-interface")
+	store.Create(first)
+
+	// same title on the same day
+	second := post.Post{Id: "2", Title: "Introducing the query interface!", Created: day.Add(time.Hour)}
+	tu.RequireNil(t, storage.AssignSlug(store, &second))
+	tu.ExpectEqual(t, second.Slug, "introducing-the-query-interface-2")
+	store.Create(second)
+
+	// same title on another day
+	third := post.Post{Id: "3", Title: "Introducing the query interface", Created: day.AddDate(0, 0, 1)}
+	tu.RequireNil(t, storage.AssignSlug(store, &third))
+	tu.ExpectEqual(t, third.Slug, "introducing-the-query-interface")
+	store.Create(third)
+
+	p, isOld, err := storage.FindBySlug(store, day, "introducing-the-query-interface-2")
+	tu.RequireNil(t, err)
+	tu.RequireNotNil(t, p)
+	tu.ExpectEqual(t, p.Id, "2")
+	tu.ExpectEqual(t, isOld, false)
+
+	// retitling keeps the old slug for redirects
+	tu.RequireNil(t, storage.Retitle(store, &first, "Queries"))
+	tu.ExpectEqual(t, first.Slug, "queries")
+	tu.RequireEqual(t, len(first.OldSlugs), 1)
+	tu.ExpectEqual(t, first.OldSlugs[0], "introducing-the-query-interface")
+	store.Update(first)
+
+	p, isOld, _ = storage.FindBySlug(store, day, "introducing-the-query-interface")
+	tu.RequireNotNil(t, p)
+	tu.ExpectEqual(t, p.Id, "1")
+	tu.ExpectEqual(t, isOld, true)
+
+	// old slugs stay reserved
+	fourth := post.Post{Id: "4", Title: "Introducing the query interface", Created: day}
+	tu.RequireNil(t, storage.AssignSlug(store, &fourth))
+	tu.ExpectEqual(t, fourth.Slug, "introducing-the-query-interface-3")
+
+	// ... except for the post itself
+	tu.RequireNil(t, storage.Retitle(store, &first, "Introducing the query interface"))
+	tu.ExpectEqual(t, first.Slug, "introducing-the-query-interface")
+	tu.RequireEqual(t, len(first.OldSlugs), 1)
+	tu.ExpectEqual(t, first.OldSlugs[0], "queries")
+
+	p, _, _ = storage.FindBySlug(store, day, "no-such-post")
+	tu.ExpectNil(t, p)
+}
+
+func TestCreateDuplicate(t *testing.T) {
+	store := &Store{}
+	tu.RequireNil(t, store.Create(post.Post{Id: "test"}))
+	tu.ExpectNotNil(t, store.Create(post.Post{Id: "test"}))
+
+	posts, _ := store.FindAll()
+	tu.ExpectEqual(t, len(posts), 1)
+}
+
+func TestUniqueSlugs(t *testing.T) {
+	day := time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)
+	store := &Store{}
+	tu.RequireNil(t, store.Create(post.Post{Id: "1", Title: "cats", Slug: "cats", Created: day}))
+	tu.RequireNil(t, store.Create(post.Post{Id: "2", Title: "dogs", Slug: "dogs", Created: day}))
+
+	err := store.Create(post.Post{Id: "3", Title: "cats", Slug: "cats", Created: day.Add(time.Hour)})
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrConflict), true)
+	tu.ExpectEqual(t, err, storage.ConflictError{Id: "3", Slug: "cats"})
+	err = store.Update(post.Post{Id: "2", Title: "cats", Slug: "cats"})
+	tu.ExpectEqual(t, err, storage.ConflictError{Id: "2", Slug: "cats"})
+
+	// on another day, or without a slug
+	tu.RequireNil(t, store.Create(post.Post{Id: "4", Title: "cats", Slug: "cats", Created: day.AddDate(0, 0, 1)}))
+	tu.RequireNil(t, store.Create(post.Post{Id: "5", Title: "cats", Created: day}))
+	tu.RequireNil(t, store.Create(post.Post{Id: "6", Title: "cats", Created: day}))
+}
+
+// doesn't see the posts for the first `stale` queries, like a store
+// another post was just created in
+type staleStore struct {
+	*Store
+	stale int
+}
+
+func (s *staleStore) Find(q query.Query) ([]post.Post, error) {
+	if s.stale > 0 {
+		s.stale -= 1
+		return []post.Post{}, nil
+	}
+	return s.Store.Find(q)
+}
+
+func TestCreateWithSlug(t *testing.T) {
+	day := time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)
+	store := &staleStore{Store: &Store{}}
+	tu.RequireNil(t, store.Create(post.Post{Id: "1", Title: "cats", Slug: "cats", Created: day}))
+
+	store.stale = 1
+	p := post.Post{Id: "2", Title: "Cats!", Created: day}
+	tu.RequireNil(t, storage.CreateWithSlug(context.Background(), store, &p))
+	tu.ExpectEqual(t, p.Slug, "cats-2")
+
+	// gives up eventually
+	store.stale = 10
+	p = post.Post{Id: "3", Title: "cats", Created: day}
+	err := storage.CreateWithSlug(context.Background(), store, &p)
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrConflict), true)
+}
+
+func TestErrors(t *testing.T) {
+	store := &Store{}
+	tu.RequireNil(t, store.Create(post.Post{Id: "test"}))
+
+	err := store.Create(post.Post{Id: "test"})
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrConflict), true)
+	_, err = store.FindById("nope")
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrNotFound), true)
+	tu.ExpectEqual(t, errors.Is(store.Update(post.Post{Id: "nope"}), storage.ErrNotFound), true)
+	tu.ExpectEqual(t, errors.Is(store.Delete("nope"), storage.ErrNotFound), true)
+
+	_, err = storage.Query().SortBy("color").Build()
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrInvalidQuery), true)
+	q, _ := storage.Query().Build()
+	_, err = storage.Aggregate(store, "color", *q)
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrInvalidQuery), true)
+}
+
+func TestWatch(t *testing.T) {
+	s := &Store{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := s.Watch(ctx)
+
+	p := post.Post{Id: "1", Title: "cats"}
+	tu.RequireNil(t, s.Create(p))
+	p.Title = "kittens"
+	tu.RequireNil(t, s.Update(p))
+	tu.RequireNil(t, s.Delete(p.Id))
+	tu.ExpectNotNil(t, s.Delete(p.Id))
+
+	for i, typ := range []string{storage.Created, storage.Updated, storage.Deleted} {
+		e := <-events
+		tu.ExpectEqual(t, e.Seq, uint64(i+1))
+		tu.ExpectEqual(t, e.Type, typ)
+		tu.ExpectEqual(t, e.Id, "1")
+	}
+
+	resumed, err := s.WatchFrom(ctx, 1)
+	tu.RequireNil(t, err)
+	e := <-resumed
+	tu.ExpectEqual(t, e.Post.Title, "kittens")
+	tu.ExpectEqual(t, e.Post.Edited(), true)
+}
+
+func TestBatch(t *testing.T) {
+	s := &Store{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := s.Watch(ctx)
+	tu.RequireNil(t, s.Create(post.Post{Id: "1", Title: "cats"}))
+
+	// the last change fails, so none are made
+	err := s.Batch(context.Background(), []storage.Op{
+		{Op: storage.OpCreate, Post: &post.Post{Id: "2", Title: "dogs"}},
+		{Op: storage.OpUpdate, Post: &post.Post{Id: "1", Title: "kittens"}},
+		{Op: storage.OpDelete, Id: "3"},
+	})
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrNotFound), true)
+	posts, _ := s.FindAll()
+	tu.ExpectEqual(t, posts, []post.Post{{Id: "1", Title: "cats"}})
+
+	err = s.Batch(context.Background(), []storage.Op{
+		{Op: storage.OpCreate, Post: &post.Post{Id: "2", Title: "dogs"}},
+		{Op: storage.OpUpdate, Post: &post.Post{Id: "1", Title: "kittens"}},
+		{Op: storage.OpDelete, Id: "2"},
+	})
+	tu.RequireNil(t, err)
+	posts, _ = s.FindAll()
+	tu.RequireEqual(t, len(posts), 1)
+	tu.ExpectEqual(t, posts[0].Title, "kittens")
+
+	for _, typ := range []string{storage.Created, storage.Created, storage.Updated, storage.Deleted} {
+		e := <-events
+		tu.ExpectEqual(t, e.Type, typ)
+	}
+}
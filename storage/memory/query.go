@@ -6,22 +6,26 @@ import (
 	"sort"
 	"strings"
 
+	storage ".."
 	"../../post"
 	"../query"
 )
 
 func (s *Store) Find(q query.Query) ([]post.Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if q.Find != nil && q.Find.Name == "id" {
 		id, ok := q.Find.Value.(string)
 		if !ok {
-			return nil, errors.New("id must be a string")
+			return nil, storage.InvalidQuery(errors.New("id must be a string"))
 		}
 
-		p, err := s.FindById(id)
-		if err != nil {
-			return nil, err
+		i := s.indexOf(id)
+		if i == -1 {
+			return nil, storage.NotFoundError{Id: id}
 		}
-		return []post.Post{*p}, nil
+		return []post.Post{s.posts[i]}, nil
 	} else if q.Find != nil {
 		return s.runFind(q)
 	} else {
@@ -39,7 +43,7 @@ func (s *Store) runFind(q query.Query) ([]post.Post, error) {
 		case "title":
 			found = p.Title == q.Find.Value
 		default:
-			return nil, errors.New(fmt.Sprint("unsupported field:", q.Find.Name))
+			return nil, storage.InvalidQuery(errors.New(fmt.Sprint("unsupported field: ", q.Find.Name)))
 		}
 
 		if found {
@@ -67,15 +71,20 @@ func (s *Store) runQuery(q query.Query) ([]post.Post, error) {
 		return posts, nil
 	}
 
-	// sort and reverse
+	// sort and reverse (a copy, other readers might be using the posts)
+	sorted := make([]post.Post, len(s.posts))
+	copy(sorted, s.posts)
+
 	var sortable sort.Interface
 	switch q.SortBy {
 	case "created":
-		sortable = post.ByDate(s.posts)
+		sortable = post.ByDate(sorted)
+	case "updated":
+		sortable = post.ByUpdated(sorted)
 	case "title":
-		sortable = post.ByTitle(s.posts)
+		sortable = post.ByTitle(sorted)
 	default:
-		return nil, errors.New(fmt.Sprintf("sorting by %s not supported", q.SortBy))
+		return nil, storage.InvalidQuery(errors.New(fmt.Sprintf("sorting by %s not supported", q.SortBy)))
 	}
 
 	if q.Reverse {
@@ -86,8 +95,8 @@ func (s *Store) runQuery(q query.Query) ([]post.Post, error) {
 
 	// actually find the posts
 	n := 0
-	for _, post := range s.posts {
-		isMatch := queryMatches(q, post)
+	for _, post := range sorted {
+		isMatch := Matches(q, post)
 
 		if isMatch && n >= start {
 			posts = append(posts, post)
@@ -105,11 +114,19 @@ func (s *Store) runQuery(q query.Query) ([]post.Post, error) {
 	return posts, nil
 }
 
-func queryMatches(q query.Query, p post.Post) bool {
-	if q.RangeStart != nil && q.RangeEnd != nil {
-		if !(q.RangeStart.Unix() <= p.Created.Unix() && p.Created.Unix() <= q.RangeEnd.Unix()) {
-			return false
-		}
+// whether `p` is in the range and has the matches of `q`, ignoring its
+// sorting, start and count
+func Matches(q query.Query, p post.Post) bool {
+	// ranges may be open on either end
+	t := p.Created
+	if q.RangeBy == "updated" {
+		t = p.LastModified()
+	}
+	if q.RangeStart != nil && t.Before(*q.RangeStart) {
+		return false
+	}
+	if q.RangeEnd != nil && t.After(*q.RangeEnd) {
+		return false
 	}
 
 	for _, f := range q.Matches {
@@ -117,6 +134,11 @@ func queryMatches(q query.Query, p post.Post) bool {
 
 		// TODO: handle default case (error?)
 		switch f.Name {
+		case "text":
+			if !storage.MatchesText(p, f.Value.(string)) {
+				return false
+			}
+			continue
 		case "id":
 			val = p.Id
 		case "title":
@@ -132,3 +154,11 @@ func queryMatches(q query.Query, p post.Post) bool {
 
 	return true
 }
+
+func (s *Store) Aggregate(by string, q query.Query) ([]storage.Count, error) {
+	posts, err := s.Find(q)
+	if err != nil {
+		return nil, err
+	}
+	return storage.CountPosts(posts, by)
+}
@@ -11,8 +11,8 @@ import (
 )
 
 var examplePosts = []post.Post{
-	post.Post{"1", "first post", "something important!", time.Now()},
-	post.Post{"2", "second post", "a realization.", time.Now()},
+	post.Post{Id: "1", Title: "first post", Content: "something important!", Created: time.Now()},
+	post.Post{Id: "2", Title: "second post", Content: "a realization.", Created: time.Now()},
 }
 
 func TestFindOnlyId(t *testing.T) {
@@ -117,7 +117,7 @@ func TestFindCount(t *testing.T) {
 }
 
 func TestFindStartCount(t *testing.T) {
-	ps := append(examplePosts, post.Post{"3", "third post", "the end of an era", time.Now()})
+	ps := append(examplePosts, post.Post{Id: "3", Title: "third post", Content: "the end of an era", Created: time.Now()})
 	store := FromPosts(ps)
 
 	q, _ := storage.Query().Start(0).Count(3).Build()
@@ -131,7 +131,7 @@ func TestFindStartCount(t *testing.T) {
 }
 
 func TestFindSortBy(t *testing.T) {
-	ps := append(examplePosts, post.Post{"3", "a new beginning", "...", time.Now()})
+	ps := append(examplePosts, post.Post{Id: "3", Title: "a new beginning", Content: "...", Created: time.Now()})
 	store := FromPosts(ps)
 
 	q, _ := storage.Query().Build()
@@ -165,20 +165,42 @@ func TestFindMatch(t *testing.T) {
 	tu.ExpectEqual(t, posts[0].Content, "something important!")
 }
 
+func TestFindMatchText(t *testing.T) {
+	store := FromPosts(examplePosts)
+
+	// every word, anywhere, ignoring case
+	q, _ := storage.Query().Match("text", "FIRST important").Build()
+	posts := expectFindN(t, store, q, 1)
+	tu.ExpectEqual(t, posts[0].Id, "1")
+
+	q, _ = storage.Query().Match("text", "post realization").Build()
+	posts = expectFindN(t, store, q, 1)
+	tu.ExpectEqual(t, posts[0].Id, "2")
+
+	q, _ = storage.Query().Match("text", "first realization").Build()
+	expectFindN(t, store, q, 0)
+}
+
 func TestFindRange(t *testing.T) {
 	t1 := time.Date(2015, 3, 1, 12, 31, 0, 0, time.UTC)
 	t2 := time.Date(2015, 3, 2, 19, 21, 0, 0, time.UTC)
 	t3 := time.Date(2015, 3, 7, 11, 57, 0, 0, time.UTC)
 	ps := []post.Post{
-		post.Post{"1", "one", "yes!", t1},
-		post.Post{"2", "two", "maybe", t2},
-		post.Post{"3", "three", "no?", t3},
+		post.Post{Id: "1", Title: "one", Content: "yes!", Created: t1},
+		post.Post{Id: "2", Title: "two", Content: "maybe", Created: t2},
+		post.Post{Id: "3", Title: "three", Content: "no?", Created: t3},
 	}
 	store := FromPosts(ps)
 
 	q, _ := storage.Query().Range(t1, t2).Build()
 	expectFindN(t, store, q, 2)
 	expectOrder(t, store, q, []string{"1", "2"})
+
+	q, _ = storage.Query().Since(t2).Build()
+	expectOrder(t, store, q, []string{"2", "3"})
+
+	q, _ = storage.Query().Until(t2.Add(-time.Second)).Build()
+	expectOrder(t, store, q, []string{"1"})
 }
 
 func expectFindN(t *testing.T, store storage.Store, q *query.Query, n int) []post.Post {
@@ -201,3 +223,63 @@ func expectOrder(t *testing.T, store storage.Store, q *query.Query, ids []string
 
 	return posts
 }
+
+func TestAggregate(t *testing.T) {
+	ps := []post.Post{
+		post.Post{Id: "1", Content: "#ops", Created: time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)},
+		post.Post{Id: "2", Content: "#ops", Created: time.Date(2015, 9, 20, 10, 0, 0, 0, time.UTC)},
+		post.Post{Id: "3", Content: "#db", Created: time.Date(2015, 10, 1, 8, 0, 0, 0, time.UTC)},
+	}
+	store := FromPosts(ps)
+
+	q, _ := storage.Query().Build()
+	counts, err := storage.Aggregate(store, storage.ByMonth, *q)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(counts), 2)
+	tu.ExpectEqual(t, counts[0], storage.Count{Key: "2015-09", Count: 2})
+	tu.ExpectEqual(t, counts[1], storage.Count{Key: "2015-10", Count: 1})
+
+	q, _ = storage.Query().Match("content", "#ops").Build()
+	counts, err = storage.Aggregate(store, storage.ByTag, *q)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(counts), 1)
+	tu.ExpectEqual(t, counts[0], storage.Count{Key: "ops", Count: 2})
+}
+
+func TestFindUpdated(t *testing.T) {
+	t1 := time.Date(2015, 3, 1, 12, 31, 0, 0, time.UTC)
+	ps := []post.Post{
+		post.Post{Id: "1", Created: t1, Updated: t1.AddDate(0, 0, 5)},
+		post.Post{Id: "2", Created: t1.AddDate(0, 0, 1)},
+		post.Post{Id: "3", Created: t1.AddDate(0, 0, 2)},
+	}
+	store := FromPosts(ps)
+
+	q, _ := storage.Query().SortBy("updated").Build()
+	expectOrder(t, store, q, []string{"2", "3", "1"})
+
+	q, _ = storage.Query().SortBy("updated").RangeBy("updated").Since(t1.AddDate(0, 0, 2)).Build()
+	expectOrder(t, store, q, []string{"3", "1"})
+
+	q, _ = storage.Query().Since(t1.AddDate(0, 0, 2)).Build()
+	expectOrder(t, store, q, []string{"3"})
+}
+
+func TestUpdateSetsUpdated(t *testing.T) {
+	t1 := time.Date(2015, 3, 1, 12, 31, 0, 0, time.UTC)
+	store := FromPosts([]post.Post{post.Post{Id: "1", Title: "one", Created: t1}})
+
+	before := time.Now()
+	store.Update(post.Post{Id: "1", Title: "uno", Created: t1, UpdatedBy: "jane"})
+	p, _ := store.FindById("1")
+	tu.ExpectEqual(t, p.Title, "uno")
+	tu.ExpectEqual(t, p.UpdatedBy, "jane")
+	tu.ExpectEqual(t, p.Edited(), true)
+	tu.ExpectEqual(t, p.Updated.Before(before), false)
+
+	// given times are kept, e.g. for replication
+	replicated := time.Now().Add(time.Hour)
+	store.Update(post.Post{Id: "1", Title: "one", Created: t1, Updated: replicated})
+	p, _ = store.FindById("1")
+	tu.ExpectEqual(t, p.Updated, replicated)
+}
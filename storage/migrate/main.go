@@ -0,0 +1,262 @@
+// versioned schema migrations for sql backends
+//
+// the applied versions are recorded in a `schema_migrations` table, each
+// migration runs in its own transaction.  databases shared by several
+// instances of gol should set a lock (see `Migrator.LockWith`), so that
+// only one of them migrates at a time.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+}
+
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// how to write the n-th (starting at 1) placeholder of a query
+type Placeholder func(n int) string
+
+func Question(n int) string { return "?" }
+func Dollar(n int) string   { return fmt.Sprintf("$%d", n) }
+
+// returned when the database has been migrated by a newer version of gol
+type TooNewError struct {
+	Version   int // of the database
+	Supported int
+}
+
+func (e TooNewError) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than the supported version %d, upgrade gol to open it", e.Version, e.Supported)
+}
+
+// a `*sql.DB`, or a `*sql.Conn` while locked
+type conn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+type Migrator struct {
+	db          conn
+	placeholder Placeholder
+	migrations  []Migration
+	// statements taking and releasing a lock held by the session, none if
+	// empty
+	lock, unlock string
+}
+
+func New(db *sql.DB, placeholder Placeholder, migrations []Migration) *Migrator {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Sort(byVersion(sorted))
+
+	return &Migrator{db: db, placeholder: placeholder, migrations: sorted}
+}
+
+// runs `lock` before and `unlock` after reading or migrating the schema,
+// both on the same connection, e.g. with `pg_advisory_lock`
+func (m *Migrator) LockWith(lock, unlock string) *Migrator {
+	m.lock = lock
+	m.unlock = unlock
+	return m
+}
+
+// runs `f` with the lock held, with a migrator using the connection that
+// holds it (the pool might not have another one)
+func (m *Migrator) locked(f func(m *Migrator) error) error {
+	db, ok := m.db.(*sql.DB)
+	if m.lock == "" || !ok {
+		return f(m)
+	}
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	_, err = c.ExecContext(ctx, m.lock)
+	if err != nil {
+		return err
+	}
+	bound := *m
+	bound.db = c
+	err = f(&bound)
+	_, unlockErr := c.ExecContext(ctx, m.unlock)
+	if err != nil {
+		return err
+	}
+	return unlockErr
+}
+
+// the latest version known to this version of gol
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// the version of the database, 0 if no migration has been applied yet
+func (m *Migrator) Current() (int, error) {
+	var current int
+	err := m.locked(func(m *Migrator) error {
+		var err error
+		current, err = m.current()
+		return err
+	})
+	return current, err
+}
+
+func (m *Migrator) current() (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	current := 0
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+	return current, nil
+}
+
+// fails if the database is newer than this version of gol
+func (m *Migrator) Check() error {
+	return m.locked((*Migrator).check)
+}
+
+func (m *Migrator) check() error {
+	current, err := m.current()
+	if err != nil {
+		return err
+	}
+	if current > m.Latest() {
+		return TooNewError{current, m.Latest()}
+	}
+	return nil
+}
+
+// applies all pending migrations in order
+func (m *Migrator) Up() error {
+	return m.locked((*Migrator).up)
+}
+
+func (m *Migrator) up() error {
+	err := m.check()
+	if err != nil {
+		return err
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err = m.apply(migration)
+		if err != nil {
+			return errors.New(fmt.Sprintf("migration %d (%s) failed: %s", migration.Version, migration.Name, err))
+		}
+	}
+	return nil
+}
+
+// all known migrations and whether they have been applied
+func (m *Migrator) Status() ([]Status, error) {
+	var applied map[int]time.Time
+	err := m.locked(func(m *Migrator) error {
+		var err error
+		applied, err = m.applied()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, Status{migration.Version, migration.Name, ok, appliedAt})
+	}
+	return statuses, nil
+}
+
+func (m *Migrator) apply(migration Migration) error {
+	tx, err := m.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+
+	err = migration.Up(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO schema_migrations (version, name, applied_at) VALUES (%s, %s, %s)",
+		m.placeholder(1), m.placeholder(2), m.placeholder(3)),
+		migration.Version, migration.Name, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// version -> applied at
+func (m *Migrator) applied() (map[int]time.Time, error) {
+	ctx := context.Background()
+	_, err := m.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY, name TEXT, applied_at TEXT)")
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		t, _ := time.Parse(time.RFC3339, appliedAt)
+		applied[version] = t
+	}
+	return applied, rows.Err()
+}
+
+type byVersion []Migration
+
+func (m byVersion) Len() int           { return len(m) }
+func (m byVersion) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m byVersion) Less(i, j int) bool { return m[i].Version < m[j].Version }
@@ -2,21 +2,37 @@
 package multi
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/url"
+	"os"
+	"path"
+	"sync"
 
 	storage ".."
 	"../../post"
-	"../query"
 )
 
 type Backend struct{}
 
+// changes go to the primary first.  if that succeeds, they are queued for
+// each secondary, which are written in the background in the same order.
+// with `?queue=<dir>` the queues are kept on disk, so that secondaries
+// that are down catch up once they are back, even after a restart.
+//
+// reads go to the primary, unless another read policy is chosen with
+// `?read=` (see `./read.go`).
 type Store struct {
-	primary     storage.Store
-	secondaries map[string]storage.Store
+	primary    storage.Store
+	readPolicy string
+	// changes are made one at a time, so that the queues have the order
+	// of the primary
+	mu       sync.Mutex
+	replicas []*replica
 }
 
 func init() {
@@ -30,75 +46,267 @@ func (b Backend) Open(u *url.URL) (storage.Store, error) {
 	}
 	primary, err := storage.Open(primaryUrl)
 	if err != nil {
-		return nil, errors.New(fmt.Sprint("error opening primary store: ", err))
+		return nil, errors.New(fmt.Sprintf("error opening primary store '%s': %s", storage.Redact(primaryUrl), err))
 	}
 
-	secondaryUrls := u.Query()["secondary"]
-	secondaries := make(map[string]storage.Store, len(secondaryUrls))
-	for _, secondaryUrl := range secondaryUrls {
+	queueDir := u.Query().Get("queue")
+	if queueDir != "" {
+		err = os.MkdirAll(queueDir, 0700)
+		if err != nil {
+			primary.Close()
+			return nil, err
+		}
+	}
+
+	readPolicy := u.Query().Get("read")
+	if readPolicy == "" {
+		readPolicy = ReadPrimary
+	}
+	err = checkReadPolicy(readPolicy)
+	if err != nil {
+		primary.Close()
+		return nil, err
+	}
+
+	s := &Store{primary: primary, readPolicy: readPolicy}
+	// closes what has been opened so far
+	abort := func() {
+		s.Close()
+	}
+
+	for _, secondaryUrl := range u.Query()["secondary"] {
 		secondary, err := storage.Open(secondaryUrl)
 		if err != nil {
-			errors.New(fmt.Sprintf("error opening secondary store '%s': %s", secondaryUrl, err))
+			abort()
+			return nil, errors.New(fmt.Sprintf("error opening secondary store '%s': %s", storage.Redact(secondaryUrl), err))
 		}
-		secondaries[secondaryUrl] = secondary
+
+		err = s.addSecondary(secondaryUrl, secondary, queueDir)
+		if err != nil {
+			secondary.Close()
+			abort()
+			return nil, err
+		}
+	}
+
+	return storage.Store(s), nil
+}
+
+// the queue of a secondary is named after its url
+func queuePath(dir, secondaryUrl string) string {
+	if dir == "" {
+		return ""
 	}
+	hash := sha256.Sum256([]byte(secondaryUrl))
+	return path.Join(dir, fmt.Sprintf("%x.queue", hash[:8]))
+}
 
-	store := storage.Store(&Store{primary, secondaries})
-	return store, nil
+// the number of changes the replication to `secondaryUrl`, with the
+// queues in `dir` (see `?queue=`), gave up on
+func DeadLetters(dir, secondaryUrl string) (int, error) {
+	dead, err := readDeadLetters(queuePath(dir, secondaryUrl) + ".dead")
+	return len(dead), err
 }
 
-func (s *Store) Find(q query.Query) ([]post.Post, error) {
-	return s.primary.Find(q)
+// forgets the oldest `n` changes the replication to `secondaryUrl` gave up
+// on, once `Repair` made up for them
+func ClearDeadLetters(dir, secondaryUrl string, n int) error {
+	path := queuePath(dir, secondaryUrl) + ".dead"
+	dead, err := readDeadLetters(path)
+	if err != nil {
+		return err
+	}
+	if n >= len(dead) {
+		err = os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range dead[n:] {
+		err = enc.Encode(e)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
 }
 
-func (s *Store) FindById(id string) (*post.Post, error) {
-	return s.primary.FindById(id)
+func (s *Store) addSecondary(secondaryUrl string, secondary storage.Store, queueDir string) error {
+	q, err := openQueue(queuePath(queueDir, secondaryUrl))
+	if err != nil {
+		return err
+	}
+
+	r := newReplica(secondaryUrl, secondary, q)
+	s.replicas = append(s.replicas, r)
+	go r.run()
+	return nil
 }
 
-func (s *Store) FindAll() ([]post.Post, error) {
-	return s.primary.FindAll()
+// the replication status of each secondary
+func (s *Store) Status() []Status {
+	statuses := make([]Status, len(s.replicas))
+	for i, r := range s.replicas {
+		statuses[i] = r.status()
+	}
+	return statuses
 }
 
 func (s *Store) Create(p post.Post) error {
-	for u, s := range s.secondaries {
-		go func(secondaryUrl string, secondary storage.Store) {
-			err := secondary.Create(p)
-			if err != nil {
-				log.Printf("Error: [%s] create: %s", u, err)
-			}
-		}(u, s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the primary decides whether the id is unique
+	err := s.primary.Create(p)
+	if err != nil {
+		return err
 	}
 
-	return s.primary.Create(p)
+	s.replicate("create", p.Id, &p)
+	return nil
 }
 
 func (s *Store) Update(p post.Post) error {
-	for u, s := range s.secondaries {
-		go func(secondaryUrl string, secondary storage.Store) {
-			err := secondary.Update(p)
-			if err != nil {
-				log.Printf("Error: [%s] update: %s", u, err)
-			}
-		}(u, s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the same update time for all stores
+	if old, err := s.primary.FindById(p.Id); err == nil {
+		storage.MarkUpdated(&p, *old)
+	}
+
+	err := s.primary.Update(p)
+	if err != nil {
+		return err
 	}
 
-	return s.primary.Update(p)
+	s.replicate("update", p.Id, &p)
+	return nil
 }
 
 func (s *Store) Delete(id string) error {
-	for u, s := range s.secondaries {
-		go func(secondaryUrl string, secondary storage.Store) {
-			err := secondary.Delete(id)
-			if err != nil {
-				log.Printf("Error: [%s] delete: %s", secondaryUrl, err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.primary.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	s.replicate("delete", id, nil)
+	return nil
+}
+
+// applies the changes to the primary, atomically if it can, and then
+// queues them for the secondaries
+func (s *Store) Batch(ctx context.Context, ops []storage.Op) error {
+	err := storage.CheckOps(ops)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the same update times for all stores, without changing the posts of
+	// the caller
+	ops = append([]storage.Op(nil), ops...)
+	for i, op := range ops {
+		if op.Post == nil {
+			continue
+		}
+		p := *op.Post
+		if op.Op == storage.OpUpdate {
+			if old, err := s.primary.FindById(p.Id); err == nil {
+				storage.MarkUpdatedContext(ctx, &p, *old)
 			}
-		}(u, s)
+		}
+		ops[i].Post = &p
+	}
+
+	err = storage.Batch(ctx, s.primary, ops)
+	if err != nil {
+		return err
 	}
 
-	return s.primary.Delete(id)
+	for _, op := range ops {
+		s.replicate(op.Op, op.PostId(), op.Post)
+	}
+	return nil
 }
 
+// must be called with `s.mu` locked
+func (s *Store) replicate(op, id string, p *post.Post) {
+	for _, r := range s.replicas {
+		err := r.queue.push(op, id, p)
+		if err != nil {
+			log.Printf("Error: [%s] could not queue %s of %s: %s", r.url, op, id, err)
+		}
+		r.notify()
+	}
+}
+
+// whether the primary can be watched, the store only tells about changes
+// if it can
+func (s *Store) Watchable() bool {
+	_, ok := storage.CanWatch(s.primary)
+	return ok
+}
+
+// the events of the primary, which has all changes
+func (s *Store) Watch(ctx context.Context) <-chan storage.Event {
+	watcher, ok := storage.CanWatch(s.primary)
+	if !ok {
+		events := make(chan storage.Event)
+		close(events)
+		return events
+	}
+	return watcher.Watch(ctx)
+}
+
+func (s *Store) WatchFrom(ctx context.Context, seq uint64) (<-chan storage.Event, error) {
+	watcher, ok := storage.CanWatch(s.primary)
+	if !ok {
+		return nil, errors.New("the primary store can not be watched")
+	}
+	return watcher.WatchFrom(ctx, seq)
+}
+
+// stops replicating and closes all stores, the queues keep what has not
+// been written yet
 func (s *Store) Close() error {
-	// TODO
-	return nil
+	var err error
+	for _, r := range s.replicas {
+		if closeErr := r.close(); closeErr != nil {
+			err = closeErr
+		}
+		if closeErr := r.store.Close(); closeErr != nil {
+			log.Printf("Error: [%s] close: %s", r.url, closeErr)
+			err = closeErr
+		}
+	}
+	s.replicas = nil
+
+	if closeErr := s.primary.Close(); closeErr != nil {
+		err = closeErr
+	}
+	return err
 }
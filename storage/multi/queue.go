@@ -0,0 +1,298 @@
+package multi
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"../../post"
+)
+
+// a change waiting to be written to a secondary
+type entry struct {
+	Seq  uint64     `json:"seq"`
+	Op   string     `json:"op,omitempty"`
+	Id   string     `json:"id,omitempty"`
+	Post *post.Post `json:"post,omitempty"`
+	Time time.Time  `json:"time,omitempty"`
+	// marks all entries up to this one as written
+	Ack uint64 `json:"ack,omitempty"`
+}
+
+// the file is rewritten without the acked entries once there are this
+// many of them
+var compactAfter = 1000
+
+// an ordered write-ahead queue.  entries and acks are appended to a file,
+// one json object per line, which is truncated whenever the queue is
+// empty and compacted when many entries were acked.  entries that are
+// given up on are kept in another file (`<path>.dead`).  without a path
+// it is only kept in memory.
+type queue struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	entries []entry
+	seq     uint64
+	// the lines in the file that are not needed anymore
+	acked int
+	// without a file
+	dead []entry
+}
+
+func openQueue(path string) (*queue, error) {
+	q := &queue{path: path}
+	if path == "" {
+		return q, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	q.f = f
+
+	var acked uint64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var e entry
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			// the last line might be incomplete after a crash
+			log.Printf("skipping entry in %s: %s", path, err)
+			continue
+		}
+
+		q.acked += 1
+		if e.Ack > 0 {
+			acked = e.Ack
+			continue
+		}
+		q.entries = append(q.entries, e)
+		q.seq = e.Seq
+	}
+	if err = scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	for len(q.entries) > 0 && q.entries[0].Seq <= acked {
+		q.entries = q.entries[1:]
+	}
+	q.acked -= len(q.entries)
+	if acked > q.seq {
+		q.seq = acked
+	}
+	return q, nil
+}
+
+func (q *queue) push(op, id string, p *post.Post) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq += 1
+	e := entry{Seq: q.seq, Op: op, Id: id, Post: p, Time: time.Now()}
+	// kept even if it can't be written, so that it is replicated while
+	// gol is running
+	q.entries = append(q.entries, e)
+	return q.write(e)
+}
+
+// the oldest entry
+func (q *queue) peek() (entry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.entries) == 0 {
+		return entry{}, false
+	}
+	return q.entries[0], true
+}
+
+// removes the oldest entry, which must have `seq`
+func (q *queue) ack(seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.entries) == 0 || q.entries[0].Seq != seq {
+		return nil
+	}
+	return q.remove()
+}
+
+// removes the oldest entry, which must have `seq`, and keeps it with the
+// entries that were given up on
+func (q *queue) bury(seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.entries) == 0 || q.entries[0].Seq != seq {
+		return nil
+	}
+
+	if q.f == nil {
+		q.dead = append(q.dead, q.entries[0])
+		return q.remove()
+	}
+
+	f, err := os.OpenFile(q.path+".dead", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(q.entries[0])
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return q.remove()
+}
+
+// must be called with `q.mu` locked
+func (q *queue) remove() error {
+	seq := q.entries[0].Seq
+	q.entries = q.entries[1:]
+
+	if q.f == nil {
+		return nil
+	}
+	if len(q.entries) == 0 {
+		// the acks are not needed anymore
+		q.acked = 0
+		return q.f.Truncate(0)
+	}
+
+	q.acked += 2
+	if q.acked >= compactAfter {
+		return q.compact()
+	}
+	return q.write(entry{Seq: seq, Ack: seq})
+}
+
+// replaces the file with one that only has the entries still queued.
+// must be called with `q.mu` locked.
+func (q *queue) compact() error {
+	tmp := q.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range q.entries {
+		err = enc.Encode(e)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, q.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// the old file is gone, appends go to the new one
+	f, err = os.OpenFile(q.path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	q.f.Close()
+	q.f = f
+	q.acked = 0
+	return nil
+}
+
+func (q *queue) write(e entry) error {
+	if q.f == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = q.f.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	return q.f.Sync()
+}
+
+// the number of entries and the time of the oldest
+func (q *queue) pending() (int, time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.entries) == 0 {
+		return 0, time.Time{}
+	}
+	return len(q.entries), q.entries[0].Time
+}
+
+// the entries that were given up on, oldest first
+func (q *queue) deadLetters() ([]entry, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.path == "" {
+		return append([]entry(nil), q.dead...), nil
+	}
+	return readDeadLetters(q.path + ".dead")
+}
+
+func readDeadLetters(path string) ([]entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dead []entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var e entry
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			// the last line might be incomplete after a crash
+			continue
+		}
+		dead = append(dead, e)
+	}
+	return dead, scanner.Err()
+}
+
+func (q *queue) close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.f == nil {
+		return nil
+	}
+	err := q.f.Close()
+	q.f = nil
+	return err
+}
@@ -0,0 +1,142 @@
+package multi
+
+import (
+	"errors"
+	"fmt"
+
+	storage ".."
+	"../../post"
+	"../query"
+)
+
+// where reads go, chosen with `?read=`
+const (
+	// only the primary, which has all changes
+	ReadPrimary = "primary"
+	// the first healthy secondary if the primary fails
+	ReadFailover = "failover"
+	// the primary and all healthy secondaries at once, the first answer
+	// wins.  it might be from a secondary that is behind.
+	ReadFastest = "fastest"
+)
+
+func checkReadPolicy(policy string) error {
+	switch policy {
+	case ReadPrimary, ReadFailover, ReadFastest:
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("unknown read policy %#v, must be one of %s, %s or %s", policy, ReadPrimary, ReadFailover, ReadFastest))
+	}
+}
+
+// secondaries whose last write succeeded, in the order they were given
+func (s *Store) healthySecondaries() []storage.Store {
+	stores := []storage.Store{}
+	for _, r := range s.replicas {
+		if r.status().Healthy {
+			stores = append(stores, r.store)
+		}
+	}
+	return stores
+}
+
+// reads with `f` according to the read policy.  `byId` reads fail on
+// the primary if there is no such post, which is only a reason to fail
+// over if the primary doesn't answer other reads either.
+func (s *Store) read(byId bool, f func(store storage.Store) (interface{}, error)) (interface{}, error) {
+	switch s.readPolicy {
+	case ReadFailover:
+		result, err := f(s.primary)
+		if err == nil || (byId && s.primaryAnswers()) {
+			return result, err
+		}
+		for _, secondary := range s.healthySecondaries() {
+			result, secondaryErr := f(secondary)
+			if secondaryErr == nil {
+				return result, nil
+			}
+		}
+		return nil, err
+	case ReadFastest:
+		return fastest(append([]storage.Store{s.primary}, s.healthySecondaries()...), f)
+	default:
+		return f(s.primary)
+	}
+}
+
+func (s *Store) primaryAnswers() bool {
+	q, _ := storage.Query().Count(0).Build()
+	_, err := s.primary.Find(*q)
+	return err == nil
+}
+
+type result struct {
+	value interface{}
+	err   error
+	// of the store in the list
+	index int
+}
+
+// the first successful result, or the error of the first store if there
+// is none.  the slower stores are left to finish on their own.
+func fastest(stores []storage.Store, f func(store storage.Store) (interface{}, error)) (interface{}, error) {
+	results := make(chan result, len(stores))
+	for i, store := range stores {
+		go func(i int, store storage.Store) {
+			value, err := f(store)
+			results <- result{value, err, i}
+		}(i, store)
+	}
+
+	var firstErr error
+	for range stores {
+		r := <-results
+		if r.err == nil {
+			return r.value, nil
+		}
+		if r.index == 0 {
+			firstErr = r.err
+		}
+	}
+	return nil, firstErr
+}
+
+func (s *Store) Find(q query.Query) ([]post.Post, error) {
+	posts, err := s.read(false, func(store storage.Store) (interface{}, error) {
+		return store.Find(q)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posts.([]post.Post), nil
+}
+
+func (s *Store) FindById(id string) (*post.Post, error) {
+	p, err := s.read(true, func(store storage.Store) (interface{}, error) {
+		return store.FindById(id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p.(*post.Post), nil
+}
+
+func (s *Store) FindAll() ([]post.Post, error) {
+	posts, err := s.read(false, func(store storage.Store) (interface{}, error) {
+		return store.FindAll()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posts.([]post.Post), nil
+}
+
+func (s *Store) Aggregate(by string, q query.Query) ([]storage.Count, error) {
+	counts, err := s.read(false, func(store storage.Store) (interface{}, error) {
+		return storage.Aggregate(store, by, q)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts.([]storage.Count), nil
+}
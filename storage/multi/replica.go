@@ -0,0 +1,234 @@
+package multi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	storage ".."
+)
+
+// failed writes are retried after `minRetryDelay`, twice as long after
+// the next failure and so on
+var (
+	minRetryDelay = time.Second
+	maxRetryDelay = time.Minute
+)
+
+// a change the secondary rejected this many times is given up on, so that
+// it doesn't hold up the ones after it.  it is kept with the dead letters
+// (see `Status`), `Repair` makes up for it.  failures to read from the
+// secondary don't count, a secondary that is down gets all changes once it
+// is back.
+var maxAttempts = 10
+
+// how far behind a secondary is
+type Status struct {
+	// without the password
+	Url string `json:"url"`
+	// whether the last write succeeded
+	Healthy bool `json:"healthy"`
+	// the number of changes waiting to be written
+	Pending int `json:"pending"`
+	// how long the oldest of them has been waiting
+	Lag         time.Duration `json:"lag"`
+	LastError   string        `json:"last_error,omitempty"`
+	LastSuccess time.Time     `json:"last_success,omitempty"`
+	// the number of changes given up on, see `maxAttempts`
+	Dead int `json:"dead,omitempty"`
+}
+
+// a secondary with its queue, written by its own goroutine in order
+type replica struct {
+	url   string
+	store storage.Store
+	queue *queue
+
+	wake chan bool
+	stop chan bool
+	done chan bool
+
+	mu          sync.Mutex
+	healthy     bool
+	lastError   string
+	lastSuccess time.Time
+}
+
+func newReplica(rawUrl string, store storage.Store, q *queue) *replica {
+	return &replica{
+		url:     storage.Redact(rawUrl),
+		store:   store,
+		queue:   q,
+		wake:    make(chan bool, 1),
+		stop:    make(chan bool),
+		done:    make(chan bool),
+		healthy: true,
+	}
+}
+
+func (r *replica) notify() {
+	select {
+	case r.wake <- true:
+	default:
+	}
+}
+
+// writes the queued changes until stopped, retrying failed ones with
+// increasing delays
+func (r *replica) run() {
+	defer close(r.done)
+
+	delay := minRetryDelay
+	// of the oldest entry
+	attempts := 0
+	for {
+		e, ok := r.queue.peek()
+		if !ok {
+			select {
+			case <-r.wake:
+				continue
+			case <-r.stop:
+				return
+			}
+		}
+
+		err := apply(r.store, e)
+		r.report(e, err)
+		if err == nil {
+			delay = minRetryDelay
+			attempts = 0
+			err = r.queue.ack(e.Seq)
+			if err != nil {
+				log.Printf("Error: [%s] could not remove %s of %s from the queue: %s", r.url, e.Op, e.Id, err)
+			}
+			continue
+		}
+
+		if errors.As(err, &rejectedError{}) {
+			attempts += 1
+		}
+		if attempts >= maxAttempts {
+			log.Printf("Error: [%s] giving up on %s of %s after %d attempts, run `gol multi repair`", r.url, e.Op, e.Id, attempts)
+			delay = minRetryDelay
+			attempts = 0
+			err = r.queue.bury(e.Seq)
+			if err != nil {
+				log.Printf("Error: [%s] could not remove %s of %s from the queue: %s", r.url, e.Op, e.Id, err)
+			}
+			continue
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-r.stop:
+			return
+		}
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// the secondary could be read, but didn't take the change
+type rejectedError struct {
+	err error
+}
+
+func (e rejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e rejectedError) Unwrap() error {
+	return e.err
+}
+
+// writes a change so that it can be repeated, e.g. if gol stopped before
+// it was removed from the queue
+func apply(s storage.Store, e entry) error {
+	_, err := s.FindById(e.Id)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	err = write(s, e, err == nil)
+	if err != nil {
+		return rejectedError{err: err}
+	}
+	return nil
+}
+
+func write(s storage.Store, e entry, exists bool) error {
+	switch e.Op {
+	case "create", "update":
+		if e.Post == nil {
+			return errors.New(fmt.Sprintf("%s of %s without post", e.Op, e.Id))
+		}
+		if exists {
+			// the time of the primary, even if it isn't newer
+			ops := []storage.Op{{Op: storage.OpUpdate, Post: e.Post}}
+			return storage.Batch(storage.KeepUpdated(context.Background()), s, ops)
+		}
+		return s.Create(*e.Post)
+	case "delete":
+		if !exists {
+			return nil
+		}
+		return s.Delete(e.Id)
+	default:
+		return errors.New(fmt.Sprintf("unknown operation %#v", e.Op))
+	}
+}
+
+func (r *replica) report(e entry, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		if r.healthy {
+			log.Printf("Error: [%s] %s of %s: %s (retrying)", r.url, e.Op, e.Id, err)
+		}
+		r.healthy = false
+		r.lastError = err.Error()
+		return
+	}
+
+	if !r.healthy {
+		log.Printf("[%s] replicating again", r.url)
+	}
+	r.healthy = true
+	r.lastError = ""
+	r.lastSuccess = time.Now()
+}
+
+func (r *replica) status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := Status{
+		Url:         r.url,
+		Healthy:     r.healthy,
+		LastError:   r.lastError,
+		LastSuccess: r.lastSuccess,
+	}
+	var oldest time.Time
+	status.Pending, oldest = r.queue.pending()
+	if !oldest.IsZero() {
+		status.Lag = time.Since(oldest)
+	}
+	dead, err := r.queue.deadLetters()
+	if err != nil {
+		log.Printf("Error: [%s] could not read the changes given up on: %s", r.url, err)
+	}
+	status.Dead = len(dead)
+	return status
+}
+
+func (r *replica) close() error {
+	close(r.stop)
+	<-r.done
+	return r.queue.close()
+}
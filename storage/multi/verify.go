@@ -0,0 +1,132 @@
+package multi
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+
+	storage ".."
+	"../../post"
+)
+
+const (
+	// on the primary only
+	Missing = "missing"
+	// on the secondary only
+	Extra = "extra"
+	// on both, but not the same
+	Different = "different"
+)
+
+// a post that is not the same on the primary and a secondary
+type Difference struct {
+	Id   string `json:"id"`
+	Kind string `json:"kind"`
+}
+
+// what is compared between stores.  update times and old slugs are left
+// out, they differ after an update replicated as an update or as a create.
+func contentHash(p post.Post) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%d", p.Title, p.Content, p.Slug, p.Created.Unix())
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// the content hashes of all posts by id, read one post at a time
+func hashesById(s storage.Store) (map[string]string, error) {
+	q, err := storage.Query().Build()
+	if err != nil {
+		return nil, err
+	}
+
+	byId := map[string]string{}
+	err = storage.Each(context.Background(), s, *q, func(p post.Post) error {
+		byId[p.Id] = contentHash(p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return byId, nil
+}
+
+// the posts that differ between `primary` and `secondary`, by id
+func Verify(primary, secondary storage.Store) ([]Difference, error) {
+	expected, err := hashesById(primary)
+	if err != nil {
+		return nil, err
+	}
+	actual, err := hashesById(secondary)
+	if err != nil {
+		return nil, err
+	}
+
+	differences := []Difference{}
+	for id, hash := range expected {
+		other, ok := actual[id]
+		switch {
+		case !ok:
+			differences = append(differences, Difference{id, Missing})
+		case hash != other:
+			differences = append(differences, Difference{id, Different})
+		}
+	}
+	for id := range actual {
+		if _, ok := expected[id]; !ok {
+			differences = append(differences, Difference{id, Extra})
+		}
+	}
+
+	sort.Slice(differences, func(i, j int) bool {
+		return differences[i].Id < differences[j].Id
+	})
+	return differences, nil
+}
+
+// makes `secondary` the same as `primary`, returns what was different
+func Repair(primary, secondary storage.Store) ([]Difference, error) {
+	differences, err := Verify(primary, secondary)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range differences {
+		switch d.Kind {
+		case Extra:
+			err = secondary.Delete(d.Id)
+		case Missing:
+			err = copyPost(primary, secondary, d.Id, false)
+		case Different:
+			err = copyPost(primary, secondary, d.Id, true)
+		}
+		if err != nil {
+			return differences, errors.New(fmt.Sprintf("%s post %s: %s", d.Kind, d.Id, err))
+		}
+	}
+	return differences, nil
+}
+
+func copyPost(primary, secondary storage.Store, id string, exists bool) error {
+	p, err := primary.FindById(id)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		old, err := secondary.FindById(id)
+		if err != nil {
+			return err
+		}
+		// updates keep the creation time, start over if that is wrong
+		if old.Created.Unix() == p.Created.Unix() {
+			return secondary.Update(*p)
+		}
+		err = secondary.Delete(id)
+		if err != nil {
+			return err
+		}
+	}
+	return secondary.Create(*p)
+}
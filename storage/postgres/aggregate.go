@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	storage ".."
+	"../query"
+)
+
+// to_char formats for grouping by date, in utc like the other backends
+var groupFormats = map[string]string{
+	storage.ByDay:   "YYYY-MM-DD",
+	storage.ByMonth: "YYYY-MM",
+	storage.ByYear:  "YYYY",
+}
+
+func (s *Store) Aggregate(by string, q query.Query) ([]storage.Count, error) {
+	return s.AggregateContext(context.Background(), by, q)
+}
+
+func (s *Store) AggregateContext(ctx context.Context, by string, q query.Query) ([]storage.Count, error) {
+	format, ok := groupFormats[by]
+	if !ok {
+		// tags are not stored separately, count them in go
+		posts, err := s.FindContext(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		return storage.CountPosts(posts, by)
+	}
+
+	var a args
+	where, err := buildWhere(q, &a)
+	if err != nil {
+		return nil, err
+	}
+
+	sql := fmt.Sprintf("SELECT to_char(created AT TIME ZONE 'UTC', '%s') AS key, COUNT(*) FROM posts WHERE %s GROUP BY key ORDER BY key", format, where)
+	args := []interface{}(a)
+	if q.Start != -1 || q.Count != -1 {
+		// only the posts `Find` would return
+		page, pageArgs, err := buildSqlQuery(q)
+		if err != nil {
+			return nil, err
+		}
+		sql = fmt.Sprintf("SELECT to_char(created AT TIME ZONE 'UTC', '%s') AS key, COUNT(*) FROM (%s) AS page GROUP BY key ORDER BY key", format, page)
+		args = pageArgs
+	}
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]storage.Count, 0)
+	for rows.Next() {
+		var c storage.Count
+		err = rows.Scan(&c.Key, &c.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,308 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	storage ".."
+	"../../post"
+	"../migrate"
+	"github.com/lib/pq"
+)
+
+type Backend struct{}
+
+// the connection pool is configured with `?max_open_conns=10`,
+// `?max_idle_conns=5` and `?conn_max_lifetime=30m`, all other parameters
+// are passed on to the driver (e.g. `?sslmode=disable`).
+//
+// like for sqlite, pending migrations are applied on open, unless
+// `?migrate=false` is given.
+type Store struct {
+	db *sql.DB
+}
+
+func init() {
+	storage.Register("postgres", Backend{})
+}
+
+const postColumns = "id, created, title, content, slug, old_slugs, updated, updated_by"
+
+type poolOptions struct {
+	maxOpen     int
+	maxIdle     int
+	maxLifetime time.Duration
+}
+
+// removes the parameters handled by gol from the url
+func parseOptions(u *url.URL) (poolOptions, bool, error) {
+	opts := poolOptions{maxOpen: 10, maxIdle: 5, maxLifetime: 30 * time.Minute}
+	params := u.Query()
+
+	var err error
+	if v := params.Get("max_open_conns"); v != "" {
+		opts.maxOpen, err = strconv.Atoi(v)
+	}
+	if v := params.Get("max_idle_conns"); v != "" && err == nil {
+		opts.maxIdle, err = strconv.Atoi(v)
+	}
+	if v := params.Get("conn_max_lifetime"); v != "" && err == nil {
+		opts.maxLifetime, err = time.ParseDuration(v)
+	}
+	if err != nil {
+		return opts, false, errors.New(fmt.Sprintf("invalid connection pool option: %s", err))
+	}
+	migrate := params.Get("migrate") != "false"
+
+	for _, key := range []string{"max_open_conns", "max_idle_conns", "conn_max_lifetime", "migrate"} {
+		params.Del(key)
+	}
+	u.RawQuery = params.Encode()
+
+	return opts, migrate, nil
+}
+
+func (b Backend) Open(u *url.URL) (storage.Store, error) {
+	connUrl := *u
+	opts, doMigrate, err := parseOptions(&connUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("postgres", connUrl.String())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(opts.maxOpen)
+	db.SetMaxIdleConns(opts.maxIdle)
+	db.SetConnMaxLifetime(opts.maxLifetime)
+
+	// sql.Open doesn't connect yet
+	err = db.Ping()
+	if err == nil {
+		migrator := newMigrator(db)
+		if doMigrate {
+			err = migrator.Up()
+		} else {
+			err = migrator.Check()
+		}
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return storage.Store(&Store{db: db}), nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// reads a post selected with `postColumns`
+func scanPost(row scanner) (*post.Post, error) {
+	var p post.Post
+	var slug, updatedBy sql.NullString
+	var updated pq.NullTime
+	err := row.Scan(&p.Id, &p.Created, &p.Title, &p.Content, &slug, pq.Array(&p.OldSlugs), &updated, &updatedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Slug = slug.String
+	p.Updated = updated.Time
+	p.UpdatedBy = updatedBy.String
+	if len(p.OldSlugs) == 0 {
+		p.OldSlugs = nil
+	}
+	return &p, nil
+}
+
+// never edited posts have no update time
+func updatedTime(p post.Post) interface{} {
+	if !p.Edited() {
+		return nil
+	}
+	return p.Updated
+}
+
+// the operations stop when the context is done, the variants without one
+// never stop
+func (s *Store) FindById(id string) (*post.Post, error) {
+	return s.FindByIdContext(context.Background(), id)
+}
+
+func (s *Store) FindByIdContext(ctx context.Context, id string) (*post.Post, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+postColumns+" FROM posts WHERE id = $1", id)
+	p, err := scanPost(row)
+	if err == sql.ErrNoRows {
+		return nil, storage.NotFoundError{Id: id}
+	}
+	return p, err
+}
+
+func (s *Store) FindAll() ([]post.Post, error) {
+	return s.FindAllContext(context.Background())
+}
+
+func (s *Store) FindAllContext(ctx context.Context) ([]post.Post, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+postColumns+" FROM posts ORDER BY created DESC")
+	if err != nil {
+		return nil, err
+	}
+	return scanPosts(rows)
+}
+
+func scanPosts(rows *sql.Rows) ([]post.Post, error) {
+	defer rows.Close()
+
+	posts := make([]post.Post, 0)
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, *p)
+	}
+	return posts, rows.Err()
+}
+
+func (s *Store) Create(p post.Post) error {
+	return s.CreateContext(context.Background(), p)
+}
+
+func (s *Store) CreateContext(ctx context.Context, p post.Post) error {
+	return createPost(ctx, s.db, p)
+}
+
+// the old version is locked until the update is done, concurrent updates
+// of the same post happen one after the other
+func (s *Store) Update(updatedPost post.Post) error {
+	return s.UpdateContext(context.Background(), updatedPost)
+}
+
+func (s *Store) UpdateContext(ctx context.Context, updatedPost post.Post) error {
+	return s.inTransaction(ctx, func(tx *sql.Tx) error {
+		return updatePost(ctx, tx, updatedPost)
+	})
+}
+
+func (s *Store) Delete(id string) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *Store) DeleteContext(ctx context.Context, id string) error {
+	return deletePost(ctx, s.db, id)
+}
+
+// applies all changes in one transaction
+func (s *Store) Batch(ctx context.Context, ops []storage.Op) error {
+	err := storage.CheckOps(ops)
+	if err != nil {
+		return err
+	}
+
+	return s.inTransaction(ctx, func(tx *sql.Tx) error {
+		for i, op := range ops {
+			var err error
+			switch op.Op {
+			case storage.OpCreate:
+				err = createPost(ctx, tx, *op.Post)
+			case storage.OpUpdate:
+				err = updatePost(ctx, tx, *op.Post)
+			case storage.OpDelete:
+				err = deletePost(ctx, tx, op.PostId())
+			}
+			if err != nil {
+				return storage.BatchError{Index: i, Op: op, Err: err}
+			}
+		}
+		return nil
+	})
+}
+
+// a database or a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func createPost(ctx context.Context, db execer, p post.Post) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO posts ("+postColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		p.Id, p.Created, p.Title, p.Content, p.Slug, pq.Array(p.OldSlugs), updatedTime(p), p.UpdatedBy)
+	return conflict(err, p)
+}
+
+// a unique violation of the id or of the slug on its day
+func conflict(err error, p post.Post) error {
+	pqErr, ok := err.(*pq.Error)
+	if !ok || pqErr.Code != "23505" {
+		return err
+	}
+	if pqErr.Constraint == "posts_slug_idx" {
+		return storage.ConflictError{Id: p.Id, Slug: p.Slug}
+	}
+	return storage.ConflictError{Id: p.Id}
+}
+
+func updatePost(ctx context.Context, tx execer, updatedPost post.Post) error {
+	row := tx.QueryRowContext(ctx, "SELECT "+postColumns+" FROM posts WHERE id = $1 FOR UPDATE", updatedPost.Id)
+	oldPost, err := scanPost(row)
+	if err == sql.ErrNoRows {
+		return storage.NotFoundError{Id: updatedPost.Id}
+	} else if err != nil {
+		return err
+	}
+
+	storage.MarkUpdatedContext(ctx, &updatedPost, *oldPost)
+	_, err = tx.ExecContext(ctx, "UPDATE posts SET title = $2, content = $3, slug = $4, old_slugs = $5, updated = $6, updated_by = $7 WHERE id = $1",
+		updatedPost.Id, updatedPost.Title, updatedPost.Content, updatedPost.Slug, pq.Array(updatedPost.OldSlugs), updatedTime(updatedPost), updatedPost.UpdatedBy)
+	return conflict(err, updatedPost)
+}
+
+func deletePost(ctx context.Context, db execer, id string) error {
+	result, err := db.ExecContext(ctx, "DELETE FROM posts WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.NotFoundError{Id: id}
+	}
+	return nil
+}
+
+// runs `f` in a transaction, which is rolled back if `f` fails
+func (s *Store) inTransaction(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = f(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Migrate() error {
+	return newMigrator(s.db).Up()
+}
+
+func (s *Store) MigrationStatus() ([]migrate.Status, error) {
+	return newMigrator(s.db).Status()
+}
@@ -0,0 +1,349 @@
+package postgres
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	storage ".."
+	"../../post"
+	tu "../../util/testing"
+	"../memory"
+	"../query"
+)
+
+// the tests run against $GOL_TEST_POSTGRES (e.g.
+// postgres://localhost/gol_test?sslmode=disable), or against a temporary
+// server listening on a unix socket if postgres is installed.  they are
+// skipped otherwise, unless built with `-tags postgres` (`make
+// test-postgres`), which is what ci should run.
+var serverUrl string
+
+// set by `-tags postgres`
+var requireServer = false
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	serverUrl = os.Getenv("GOL_TEST_POSTGRES")
+	var stop func()
+	if serverUrl == "" {
+		var err error
+		serverUrl, stop, err = startServer()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "!!! NOT TESTING AGAINST POSTGRES, all postgres tests are skipped:", err)
+			fmt.Fprintln(os.Stderr, "!!! install postgres or set $GOL_TEST_POSTGRES, `make test-postgres` fails instead")
+			if requireServer {
+				os.Exit(1)
+			}
+		}
+	}
+
+	code := m.Run()
+	if stop != nil {
+		stop()
+	}
+	os.Exit(code)
+}
+
+func startServer() (string, func(), error) {
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		return "", nil, err
+	}
+	pgCtl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		return "", nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "gol_postgres_test")
+	if err != nil {
+		return "", nil, err
+	}
+	dataDir := path.Join(dir, "data")
+
+	out, err := exec.Command(initdb, "-D", dataDir, "-U", "gol", "--auth=trust").CombinedOutput()
+	if err == nil {
+		out, err = exec.Command(pgCtl, "start", "-w", "-D", dataDir, "-l", path.Join(dir, "log"),
+			"-o", fmt.Sprintf("-k %s -c listen_addresses='' -F", dir)).CombinedOutput()
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("%s: %s", err, out)
+	}
+
+	stop := func() {
+		exec.Command(pgCtl, "stop", "-D", dataDir, "-m", "immediate").Run()
+		os.RemoveAll(dir)
+	}
+	return fmt.Sprintf("postgres://gol@/postgres?host=%s&sslmode=disable", dir), stop, nil
+}
+
+var schemas = 0
+
+// a store in a new schema, which is removed afterwards
+func tSetup(t *testing.T) (*Store, func()) {
+	if serverUrl == "" {
+		t.Skip("no postgres server, run `make test-postgres` to require one")
+	}
+
+	db, err := sql.Open("postgres", serverUrl)
+	tu.RequireNil(t, err)
+
+	schemas += 1
+	schema := fmt.Sprintf("gol_test_%d_%d", os.Getpid(), schemas)
+	_, err = db.Exec("CREATE SCHEMA " + schema)
+	tu.RequireNil(t, err)
+
+	u, _ := url.Parse(serverUrl)
+	params := u.Query()
+	params.Set("search_path", schema)
+	u.RawQuery = params.Encode()
+
+	store, err := Backend{}.Open(u)
+	tu.RequireNil(t, err)
+
+	return store.(*Store), func() {
+		store.Close()
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		db.Close()
+	}
+}
+
+func examplePosts() []post.Post {
+	day := func(d int) time.Time {
+		return time.Date(2015, 9, d, 10, 0, 0, 0, time.UTC)
+	}
+	return []post.Post{
+		{Id: "1", Title: "cats", Content: "meow", Created: day(1)},
+		{Id: "2", Title: "birds", Content: "tweet, meow?", Created: day(3), Updated: day(20)},
+		{Id: "3", Title: "dogs", Content: "woof", Created: day(2)},
+		{Id: "4", Title: "another cat", Content: "meow!", Created: day(10), Updated: day(11)},
+		{Id: "5", Title: "fish", Content: "...", Created: day(14)},
+	}
+}
+
+// instances of gol starting together on a new database
+func TestConcurrentMigrations(t *testing.T) {
+	s, tearDown := tSetup(t)
+	defer tearDown()
+	_, err := s.db.Exec("DROP TABLE posts, schema_migrations")
+	tu.RequireNil(t, err)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = newMigrator(s.db).Up()
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		tu.ExpectNil(t, err)
+	}
+
+	statuses, err := s.MigrationStatus()
+	tu.RequireNil(t, err)
+	for _, status := range statuses {
+		tu.ExpectEqual(t, status.Applied, true)
+	}
+}
+
+func TestUniqueSlugs(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	day := time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)
+	tu.RequireNil(t, store.Create(post.Post{Id: "1", Title: "cats", Slug: "cats", Created: day}))
+	tu.RequireNil(t, store.Create(post.Post{Id: "2", Title: "dogs", Slug: "dogs", Created: day}))
+
+	// the same day in utc
+	cest := time.FixedZone("CEST", 2*60*60)
+	err := store.Create(post.Post{Id: "3", Title: "cats", Slug: "cats", Created: time.Date(2015, 9, 15, 1, 0, 0, 0, cest)})
+	tu.ExpectEqual(t, err, storage.ConflictError{Id: "3", Slug: "cats"})
+	err = store.Update(post.Post{Id: "2", Title: "cats", Slug: "cats", Created: day})
+	tu.ExpectEqual(t, err, storage.ConflictError{Id: "2", Slug: "cats"})
+
+	// on another day, or without a slug
+	tu.RequireNil(t, store.Create(post.Post{Id: "4", Title: "cats", Slug: "cats", Created: day.AddDate(0, 0, 1)}))
+	tu.RequireNil(t, store.Create(post.Post{Id: "5", Title: "cats", Created: day}))
+	tu.RequireNil(t, store.Create(post.Post{Id: "6", Title: "cats", Created: day}))
+}
+
+// the same counts as `Find` and the memory storage, paging included
+func TestAggregateLikeMemory(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	posts := []post.Post{}
+	day := time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 12; i++ {
+		posts = append(posts, post.Post{
+			Id:      fmt.Sprintf("%02d", i),
+			Title:   fmt.Sprintf("post %d", i),
+			Content: fmt.Sprintf("#tag%d", i%3),
+			Created: day.AddDate(0, 0, 10*i),
+		})
+	}
+	mem := memory.FromPosts(append([]post.Post(nil), posts...))
+	for _, p := range posts {
+		tu.RequireNil(t, store.Create(p))
+	}
+
+	for _, rawQuery := range []string{"", "start=3", "count=4", "start=2&count=5", "start=1&count=3&reverse=true", "count=0"} {
+		params, _ := url.ParseQuery(rawQuery)
+		q, err := query.FromParams(params)
+		tu.RequireNil(t, err)
+		for _, by := range []string{storage.ByDay, storage.ByMonth, storage.ByYear, storage.ByTag} {
+			expected, err := storage.Aggregate(mem, by, *q)
+			tu.RequireNil(t, err)
+			counts, err := storage.Aggregate(store, by, *q)
+			tu.RequireNil(t, err)
+			if !reflect.DeepEqual(counts, expected) {
+				t.Errorf("?%s by %s: %v, but memory has %v", rawQuery, by, counts, expected)
+			}
+		}
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	s, tearDown := tSetup(t)
+	defer tearDown()
+
+	statuses, err := s.MigrationStatus()
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(statuses), len(migrations))
+	for _, status := range statuses {
+		tu.ExpectEqual(t, status.Applied, true)
+	}
+}
+
+func TestCreateUpdateDelete(t *testing.T) {
+	s, tearDown := tSetup(t)
+	defer tearDown()
+
+	p := examplePosts()[0]
+	tu.RequireNil(t, s.Create(p))
+	tu.ExpectNotNil(t, s.Create(p))
+
+	p.Title = "kittens"
+	p.Slug = "kittens"
+	p.OldSlugs = []string{"cats"}
+	p.UpdatedBy = "jane"
+	tu.RequireNil(t, s.Update(p))
+
+	found, err := s.FindById(p.Id)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, found.Title, "kittens")
+	tu.ExpectEqual(t, found.OldSlugs, []string{"cats"})
+	tu.ExpectEqual(t, found.UpdatedBy, "jane")
+	tu.ExpectEqual(t, found.Edited(), true)
+	tu.ExpectEqual(t, found.Created.Equal(p.Created), true)
+
+	tu.RequireNil(t, s.Delete(p.Id))
+	tu.ExpectNotNil(t, s.Delete(p.Id))
+	tu.ExpectNotNil(t, s.Update(p))
+
+	_, err = s.FindById(p.Id)
+	tu.ExpectNotNil(t, err)
+}
+
+// the memory backend defines what queries mean
+func TestSameAsMemory(t *testing.T) {
+	s, tearDown := tSetup(t)
+	defer tearDown()
+
+	posts := examplePosts()
+	for _, p := range posts {
+		tu.RequireNil(t, s.Create(p))
+	}
+	m := memory.FromPosts(posts)
+
+	day := func(d int) time.Time {
+		return time.Date(2015, 9, d, 0, 0, 0, 0, time.UTC)
+	}
+	for i, q := range exampleQueries(day) {
+		expected, err := m.Find(*q)
+		tu.RequireNil(t, err)
+		actual, err := s.Find(*q)
+		tu.RequireNil(t, err)
+
+		tu.RequireEqual(t, len(actual), len(expected))
+		for j := range expected {
+			if actual[j].Id != expected[j].Id {
+				t.Errorf("query %d: expected %s at %d, got %s", i, expected[j].Id, j, actual[j].Id)
+			}
+		}
+	}
+}
+
+func TestFullTextSearch(t *testing.T) {
+	s, tearDown := tSetup(t)
+	defer tearDown()
+
+	for _, p := range examplePosts() {
+		tu.RequireNil(t, s.Create(p))
+	}
+
+	q, _ := storage.Query().Match("text", "Meow -cats").Build()
+	posts, err := s.Find(*q)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(posts), 2)
+
+	q, _ = storage.Query().Match("text", `"another cat"`).Build()
+	posts, err = s.Find(*q)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(posts), 1)
+	tu.ExpectEqual(t, posts[0].Id, "4")
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	s, tearDown := tSetup(t)
+	defer tearDown()
+
+	p := examplePosts()[0]
+	tu.RequireNil(t, s.Create(p))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			update := p
+			update.Content = fmt.Sprintf("version %d", i)
+			if err := s.Update(update); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	found, err := s.FindById(p.Id)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, found.Edited(), true)
+}
+
+func TestAggregate(t *testing.T) {
+	s, tearDown := tSetup(t)
+	defer tearDown()
+
+	for _, p := range examplePosts() {
+		tu.RequireNil(t, s.Create(p))
+	}
+
+	q, _ := storage.Query().Build()
+	counts, err := s.Aggregate(storage.ByMonth, *q)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, counts, []storage.Count{{Key: "2015-09", Count: 5}})
+}
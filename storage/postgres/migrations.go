@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"../migrate"
+)
+
+// the schema, in order.  never change a migration that has been released,
+// add a new one instead.
+var migrations = []migrate.Migration{
+	{Version: 1, Name: "create posts table", Up: func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE posts (
+			id TEXT NOT NULL PRIMARY KEY,
+			created TIMESTAMPTZ NOT NULL,
+			title TEXT NOT NULL,
+			content TEXT NOT NULL,
+			slug TEXT,
+			old_slugs TEXT[],
+			updated TIMESTAMPTZ,
+			updated_by TEXT
+		)`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("CREATE INDEX posts_created_idx ON posts (created)")
+		return err
+	}},
+	{Version: 2, Name: "add full-text search", Up: func(tx *sql.Tx) error {
+		// the `simple` configuration works for posts in any language, the
+		// title ranks higher than the content
+		_, err := tx.Exec(`ALTER TABLE posts ADD COLUMN search tsvector
+			GENERATED ALWAYS AS (
+				setweight(to_tsvector('simple', title), 'A') ||
+				setweight(to_tsvector('simple', content), 'B')
+			) STORED`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("CREATE INDEX posts_search_idx ON posts USING GIN (search)")
+		return err
+	}},
+	{Version: 3, Name: "unique slugs per day", Up: func(tx *sql.Tx) error {
+		// the day in utc, like in permalinks.  posts without a slug use
+		// one derived from their title.
+		_, err := tx.Exec("CREATE UNIQUE INDEX posts_slug_idx ON posts (((created AT TIME ZONE 'UTC')::date), slug) WHERE slug <> ''")
+		return err
+	}},
+}
+
+// the key of the advisory lock taken while migrating, so that instances of
+// gol starting together on the same database don't migrate it twice
+const migrationLock = 0x676f6c // "gol"
+
+func newMigrator(db *sql.DB) *migrate.Migrator {
+	return migrate.New(db, migrate.Dollar, migrations).LockWith(
+		fmt.Sprintf("SELECT pg_advisory_lock(%d)", migrationLock),
+		fmt.Sprintf("SELECT pg_advisory_unlock(%d)", migrationLock))
+}
@@ -0,0 +1,134 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	storage ".."
+	"../../post"
+	"../query"
+)
+
+// collects the arguments for the numbered placeholders of a query
+type args []interface{}
+
+func (a *args) add(value interface{}) string {
+	*a = append(*a, value)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+var matchColumns = map[string]string{
+	"id":      "id",
+	"title":   "title",
+	"content": "content",
+}
+
+var sortColumns = map[string]string{
+	"created": "created",
+	"updated": "COALESCE(updated, created)",
+	"title":   "title",
+}
+
+// the column ranges are for, posts that were never edited were last
+// changed when they were created
+func rangeColumn(q query.Query) string {
+	if q.RangeBy == "updated" {
+		return sortColumns["updated"]
+	}
+	return "created"
+}
+
+// the where clause for the conditions of `q`.  values are never part of
+// the query, they are added to `a`.
+func buildWhere(q query.Query, a *args) (string, error) {
+	var clauses []string
+
+	if q.Find != nil {
+		column, ok := matchColumns[q.Find.Name]
+		if !ok || column == "content" {
+			return "", storage.InvalidQuery(errors.New(fmt.Sprint("unsupported field: ", q.Find.Name)))
+		}
+		clauses = append(clauses, fmt.Sprintf("%s = %s", column, a.add(q.Find.Value)))
+	}
+
+	for _, m := range q.Matches {
+		if m.Name == "text" {
+			clauses = append(clauses,
+				fmt.Sprintf("search @@ websearch_to_tsquery('simple', %s)", a.add(m.Value)))
+			continue
+		}
+
+		column, ok := matchColumns[m.Name]
+		if !ok {
+			return "", storage.InvalidQuery(errors.New(fmt.Sprint("unsupported field: ", m.Name)))
+		}
+		clauses = append(clauses, fmt.Sprintf("strpos(%s, %s) > 0", column, a.add(m.Value)))
+	}
+
+	if q.RangeStart != nil {
+		clauses = append(clauses, fmt.Sprintf("%s >= %s", rangeColumn(q), a.add(*q.RangeStart)))
+	}
+	if q.RangeEnd != nil {
+		clauses = append(clauses, fmt.Sprintf("%s <= %s", rangeColumn(q), a.add(*q.RangeEnd)))
+	}
+
+	if len(clauses) == 0 {
+		return "TRUE", nil
+	}
+	return strings.Join(clauses, " AND "), nil
+}
+
+// returns the query and the arguments for its placeholders
+func buildSqlQuery(q query.Query) (string, []interface{}, error) {
+	var a args
+	where, err := buildWhere(q, &a)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sortColumn, ok := sortColumns[q.SortBy]
+	if !ok {
+		return "", nil, storage.InvalidQuery(errors.New(fmt.Sprintf("sorting by %s not supported", q.SortBy)))
+	}
+	order := "ASC"
+	if q.Reverse {
+		order = "DESC"
+	}
+
+	// the id keeps the order stable for posts with the same date or title
+	sql := fmt.Sprintf("SELECT %s FROM posts WHERE %s ORDER BY %s %s, id %s",
+		postColumns, where, sortColumn, order, order)
+
+	if q.Find != nil {
+		// only the first post is found
+		sql += " LIMIT 1"
+	} else {
+		if q.Count != -1 {
+			sql += " LIMIT " + a.add(q.Count)
+		}
+		if q.Start != -1 {
+			sql += " OFFSET " + a.add(q.Start)
+		}
+	}
+
+	return sql, a, nil
+}
+
+func (s *Store) Find(q query.Query) ([]post.Post, error) {
+	return s.FindContext(context.Background(), q)
+}
+
+func (s *Store) FindContext(ctx context.Context, q query.Query) ([]post.Post, error) {
+	sql, args, err := buildSqlQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanPosts(rows)
+}
@@ -0,0 +1,105 @@
+package postgres
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	storage ".."
+	tu "../../util/testing"
+	"../query"
+)
+
+// also run against the server in `TestSameAsMemory`
+func exampleQueries(day func(d int) time.Time) []*query.Query {
+	builders := []query.Builder{
+		storage.Query(),
+		storage.Query().Reverse(),
+		storage.Query().Find("id", "3"),
+		storage.Query().Find("title", "birds"),
+		storage.Query().Find("title", "bird"),
+		storage.Query().Start(1).Count(2),
+		storage.Query().Start(1).Count(2).Reverse(),
+		storage.Query().Start(10),
+		storage.Query().Count(0),
+		storage.Query().Match("content", "meow"),
+		storage.Query().Match("content", "meow").Start(1).Count(1).Reverse(),
+		storage.Query().SortBy("title"),
+		storage.Query().SortBy("title").Reverse().Count(3),
+		storage.Query().SortBy("updated"),
+		storage.Query().Range(day(2), day(10).Add(10*time.Hour)),
+		storage.Query().Range(day(2), day(3)).SortBy("title"),
+		storage.Query().Since(day(3)),
+		storage.Query().Until(day(3)).Reverse(),
+		storage.Query().Since(day(11)).RangeBy("updated").SortBy("updated"),
+	}
+
+	queries := make([]*query.Query, len(builders))
+	for i, b := range builders {
+		q, err := b.Build()
+		if err != nil {
+			panic(err)
+		}
+		queries[i] = q
+	}
+	return queries
+}
+
+func TestBuildSqlQuery(t *testing.T) {
+	start := time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2015, 9, 30, 0, 0, 0, 0, time.UTC)
+
+	q, _ := storage.Query().Build()
+	sql, args, err := buildSqlQuery(*q)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, sql, "SELECT "+postColumns+" FROM posts WHERE TRUE ORDER BY created ASC, id ASC")
+	tu.ExpectEqual(t, len(args), 0)
+
+	q, _ = storage.Query().Match("title", "'; DROP TABLE posts; --").Match("text", "cats dogs").
+		Range(start, end).RangeBy("updated").SortBy("title").Reverse().Start(5).Count(10).Build()
+	sql, args, err = buildSqlQuery(*q)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, sql, "SELECT "+postColumns+" FROM posts WHERE "+
+		"strpos(title, $1) > 0 AND search @@ websearch_to_tsquery('simple', $2) AND "+
+		"COALESCE(updated, created) >= $3 AND COALESCE(updated, created) <= $4 "+
+		"ORDER BY title DESC, id DESC LIMIT $5 OFFSET $6")
+	tu.ExpectEqual(t, args, []interface{}{"'; DROP TABLE posts; --", "cats dogs", start, end, 10, 5})
+
+	q, _ = storage.Query().Find("title", "cats").Build()
+	sql, args, err = buildSqlQuery(*q)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, sql, "SELECT "+postColumns+" FROM posts WHERE title = $1 ORDER BY created ASC, id ASC LIMIT 1")
+	tu.ExpectEqual(t, args, []interface{}{"cats"})
+
+	day := func(d int) time.Time {
+		return time.Date(2015, 9, d, 0, 0, 0, 0, time.UTC)
+	}
+	for _, q := range exampleQueries(day) {
+		_, _, err = buildSqlQuery(*q)
+		tu.ExpectNil(t, err)
+	}
+
+	_, _, err = buildSqlQuery(query.Query{Start: -1, Count: -1, SortBy: "content"})
+	tu.ExpectNotNil(t, err)
+	_, _, err = buildSqlQuery(query.Query{Start: -1, Count: -1, SortBy: "created",
+		Find: &query.Field{Name: "content", Value: "meow"}})
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrInvalidQuery), true)
+	_, _, err = buildSqlQuery(query.Query{Start: -1, Count: -1, SortBy: "created",
+		Matches: []query.Field{{Name: "slug", Value: "cats"}}})
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrInvalidQuery), true)
+	tu.ExpectEqual(t, err.Error(), "unsupported field: slug")
+}
+
+func TestParseOptions(t *testing.T) {
+	u, _ := url.Parse("postgres://localhost/gol?sslmode=disable&max_open_conns=3&conn_max_lifetime=1m&migrate=false")
+	opts, migrate, err := parseOptions(u)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, opts, poolOptions{maxOpen: 3, maxIdle: 5, maxLifetime: time.Minute})
+	tu.ExpectEqual(t, migrate, false)
+	tu.ExpectEqual(t, u.String(), "postgres://localhost/gol?sslmode=disable")
+
+	u, _ = url.Parse("postgres://localhost/gol?max_idle_conns=many")
+	_, _, err = parseOptions(u)
+	tu.ExpectNotNil(t, err)
+}
@@ -0,0 +1,7 @@
+//go:build postgres
+
+package postgres
+
+func init() {
+	requireServer = true
+}
@@ -0,0 +1,162 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the current time, replaced in tests
+var now = time.Now
+
+// a span of time, both ends inclusive
+type period struct {
+	start time.Time
+	end   time.Time
+}
+
+// parses an expression describing a span of time, relative to `now` and
+// in the timezone `loc` (unless the expression specifies one itself).
+//
+// supported are:
+//
+//	2015, 2015-09, 2015-09-14        a calendar year, month or day
+//	2015-09-14T10:00:00+02:00        a single point in time (RFC3339)
+//	today, yesterday                 the current or the previous day
+//	this-week, last-week             weeks start on monday
+//	this-month, last-month
+//	this-year, last-year
+//	12h, 7d, 2w, 3m, 1y              relative: from that long ago until now
+//	                                 (hours, days, weeks, months, years)
+//
+// `relative` is true for the last form, for which `since`/`until` only
+// use the starting point.
+func parsePeriod(expr string, now time.Time, loc *time.Location) (p period, relative bool, err error) {
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	switch expr {
+	case "today":
+		return days(today, 1), false, nil
+	case "yesterday":
+		return days(today.AddDate(0, 0, -1), 1), false, nil
+	case "this-week":
+		return days(startOfWeek(today), 7), false, nil
+	case "last-week":
+		return days(startOfWeek(today).AddDate(0, 0, -7), 7), false, nil
+	case "this-month":
+		return months(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc), 1), false, nil
+	case "last-month":
+		return months(time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, loc), 1), false, nil
+	case "this-year":
+		return months(time.Date(now.Year(), 1, 1, 0, 0, 0, 0, loc), 12), false, nil
+	case "last-year":
+		return months(time.Date(now.Year()-1, 1, 1, 0, 0, 0, 0, loc), 12), false, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, expr); err == nil {
+		return period{t, t}, false, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", expr, loc); err == nil {
+		return days(t, 1), false, nil
+	}
+	if t, err := time.ParseInLocation("2006-01", expr, loc); err == nil {
+		return months(t, 1), false, nil
+	}
+	if len(expr) == 4 {
+		if t, err := time.ParseInLocation("2006", expr, loc); err == nil {
+			return months(t, 12), false, nil
+		}
+	}
+
+	if ago, err := parseRelative(expr, now); err == nil {
+		return period{ago, now}, true, nil
+	} else if err != errNotRelative {
+		return period{}, false, err
+	}
+
+	return period{}, false, errors.New(fmt.Sprintf("invalid date expression '%s'", expr))
+}
+
+var errNotRelative = errors.New("not a relative date expression")
+
+func parseRelative(expr string, now time.Time) (time.Time, error) {
+	if len(expr) < 2 {
+		return time.Time{}, errNotRelative
+	}
+
+	n, err := strconv.ParseUint(expr[:len(expr)-1], 10, 32)
+	if err != nil {
+		return time.Time{}, errNotRelative
+	}
+	i := int(n)
+
+	switch expr[len(expr)-1] {
+	case 'h':
+		return now.Add(-time.Duration(i) * time.Hour), nil
+	case 'd':
+		return now.AddDate(0, 0, -i), nil
+	case 'w':
+		return now.AddDate(0, 0, -7*i), nil
+	case 'm':
+		return now.AddDate(0, -i, 0), nil
+	case 'y':
+		return now.AddDate(-i, 0, 0), nil
+	default:
+		return time.Time{}, errors.New(fmt.Sprintf("unknown unit in '%s' (must be one of h, d, w, m, y)", expr))
+	}
+}
+
+// parses the value of the `range` parameter, either `start,end` or a
+// single expression understood by `parsePeriod`
+func parseRange(v string, now time.Time, loc *time.Location) (period, error) {
+	if !strings.Contains(v, ",") {
+		p, _, err := parsePeriod(v, now, loc)
+		return p, err
+	}
+
+	rangePair := strings.Split(v, ",")
+	if len(rangePair) != 2 {
+		return period{}, errors.New(fmt.Sprintf("range must be of the format `start,end`, but was '%s'", v))
+	}
+	start, err := parsePoint(rangePair[0], now, loc, true)
+	if err != nil {
+		return period{}, errors.New(fmt.Sprint("invalid range start: ", err))
+	}
+	end, err := parsePoint(rangePair[1], now, loc, false)
+	if err != nil {
+		return period{}, errors.New(fmt.Sprint("invalid range end: ", err))
+	}
+	return period{start, end}, nil
+}
+
+// the beginning (`isStart`) or end of the period described by `expr`
+//
+// for relative expressions, this is always the point in the past, so
+// that `until=2d` means "until two days ago".
+func parsePoint(expr string, now time.Time, loc *time.Location, isStart bool) (time.Time, error) {
+	p, relative, err := parsePeriod(expr, now, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if isStart || relative {
+		return p.start, nil
+	}
+	return p.end, nil
+}
+
+func startOfWeek(day time.Time) time.Time {
+	// time.Weekday starts on sunday
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+func days(start time.Time, n int) period {
+	return period{start, start.AddDate(0, 0, n).Add(-time.Nanosecond)}
+}
+
+func months(start time.Time, n int) period {
+	return period{start, start.AddDate(0, n, 0).Add(-time.Nanosecond)}
+}
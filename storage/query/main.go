@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,16 +23,23 @@ type Query struct {
 	Matches    []Field
 	RangeStart *time.Time
 	RangeEnd   *time.Time
+	RangeBy    string // the date the range applies to, "created" or "updated"
 	SortBy     string
 	Reverse    bool
 }
 
 // default is to get all posts, sorted by created date
-var Default = Query{nil, -1, -1, nil, nil, nil, "created", false}
+var Default = Query{
+	Start:   -1,
+	Count:   -1,
+	RangeBy: "created",
+	SortBy:  "created",
+}
 
 func IsDefault(q Query) bool {
 	return q.Find == nil && q.Start == -1 && q.Count == -1 && q.Matches == nil &&
-		q.RangeStart == nil && q.RangeEnd == nil && q.SortBy == "created" && !q.Reverse
+		q.RangeStart == nil && q.RangeEnd == nil && q.RangeBy == "created" &&
+		q.SortBy == "created" && !q.Reverse
 }
 
 type Builder interface {
@@ -40,6 +48,9 @@ type Builder interface {
 	Count(pos uint) Builder
 	Match(field string, value interface{}) Builder // partial match
 	Range(start, end time.Time) Builder
+	Since(start time.Time) Builder // open-ended range
+	Until(end time.Time) Builder   // open-ended range
+	RangeBy(field string) Builder  // "created" (default) or "updated"
 	SortBy(field string) Builder
 	Reverse() Builder
 	Build() (*Query, error)
@@ -72,7 +83,7 @@ func (b *DefaultBuilder) Count(count uint) Builder {
 }
 
 func (b *DefaultBuilder) Match(field string, value interface{}) Builder {
-	if err := valueIn("match", field, []string{"id", "title", "content"}); err != nil {
+	if err := valueIn("match", field, []string{"id", "title", "content", "text"}); err != nil {
 		return Invalid{err}
 	}
 	b.query.Matches = append(b.query.Matches, Field{field, value})
@@ -88,8 +99,34 @@ func (b *DefaultBuilder) Range(start, end time.Time) Builder {
 	return b
 }
 
+func (b *DefaultBuilder) Since(start time.Time) Builder {
+	if b.query.RangeEnd != nil && start.After(*b.query.RangeEnd) {
+		return Invalid{errors.New("empty range")}
+	}
+	b.query.RangeStart = &start
+	return b
+}
+
+func (b *DefaultBuilder) Until(end time.Time) Builder {
+	if b.query.RangeStart != nil && end.Before(*b.query.RangeStart) {
+		return Invalid{errors.New("empty range")}
+	}
+	b.query.RangeEnd = &end
+	return b
+}
+
+func (b *DefaultBuilder) RangeBy(field string) Builder {
+	if err := valueIn("range_by", field, []string{"created", "updated"}); err != nil {
+		return Invalid{err}
+	}
+	b.query.RangeBy = field
+	return b
+}
+
+// sorting by "updated" uses the created date for posts that have never
+// been edited
 func (b *DefaultBuilder) SortBy(field string) Builder {
-	if err := valueIn("sort", field, []string{"title", "created"}); err != nil {
+	if err := valueIn("sort", field, []string{"title", "created", "updated"}); err != nil {
 		return Invalid{err}
 	}
 	b.query.SortBy = field
@@ -128,11 +165,34 @@ func (q Invalid) Start(pos uint) Builder                        { return q }
 func (q Invalid) Count(pos uint) Builder                        { return q }
 func (q Invalid) Match(field string, value interface{}) Builder { return q }
 func (q Invalid) Range(start, end time.Time) Builder            { return q }
+func (q Invalid) Since(start time.Time) Builder                 { return q }
+func (q Invalid) Until(end time.Time) Builder                   { return q }
+func (q Invalid) RangeBy(field string) Builder                  { return q }
 func (q Invalid) SortBy(field string) Builder                   { return q }
 func (q Invalid) Reverse() Builder                              { return q }
 
 func (q Invalid) Build() (*Query, error) {
-	return nil, q.Err
+	return nil, invalid(q.Err)
+}
+
+// all errors of invalid queries are one, check with
+// `errors.Is(err, query.ErrInvalid)`
+var ErrInvalid = errors.New("invalid query")
+
+// what is wrong with a query
+type Error struct {
+	Err error
+}
+
+func (e Error) Error() string        { return e.Err.Error() }
+func (e Error) Unwrap() error        { return e.Err }
+func (e Error) Is(target error) bool { return target == ErrInvalid }
+
+func invalid(err error) error {
+	if _, ok := err.(Error); ok {
+		return err
+	}
+	return Error{Err: err}
 }
 
 // build from query params
@@ -143,12 +203,45 @@ func (q Invalid) Build() (*Query, error) {
 // Reverse() == ?reverse
 // Matches("title", "cool") == ?match=title:cool
 // Matches("title", "cool").Matches("content", "wow") == ?match=title:cool&match=content:cool
+//
+// ranges also accept relative and calendar expressions (see `parsePeriod`),
+// interpreted in the timezone given by `tz` (default: local time):
+//
+// ?range=7d, ?range=last-week, ?range=2015-09-01,2015-09-14
+// Since(...) == ?since=2015-09
+// Until(...) == ?until=yesterday
+// Range(...) == ?on=2015-09-14&tz=Europe/Berlin
+// RangeBy("updated").Since(...) == ?since=7d&range_by=updated
 func FromParams(params url.Values) (*Query, error) {
+	q, err := parseParams(params)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	return q, nil
+}
+
+func parseParams(params url.Values) (*Query, error) {
 	b := New()
 
-	for key, vals := range params {
-		//fmt.Println(key, vals)
+	loc := time.Local
+	if tz := params.Get("tz"); tz != "" {
+		var err error
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			return nil, errors.New(fmt.Sprint("invalid timezone: ", err))
+		}
+	}
+	now := now()
 
+	// sorted, so that the outcome does not depend on map order
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		vals := params[key]
 		v := vals[0]
 		switch key {
 		case "id":
@@ -181,20 +274,26 @@ func FromParams(params url.Values) (*Query, error) {
 				}
 				b = b.Match(matchPair[0], matchPair[1])
 			}
-		case "range":
-			rangePair := strings.Split(v, ",")
-			if len(rangePair) != 2 {
-				return nil, errors.New(fmt.Sprintf("range must be of the format `start,end`, but was '%s'", v))
+		case "range", "on":
+			p, err := parseRange(v, now, loc)
+			if err != nil {
+				return nil, err
 			}
-			start, err := time.Parse(time.RFC3339, rangePair[0])
+			b = b.Range(p.start, p.end)
+		case "range_by":
+			b = b.RangeBy(v)
+		case "since":
+			start, err := parsePoint(v, now, loc, true)
 			if err != nil {
-				return nil, errors.New(fmt.Sprint("invalid range start: ", err))
+				return nil, errors.New(fmt.Sprint("invalid since: ", err))
 			}
-			end, err := time.Parse(time.RFC3339, rangePair[1])
+			b = b.Since(start)
+		case "until":
+			end, err := parsePoint(v, now, loc, false)
 			if err != nil {
-				return nil, errors.New(fmt.Sprint("invalid range and: ", err))
+				return nil, errors.New(fmt.Sprint("invalid until: ", err))
 			}
-			b = b.Range(start, end)
+			b = b.Until(end)
 		}
 	}
 
@@ -204,7 +303,7 @@ func FromParams(params url.Values) (*Query, error) {
 func parsePos(name, s string) (uint, error) {
 	i, err := strconv.ParseUint(s, 10, 32)
 	if err != nil {
-		return 0, errors.New(fmt.Sprint("invalid %s value:", name, err))
+		return 0, errors.New(fmt.Sprintf("invalid %s value: %s", name, err))
 	}
 	return uint(i), nil
 }
@@ -228,7 +327,14 @@ func ToParams(q Query) url.Values {
 		vals["match"] = ms
 	}
 	if q.RangeStart != nil && q.RangeEnd != nil {
-		vals["range"] = []string{fmt.Sprintf("%s,%s", q.RangeStart.Format(time.RFC3339), q.RangeEnd.Format(time.RFC3339))}
+		vals["range"] = []string{fmt.Sprintf("%s,%s", q.RangeStart.Format(time.RFC3339Nano), q.RangeEnd.Format(time.RFC3339Nano))}
+	} else if q.RangeStart != nil {
+		vals["since"] = []string{q.RangeStart.Format(time.RFC3339Nano)}
+	} else if q.RangeEnd != nil {
+		vals["until"] = []string{q.RangeEnd.Format(time.RFC3339Nano)}
+	}
+	if q.RangeBy != "" && q.RangeBy != "created" {
+		vals["range_by"] = []string{q.RangeBy}
 	}
 	vals["sort"] = []string{q.SortBy}
 	vals["reverse"] = []string{fmt.Sprint(q.Reverse)}
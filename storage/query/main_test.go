@@ -82,6 +82,12 @@ func TestInvalidBuildMustError(t *testing.T) {
 	if q, err := b.Build(); q != nil || err == nil {
 		t.Fail()
 	}
+
+	_, err := b.Build()
+	tu.ExpectEqual(t, errors.Is(err, ErrInvalid), true)
+	tu.ExpectEqual(t, err.Error(), "oops")
+	_, err = FromParams(url.Values{"count": {"many"}})
+	tu.ExpectEqual(t, errors.Is(err, ErrInvalid), true)
 }
 
 func TestFromParamsFind(t *testing.T) {
@@ -183,3 +189,142 @@ func fromParams(t *testing.T, rawUrl string) (*Query, error) {
 	}
 	return q, nil
 }
+
+func TestSinceUntil(t *testing.T) {
+	t1 := time.Date(2015, 3, 3, 9, 35, 0, 0, time.UTC)
+	t2 := time.Date(2015, 3, 9, 10, 50, 0, 0, time.UTC)
+
+	q, err := New().Since(t1).Build()
+	tu.RequireNil(t, err)
+	tu.RequireNotNil(t, q.RangeStart)
+	tu.ExpectEqual(t, *q.RangeStart, t1)
+	tu.ExpectNil(t, q.RangeEnd)
+
+	q, err = New().Until(t2).Build()
+	tu.RequireNil(t, err)
+	tu.ExpectNil(t, q.RangeStart)
+	tu.RequireNotNil(t, q.RangeEnd)
+	tu.ExpectEqual(t, *q.RangeEnd, t2)
+
+	_, err = New().Since(t2).Until(t1).Build()
+	tu.ExpectNotNil(t, err)
+}
+
+func TestFromParamsRelative(t *testing.T) {
+	defer fixNow(time.Date(2015, 9, 16, 14, 30, 0, 0, time.UTC))()
+
+	q, _ := fromParams(t, "http://not.es/find?range=7d&tz=UTC")
+	expectRange(t, q, time.Date(2015, 9, 9, 14, 30, 0, 0, time.UTC), time.Date(2015, 9, 16, 14, 30, 0, 0, time.UTC))
+
+	q, _ = fromParams(t, "http://not.es/find?range=12h&tz=UTC")
+	expectRange(t, q, time.Date(2015, 9, 16, 2, 30, 0, 0, time.UTC), time.Date(2015, 9, 16, 14, 30, 0, 0, time.UTC))
+
+	// 2015-09-16 is a wednesday
+	q, _ = fromParams(t, "http://not.es/find?range=last-week&tz=UTC")
+	expectRange(t, q, time.Date(2015, 9, 7, 0, 0, 0, 0, time.UTC), endOfDay(2015, 9, 13, time.UTC))
+
+	q, _ = fromParams(t, "http://not.es/find?range=this-month&tz=UTC")
+	expectRange(t, q, time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC), endOfDay(2015, 9, 30, time.UTC))
+
+	q, _ = fromParams(t, "http://not.es/find?range=2015-09-01,2015-09-14&tz=UTC")
+	expectRange(t, q, time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC), endOfDay(2015, 9, 14, time.UTC))
+}
+
+func TestFromParamsCalendar(t *testing.T) {
+	defer fixNow(time.Date(2015, 9, 16, 14, 30, 0, 0, time.UTC))()
+
+	q, _ := fromParams(t, "http://not.es/find?since=2015-09&tz=UTC")
+	tu.RequireNotNil(t, q.RangeStart)
+	tu.ExpectEqual(t, *q.RangeStart, time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC))
+	tu.ExpectNil(t, q.RangeEnd)
+
+	q, _ = fromParams(t, "http://not.es/find?until=yesterday&tz=UTC")
+	tu.ExpectNil(t, q.RangeStart)
+	tu.RequireNotNil(t, q.RangeEnd)
+	tu.ExpectEqual(t, *q.RangeEnd, endOfDay(2015, 9, 15, time.UTC))
+
+	q, _ = fromParams(t, "http://not.es/find?until=2d&tz=UTC")
+	tu.RequireNotNil(t, q.RangeEnd)
+	tu.ExpectEqual(t, *q.RangeEnd, time.Date(2015, 9, 14, 14, 30, 0, 0, time.UTC))
+
+	q, _ = fromParams(t, "http://not.es/find?on=2015&tz=UTC")
+	expectRange(t, q, time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), endOfDay(2015, 12, 31, time.UTC))
+
+	q, _ = fromParams(t, "http://not.es/find?since=2015-09-01&until=2015-09-14&tz=UTC")
+	expectRange(t, q, time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC), endOfDay(2015, 9, 14, time.UTC))
+}
+
+func TestFromParamsTimezone(t *testing.T) {
+	defer fixNow(time.Date(2015, 9, 16, 23, 30, 0, 0, time.UTC))()
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no timezone data:", err)
+	}
+
+	q, _ := fromParams(t, "http://not.es/find?on=2015-09-14&tz=Europe/Berlin")
+	expectRange(t, q, time.Date(2015, 9, 14, 0, 0, 0, 0, berlin), endOfDay(2015, 9, 14, berlin))
+	tu.ExpectEqual(t, q.RangeStart.UTC(), time.Date(2015, 9, 13, 22, 0, 0, 0, time.UTC))
+
+	// it's already the 17th in berlin
+	q, _ = fromParams(t, "http://not.es/find?on=today&tz=Europe/Berlin")
+	expectRange(t, q, time.Date(2015, 9, 17, 0, 0, 0, 0, berlin), endOfDay(2015, 9, 17, berlin))
+}
+
+func TestFromParamsInvalidDates(t *testing.T) {
+	invalid := []string{"range=7x", "range=someday", "since=2015-13", "on=tomorrow", "until=2015-09-14&tz=Nowhere/Special"}
+	for _, params := range invalid {
+		vals, _ := url.ParseQuery(params)
+		if _, err := FromParams(vals); err == nil {
+			t.Errorf("%s should be invalid", params)
+		}
+	}
+}
+
+func TestToParamsOpenRange(t *testing.T) {
+	start := time.Date(2015, 9, 1, 0, 0, 0, 0, time.UTC)
+	q, _ := New().Since(start).Build()
+
+	q2, err := FromParams(ToParams(*q))
+	tu.RequireNil(t, err)
+	tu.RequireNotNil(t, q2.RangeStart)
+	tu.ExpectEqual(t, q2.RangeStart.Equal(start), true)
+	tu.ExpectNil(t, q2.RangeEnd)
+}
+
+func fixNow(t time.Time) func() {
+	now = func() time.Time { return t }
+	return func() {
+		now = time.Now
+	}
+}
+
+func endOfDay(year int, month time.Month, day int, loc *time.Location) time.Time {
+	return time.Date(year, month, day+1, 0, 0, 0, 0, loc).Add(-time.Nanosecond)
+}
+
+func expectRange(t *testing.T, q *Query, start, end time.Time) {
+	tu.RequireNotNil(t, q.RangeStart)
+	tu.RequireNotNil(t, q.RangeEnd)
+	if !q.RangeStart.Equal(start) || !q.RangeEnd.Equal(end) {
+		t.Errorf("%s,%s != %s,%s", q.RangeStart, q.RangeEnd, start, end)
+	}
+}
+
+func TestFromParamsUpdated(t *testing.T) {
+	q, _ := fromParams(t, "http://not.es/find")
+	tu.ExpectEqual(t, q.RangeBy, "created")
+
+	q, _ = fromParams(t, "http://not.es/find?since=2015-09-01T00:00:00Z&range_by=updated&sort=updated")
+	tu.ExpectEqual(t, q.RangeBy, "updated")
+	tu.ExpectEqual(t, q.SortBy, "updated")
+	tu.RequireNotNil(t, q.RangeStart)
+
+	q2, err := FromParams(ToParams(*q))
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, q2.RangeBy, "updated")
+
+	vals, _ := url.ParseQuery("range_by=title")
+	_, err = FromParams(vals)
+	tu.ExpectNotNil(t, err)
+}
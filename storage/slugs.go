@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"../post"
+)
+
+// slugs are unique per day (in utc), which is part of the permalink.  all
+// of this only needs `Find`, so it works with every backend.  backends
+// that can refuse posts with a taken slug (see `SlugsCollide`) return a
+// `ConflictError` with the slug, so that another one can be tried.
+
+// sets the slug of a new post, derived from its title
+func AssignSlug(s Store, p *post.Post) error {
+	slug, err := uniqueSlug(s, *p, post.Slugify(p.Title))
+	if err != nil {
+		return err
+	}
+	p.Slug = slug
+	return nil
+}
+
+// how often `CreateWithSlug` looks for a free slug
+const slugAttempts = 3
+
+// creates `p` with a slug derived from its title, looking for another one
+// if a post created at the same time took it
+func CreateWithSlug(ctx context.Context, s Store, p *post.Post) error {
+	var err error
+	for i := 0; i < slugAttempts; i++ {
+		err = AssignSlug(s, p)
+		if err != nil {
+			return err
+		}
+
+		err = WithContext(s).CreateContext(ctx, *p)
+		var conflict ConflictError
+		if !errors.As(err, &conflict) || conflict.Slug == "" {
+			return err
+		}
+	}
+	return err
+}
+
+// whether `a` and `b` are different posts with the same stored slug on
+// the same day
+func SlugsCollide(a, b post.Post) bool {
+	if a.Id == b.Id || a.Slug == "" || a.Slug != b.Slug {
+		return false
+	}
+	return a.Created.UTC().Format("2006-01-02") == b.Created.UTC().Format("2006-01-02")
+}
+
+// changes the title of `p` and its slug along with it.  the previous slug
+// is kept so that old links can be redirected.
+func Retitle(s Store, p *post.Post, title string) error {
+	current := p.PermalinkSlug()
+	p.Title = title
+
+	slug, err := uniqueSlug(s, *p, post.Slugify(title))
+	if err != nil {
+		return err
+	}
+	if slug == current {
+		p.Slug = current
+		return nil
+	}
+
+	oldSlugs := make([]string, 0, len(p.OldSlugs)+1)
+	for _, old := range p.OldSlugs {
+		if old != slug {
+			oldSlugs = append(oldSlugs, old)
+		}
+	}
+	if !p.HasOldSlug(current) {
+		oldSlugs = append(oldSlugs, current)
+	}
+	p.Slug = slug
+	p.OldSlugs = oldSlugs
+	return nil
+}
+
+// finds the post created on `day` with the given slug.  if it's one of
+// its old slugs, `isOld` is true and the post should be redirected to.
+//
+// returns a nil post if there is no such post.
+func FindBySlug(s Store, day time.Time, slug string) (p *post.Post, isOld bool, err error) {
+	posts, err := postsOnDay(s, day)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for i, p := range posts {
+		if p.PermalinkSlug() == slug {
+			return &posts[i], false, nil
+		}
+	}
+	for i, p := range posts {
+		if p.HasOldSlug(slug) {
+			return &posts[i], true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// `base`, or `base-2`, `base-3`, ... if other posts on the same day
+// already use it
+func uniqueSlug(s Store, p post.Post, base string) (string, error) {
+	others, err := postsOnDay(s, p.Created)
+	if err != nil {
+		return "", err
+	}
+
+	taken := map[string]bool{}
+	for _, other := range others {
+		if other.Id == p.Id {
+			continue
+		}
+		taken[other.PermalinkSlug()] = true
+		for _, old := range other.OldSlugs {
+			taken[old] = true
+		}
+	}
+
+	slug := base
+	for i := 2; taken[slug]; i++ {
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+	return slug, nil
+}
+
+func postsOnDay(s Store, day time.Time) ([]post.Post, error) {
+	day = day.UTC()
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 1).Add(-time.Nanosecond)
+
+	q, err := Query().Range(start, end).Build()
+	if err != nil {
+		return nil, err
+	}
+	return s.Find(*q)
+}
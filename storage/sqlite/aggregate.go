@@ -0,0 +1,64 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+
+	storage ".."
+	"../query"
+)
+
+// strftime formats for grouping by date, sqlite converts to utc
+var groupFormats = map[string]string{
+	storage.ByDay:   "%Y-%m-%d",
+	storage.ByMonth: "%Y-%m",
+	storage.ByYear:  "%Y",
+}
+
+func (s *Store) Aggregate(by string, q query.Query) ([]storage.Count, error) {
+	return s.AggregateContext(context.Background(), by, q)
+}
+
+func (s *Store) AggregateContext(ctx context.Context, by string, q query.Query) ([]storage.Count, error) {
+	format, ok := groupFormats[by]
+	if !ok {
+		// tags are not stored separately, count them in go
+		posts, err := s.FindContext(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		return storage.CountPosts(posts, by)
+	}
+
+	where, args := buildWhere(q)
+	sqlQuery := fmt.Sprintf("SELECT strftime('%s', created) AS key, COUNT(*) FROM posts WHERE %s GROUP BY key ORDER BY key", format, where)
+	if q.Start != -1 || q.Count != -1 {
+		// only the posts `Find` would return
+		offset := 0
+		if q.Start > 0 {
+			offset = q.Start
+		}
+		page, pageArgs, err := buildSqlQuery(q, nil, q.Count, offset)
+		if err != nil {
+			return nil, err
+		}
+		sqlQuery = fmt.Sprintf("SELECT strftime('%s', created) AS key, COUNT(*) FROM (%s) GROUP BY key ORDER BY key", format, page)
+		args = pageArgs
+	}
+	rows, err := s.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]storage.Count, 0)
+	for rows.Next() {
+		var c storage.Count
+		err = rows.Scan(&c.Key, &c.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
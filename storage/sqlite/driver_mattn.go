@@ -0,0 +1,60 @@
+//go:build cgo && !purego
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	drivers["mattn"] = driver{
+		sqlName: "sqlite3",
+		dsn:     func(path string) string { return path },
+		backup:  onlineBackup,
+	}
+}
+
+// copies a few pages at a time, so that writers are not blocked for long
+func onlineBackup(ctx context.Context, db *sql.DB, path string) error {
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(d interface{}) error {
+		return srcConn.Raw(func(s interface{}) error {
+			b, err := d.(*sqlite3.SQLiteConn).Backup("main", s.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			for {
+				done, err := b.Step(256)
+				if err == nil {
+					err = ctx.Err()
+				}
+				if err != nil {
+					b.Finish()
+					return err
+				}
+				if done {
+					return b.Finish()
+				}
+			}
+		})
+	})
+}
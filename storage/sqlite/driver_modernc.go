@@ -0,0 +1,14 @@
+package sqlite
+
+import (
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	drivers["modernc"] = driver{
+		sqlName: "sqlite",
+		// dates are written like mattn does, which sqlite's date
+		// functions understand
+		dsn: func(path string) string { return path + "?_time_format=sqlite" },
+	}
+}
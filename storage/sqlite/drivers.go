@@ -0,0 +1,58 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the sqlite drivers gol can use, each registered by its own file:
+//
+//	mattn    github.com/mattn/go-sqlite3, needs cgo (left out when
+//	         building with `-tags purego` or `CGO_ENABLED=0`)
+//	modernc  modernc.org/sqlite, pure go
+//
+// both read and write the same database files.  `?driver=` selects one,
+// the default is mattn if it is built in.
+var drivers = map[string]driver{}
+
+type driver struct {
+	// the name the driver has in `database/sql`
+	sqlName string
+	// the data source name for a path, with options for the driver
+	dsn func(path string) string
+	// copies the open database to `path` with sqlite's online backup api,
+	// nil if the driver doesn't offer it
+	backup func(ctx context.Context, db *sql.DB, path string) error
+}
+
+func openDriver(name string, path string) (*sql.DB, error) {
+	d, err := findDriver(name)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open(d.sqlName, d.dsn(path))
+}
+
+func findDriver(name string) (driver, error) {
+	if name == "" {
+		name = "modernc"
+		if _, ok := drivers["mattn"]; ok {
+			name = "mattn"
+		}
+	}
+
+	d, ok := drivers[name]
+	if !ok {
+		names := make([]string, 0, len(drivers))
+		for n := range drivers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return driver{}, errors.New(fmt.Sprintf("unknown sqlite driver %q, built in are: %s", name, strings.Join(names, ", ")))
+	}
+	return d, nil
+}
@@ -0,0 +1,98 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	storage ".."
+	"../../post"
+)
+
+// events older than the last `keepEvents` ones are removed
+const keepEvents = 1000
+
+// keeps events in the events table
+type eventLog struct {
+	db   *sql.DB
+	keep uint64
+}
+
+// a database or a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (l *eventLog) Append(e storage.Event) error {
+	return l.insert(context.Background(), l.db, e)
+}
+
+// `Store.Batch` inserts the events of its changes in their transaction
+func (l *eventLog) insert(ctx context.Context, db execer, e storage.Event) error {
+	var p string
+	if e.Post != nil {
+		data, err := json.Marshal(e.Post)
+		if err != nil {
+			return err
+		}
+		p = string(data)
+	}
+
+	_, err := db.ExecContext(ctx, "INSERT INTO events (seq, type, post_id, post, time) VALUES (?, ?, ?, ?, ?)", e.Seq, e.Type, e.Id, p, e.Time)
+	if err != nil {
+		return err
+	}
+
+	// no need to do this every time
+	if e.Seq%100 == 0 && e.Seq > l.keep {
+		_, err = db.ExecContext(ctx, "DELETE FROM events WHERE seq <= ?", e.Seq-l.keep)
+	}
+	return err
+}
+
+func (l *eventLog) Since(seq uint64) ([]storage.Event, error) {
+	var first sql.NullInt64
+	err := l.db.QueryRow("SELECT MIN(seq) FROM events").Scan(&first)
+	if err != nil {
+		return nil, err
+	}
+	if !first.Valid || uint64(first.Int64) > seq+1 {
+		return nil, storage.ErrEventsGone
+	}
+
+	rows, err := l.db.Query("SELECT seq, type, post_id, post, time FROM events WHERE seq > ? ORDER BY seq", seq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]storage.Event, 0)
+	for rows.Next() {
+		var e storage.Event
+		var p string
+		err = rows.Scan(&e.Seq, &e.Type, &e.Id, &p, &e.Time)
+		if err != nil {
+			return nil, err
+		}
+		if p != "" {
+			e.Post = &post.Post{}
+			err = json.Unmarshal([]byte(p), e.Post)
+			if err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (l *eventLog) Last() (uint64, error) {
+	var seq uint64
+	err := l.db.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM events").Scan(&seq)
+	return seq, err
+}
+
+// the database is closed by the store
+func (l *eventLog) Close() error {
+	return nil
+}
@@ -0,0 +1,90 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	storage ".."
+	tu "../../util/testing"
+)
+
+func TestWatchAfterReopen(t *testing.T) {
+	dbPath, tearDown := tempDbPath(t)
+	defer tearDown()
+
+	store, err := openPath(dbPath, "")
+	tu.RequireNil(t, err)
+	p := makePost("0815", "cats", "meow")
+	tu.RequireNil(t, store.Create(p))
+	p.Title = "kittens"
+	tu.RequireNil(t, store.Update(p))
+	tu.RequireNil(t, store.Close())
+
+	store, err = openPath(dbPath, "")
+	tu.RequireNil(t, err)
+	defer store.Close()
+	tu.RequireNil(t, store.Delete(p.Id))
+
+	watcher := store.(storage.Watcher)
+	events, err := watcher.WatchFrom(context.Background(), 1)
+	tu.RequireNil(t, err)
+	e := <-events
+	tu.ExpectEqual(t, e.Seq, uint64(2))
+	tu.ExpectEqual(t, e.Type, storage.Updated)
+	tu.ExpectEqual(t, e.Post.Title, "kittens")
+	e = <-events
+	tu.ExpectEqual(t, e.Seq, uint64(3))
+	tu.ExpectEqual(t, e.Type, storage.Deleted)
+	tu.ExpectEqual(t, e.Post == nil, true)
+
+	tu.RequireNil(t, store.Create(p))
+	e = <-events
+	tu.ExpectEqual(t, e.Seq, uint64(4))
+	tu.ExpectEqual(t, e.Type, storage.Created)
+}
+
+func TestEventLogTrims(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	l := &eventLog{db: store.(*Store).db, keep: 10}
+	for i := uint64(1); i <= 100; i++ {
+		tu.RequireNil(t, l.Append(storage.Event{Seq: i, Type: storage.Deleted, Id: "1"}))
+	}
+
+	_, err := l.Since(50)
+	tu.ExpectEqual(t, err, storage.ErrEventsGone)
+	events, err := l.Since(90)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(events), 10)
+	last, err := l.Last()
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, last, uint64(100))
+}
+
+func TestEventsInTransaction(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+	db := store.(*Store).db
+
+	p := makePost("0815", "cats", "meow")
+	tu.RequireNil(t, store.Create(p))
+
+	// the event can't be stored, so the change isn't either
+	_, err := db.Exec("INSERT INTO events (seq, type, post_id) VALUES (2, 'deleted', 'other')")
+	tu.RequireNil(t, err)
+	p.Title = "kittens"
+	tu.ExpectNotNil(t, store.Update(p))
+	found, err := store.FindById(p.Id)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, found.Title, "cats")
+
+	_, err = db.Exec("DELETE FROM events WHERE seq = 2")
+	tu.RequireNil(t, err)
+	tu.RequireNil(t, store.Update(p))
+	events, err := store.(storage.Watcher).WatchFrom(context.Background(), 1)
+	tu.RequireNil(t, err)
+	e := <-events
+	tu.ExpectEqual(t, e.Seq, uint64(2))
+	tu.ExpectEqual(t, e.Post.Title, "kittens")
+}
@@ -1,15 +1,20 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
-	_ "github.com/mattn/go-sqlite3"
+	"fmt"
 	"log"
 	"net/url"
-	"time"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	storage ".."
 	"../../post"
+	"../migrate"
 )
 
 type Backend struct{}
@@ -17,62 +22,139 @@ type Backend struct{}
 type Store struct {
 	path string
 	db   *sql.DB
+	// nil if the driver has no online backup api
+	backup func(ctx context.Context, db *sql.DB, path string) error
+	// changes are made one at a time, so that their events are in order
+	mu   sync.Mutex
+	feed *storage.Feed
+	// the events table, nil if the events are kept in memory
+	events *eventLog
 }
 
 func init() {
 	storage.Register("sqlite", Backend{})
 }
 
-func setup(db *sql.DB) error {
-	// initialize sqlite database if not present under path
-	creatTableStmt := "CREATE TABLE IF NOT EXISTS posts (id TEXT NOT NULL PRIMARY KEY, created DATETIME, title TEXT, content TEXT)"
-	// db.Exec does not return results
-	_, err := db.Exec(creatTableStmt)
+const postColumns = "id, created, title, content, slug, old_slugs, updated, updated_by"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// reads a post selected with `postColumns`
+func scanPost(row scanner) (*post.Post, error) {
+	var p post.Post
+	var slug, oldSlugs, updatedBy sql.NullString
+	var updated sql.NullTime
+	err := row.Scan(&p.Id, &p.Created, &p.Title, &p.Content, &slug, &oldSlugs, &updated, &updatedBy)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	createIndexStmt := "CREATE UNIQUE INDEX IF NOT EXISTS idIdx ON posts (id)"
-	_, err = db.Exec(createIndexStmt)
-	return err
+	p.Slug = slug.String
+	p.Updated = updated.Time
+	p.UpdatedBy = updatedBy.String
+	if oldSlugs.String != "" {
+		err = json.Unmarshal([]byte(oldSlugs.String), &p.OldSlugs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &p, nil
+}
+
+// never edited posts have no update time
+func updatedTime(p post.Post) interface{} {
+	if !p.Edited() {
+		return nil
+	}
+	return p.Updated
 }
 
+func encodeOldSlugs(p post.Post) (string, error) {
+	if len(p.OldSlugs) == 0 {
+		return "", nil
+	}
+	oldSlugs, err := json.Marshal(p.OldSlugs)
+	return string(oldSlugs), err
+}
+
+// pending schema migrations are applied on open, unless `?migrate=false`
+// is given.  databases from newer versions of gol are refused.
+//
+// `?driver=mattn` or `?driver=modernc` selects the driver (see
+// `./drivers.go`).
 func (m Backend) Open(u *url.URL) (storage.Store, error) {
 	path := u.Host + u.Path
-	db, err := sql.Open("sqlite3", path)
+	d, err := findDriver(u.Query().Get("driver"))
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(d.sqlName, d.dsn(path))
 	if err != nil {
 		return nil, err
 	}
 
-	err = setup(db)
+	migrator := newMigrator(db)
+	if u.Query().Get("migrate") == "false" {
+		err = migrator.Check()
+	} else {
+		err = migrator.Up()
+	}
 	if err != nil {
-		log.Fatal(err)
+		db.Close()
+		return nil, err
+	}
+
+	tableLog := &eventLog{db: db, keep: keepEvents}
+	events := storage.EventLog(tableLog)
+	if _, err = events.Last(); err != nil {
+		// not migrated yet, the events are lost when gol exits
+		log.Printf("keeping events in memory: %s", err)
+		events = storage.NewMemoryLog(keepEvents)
+		tableLog = nil
+	}
+	feed, err := storage.NewFeed(events)
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
 
 	// return store
 	store := storage.Store(&Store{
-		db: db,
+		path:   path,
+		db:     db,
+		backup: d.backup,
+		feed:   feed,
+		events: tableLog,
 	})
 	return store, nil
 }
 
-// Store interface methods
+// Store interface methods, the variants with a context stop when it is
+// done
 func (s *Store) FindById(id string) (*post.Post, error) {
-	stmt, err := s.db.Prepare("SELECT * FROM posts WHERE ID = ?")
-	if err != nil {
-		return nil, err
-	}
+	return s.FindByIdContext(context.Background(), id)
+}
+
+func (s *Store) FindByIdContext(ctx context.Context, id string) (*post.Post, error) {
+	return findById(ctx, s.db, id)
+}
+
+// a database or a transaction
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func findById(ctx context.Context, db queryer, id string) (*post.Post, error) {
 	// never returns nil
-	row := stmt.QueryRow(id)
+	row := db.QueryRowContext(ctx, "SELECT "+postColumns+" FROM posts WHERE ID = ?", id)
 
-	var title, content string
-	var created time.Time
-	err = row.Scan(&id, &created, &title, &content)
-	post := &post.Post{id, title, content, created}
+	post, err := scanPost(row)
 
 	switch {
 	case err == sql.ErrNoRows:
-		return nil, errors.New("could not find post with id!")
+		return nil, storage.NotFoundError{Id: id}
 	case err != nil:
 		return nil, err
 	}
@@ -81,7 +163,11 @@ func (s *Store) FindById(id string) (*post.Post, error) {
 }
 
 func (s *Store) FindAll() ([]post.Post, error) {
-	rows, err := s.db.Query("SELECT id, created, title, content FROM posts ORDER BY created DESC")
+	return s.FindAllContext(context.Background())
+}
+
+func (s *Store) FindAllContext(ctx context.Context) ([]post.Post, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+postColumns+" FROM posts ORDER BY created DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -89,59 +175,237 @@ func (s *Store) FindAll() ([]post.Post, error) {
 	posts := make([]post.Post, 0)
 	defer rows.Close()
 	for rows.Next() {
-		var id, title, content string
-		var created time.Time
-		err = rows.Scan(&id, &created, &title, &content)
+		p, err := scanPost(rows)
 		if err != nil {
-			log.Print(err)
+			return nil, err
 		}
-		posts = append(posts, post.Post{id, title, content, created})
+		posts = append(posts, *p)
 	}
 	return posts, rows.Err()
 }
 
 func (s *Store) Create(post post.Post) error {
-	return s.execQuery("INSERT INTO posts(id, created, title, content) values(?, ?, ?, ?)", post.Id, post.Created, post.Title, post.Content)
+	return s.CreateContext(context.Background(), post)
+}
+
+func (s *Store) CreateContext(ctx context.Context, post post.Post) error {
+	return s.change(ctx, storage.Op{Op: storage.OpCreate, Post: &post})
 }
 
 func (s *Store) Update(updatedPost post.Post) error {
-	_, err := s.FindById(updatedPost.Id)
+	return s.UpdateContext(context.Background(), updatedPost)
+}
+
+func (s *Store) UpdateContext(ctx context.Context, updatedPost post.Post) error {
+	return s.change(ctx, storage.Op{Op: storage.OpUpdate, Post: &updatedPost})
+}
+
+func (s *Store) Delete(id string) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *Store) DeleteContext(ctx context.Context, id string) error {
+	return s.change(ctx, storage.Op{Op: storage.OpDelete, Id: id})
+}
+
+// makes a single change, with the error as is
+func (s *Store) change(ctx context.Context, op storage.Op) error {
+	err := s.Batch(ctx, []storage.Op{op})
+	if batchErr, ok := err.(storage.BatchError); ok {
+		return batchErr.Err
+	}
+	return err
+}
+
+// applies all changes in one transaction together with their events,
+// watchers are told about them once it is committed
+func (s *Store) Batch(ctx context.Context, ops []storage.Op) error {
+	err := storage.CheckOps(ops)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []storage.Event
+	err = s.inTransaction(ctx, func(tx *sql.Tx) error {
+		changed := make([]*post.Post, len(ops))
+		for i, op := range ops {
+			var err error
+			switch op.Op {
+			case storage.OpCreate:
+				err = createPost(ctx, tx, *op.Post)
+				changed[i] = op.Post
+			case storage.OpUpdate:
+				changed[i], err = updatePost(ctx, tx, *op.Post)
+			case storage.OpDelete:
+				err = deletePost(ctx, tx, op.PostId())
+			}
+			if err != nil {
+				return storage.BatchError{Index: i, Op: op, Err: err}
+			}
+		}
+
+		events = make([]storage.Event, len(ops))
+		for i, op := range ops {
+			events[i] = storage.Event{Type: op.EventType(), Id: op.PostId(), Post: changed[i]}
+		}
+		if s.events == nil {
+			return nil
+		}
+		events = s.feed.Prepare(events)
+		for _, e := range events {
+			err := s.events.insert(ctx, tx, e)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	return s.execQuery("UPDATE posts SET id=?, created=?, title=?, content=? WHERE id=?", updatedPost.Id, updatedPost.Created, updatedPost.Title, updatedPost.Content, updatedPost.Id)
+	if s.events != nil {
+		s.feed.Send(events)
+		return nil
+	}
+	for _, e := range events {
+		s.feed.Publish(e.Type, e.Id, e.Post)
+	}
+	return nil
 }
 
-func (s *Store) Delete(id string) error {
-	return s.execQuery("DELETE FROM posts WHERE id = ?", id)
+func createPost(ctx context.Context, tx *sql.Tx, post post.Post) error {
+	if _, err := findById(ctx, tx, post.Id); err == nil {
+		return storage.ConflictError{Id: post.Id}
+	}
+	err := checkSlug(ctx, tx, post)
+	if err != nil {
+		return err
+	}
+
+	oldSlugs, err := encodeOldSlugs(post)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO posts("+postColumns+") values(?, ?, ?, ?, ?, ?, ?, ?)", post.Id, post.Created, post.Title, post.Content, post.Slug, oldSlugs, updatedTime(post), post.UpdatedBy)
+	return slugConflict(err, post)
+}
+
+// fails with a `storage.ConflictError` if another post created on the same
+// day has the slug of `p`
+func checkSlug(ctx context.Context, tx *sql.Tx, p post.Post) error {
+	if p.Slug == "" {
+		return nil
+	}
+
+	var id string
+	err := tx.QueryRowContext(ctx, "SELECT id FROM posts WHERE slug = ? AND date(created) = date(?) AND id != ? LIMIT 1", p.Slug, p.Created, p.Id).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return storage.ConflictError{Id: p.Id, Slug: p.Slug}
+}
+
+// the unique index on the slugs catches what `checkSlug` can't see yet
+func slugConflict(err error, p post.Post) error {
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed: index 'posts_slug_idx'") {
+		return storage.ConflictError{Id: p.Id, Slug: p.Slug}
+	}
+	return err
+}
+
+// returns the post as stored
+func updatePost(ctx context.Context, tx *sql.Tx, updatedPost post.Post) (*post.Post, error) {
+	oldPost, err := findById(ctx, tx, updatedPost.Id)
+	if err != nil {
+		return nil, err
+	}
+	storage.MarkUpdatedContext(ctx, &updatedPost, *oldPost)
+	err = checkSlug(ctx, tx, updatedPost)
+	if err != nil {
+		return nil, err
+	}
+
+	oldSlugs, err := encodeOldSlugs(updatedPost)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE posts SET id=?, created=?, title=?, content=?, slug=?, old_slugs=?, updated=?, updated_by=? WHERE id=?", updatedPost.Id, updatedPost.Created, updatedPost.Title, updatedPost.Content, updatedPost.Slug, oldSlugs, updatedTime(updatedPost), updatedPost.UpdatedBy, updatedPost.Id)
+	if err != nil {
+		return nil, slugConflict(err, updatedPost)
+	}
+	return &updatedPost, nil
+}
+
+func deletePost(ctx context.Context, tx *sql.Tx, id string) error {
+	if _, err := findById(ctx, tx, id); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, "DELETE FROM posts WHERE id = ?", id)
+	return err
+}
+
+func (s *Store) Watch(ctx context.Context) <-chan storage.Event {
+	return s.feed.Watch(ctx)
+}
+
+func (s *Store) WatchFrom(ctx context.Context, seq uint64) (<-chan storage.Event, error) {
+	return s.feed.WatchFrom(ctx, seq)
 }
 
 func (s *Store) Close() error {
+	s.feed.Close()
 	return s.db.Close()
 }
 
+func (s *Store) Migrate() error {
+	return newMigrator(s.db).Up()
+}
+
+func (s *Store) MigrationStatus() ([]migrate.Status, error) {
+	return newMigrator(s.db).Status()
+}
+
+// copies the database while it is in use, with the online backup api if
+// the driver has it and with `VACUUM INTO` otherwise
+func (s *Store) Snapshot(ctx context.Context, dir string) (string, error) {
+	path := filepath.Join(dir, "snapshot.db")
+	var err error
+	if s.backup != nil {
+		err = s.backup(ctx, s.db, path)
+	} else {
+		_, err = s.db.ExecContext(ctx, "VACUUM INTO ?", path)
+	}
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("could not copy %s: %s", s.path, err))
+	}
+	return "sqlite://" + path + "?migrate=false", nil
+}
+
 func (s *Store) Sync() error {
 	// TODO
 	return nil
 }
 
-func (s *Store) execQuery(query string, args ...interface{}) error {
-	tx, err := s.db.Begin()
+// runs `f` in a transaction, which is rolled back if `f` fails
+func (s *Store) inTransaction(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Println("could not begin transaction", err)
 		return err
 	}
 
-	stmt, err := s.db.Prepare(query)
-	if err != nil {
-		log.Println("could not prepare query", err)
-		return err
-	}
-
-	_, err = stmt.Exec(args...)
+	err = f(tx)
 	if err != nil {
-		log.Println("could not execute statement", err)
+		tx.Rollback()
 		return err
 	}
 
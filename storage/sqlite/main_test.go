@@ -1,17 +1,23 @@
 package sqlite
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path"
+	"reflect"
 	"testing"
 	"time"
 
 	storage ".."
 	"../../post"
 	tu "../../util/testing"
+	"../memory"
+	"../query"
 )
 
 func makePost(id, title, content string) post.Post {
@@ -30,16 +36,17 @@ func comparePost(t *testing.T, a, b *post.Post) {
 	tu.RequireEqual(t, a.Content, b.Content)
 }
 
+// the driver to test, e.g. `GOL_SQLITE_DRIVER=modernc go test`
+var testDriver = os.Getenv("GOL_SQLITE_DRIVER")
+
 func tSetup(t *testing.T) (storage.Store, func()) {
-	backend := Backend{}
 	tmpPath, err := ioutil.TempDir("", "gol_sqlite_test")
 	if err != nil {
 		t.Fatal("could not create temporary directory", err)
 	}
 
 	dbPath := path.Join(tmpPath, "sqltest.db")
-	u, _ := url.Parse(fmt.Sprintf("sqlite://%s", dbPath))
-	store, err := backend.Open(u)
+	store, err := openPath(dbPath, "")
 	if store == nil {
 		t.Fatal("could not get store for sqlite backend", err)
 	}
@@ -52,15 +59,13 @@ func tSetup(t *testing.T) (storage.Store, func()) {
 
 // cant cast testing.T to testing.B
 func bSetup(b *testing.B) (storage.Store, func()) {
-	backend := Backend{}
 	tmpPath, err := ioutil.TempDir("", "gol_sqlite_test")
 	if err != nil {
 		b.Fatal("could not create temporary directory", err)
 	}
 
 	dbPath := path.Join(tmpPath, "sqltest.db")
-	u, _ := url.Parse(fmt.Sprintf("sqlite://%s", dbPath))
-	store, err := backend.Open(u)
+	store, err := openPath(dbPath, "")
 	if store == nil {
 		b.Fatal("could not get store for sqlite backend", err)
 	}
@@ -92,6 +97,108 @@ func TestFind(t *testing.T) {
 	comparePost(t, &foundPosts[0], &post)
 }
 
+func TestFindRange(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	t1 := time.Date(2015, 3, 1, 12, 31, 0, 0, time.UTC)
+	t2 := time.Date(2015, 3, 2, 19, 21, 0, 0, time.FixedZone("UTC+2", 2*60*60))
+	t3 := time.Date(2015, 3, 7, 11, 57, 0, 0, time.UTC)
+	for i, created := range []time.Time{t1, t2, t3} {
+		p := makePost(fmt.Sprintf("%d", i+1), "", "")
+		p.Created = created
+		store.Create(p)
+	}
+
+	q, _ := storage.Query().Range(t1, t2).Build()
+	expectIds(t, store, q, []string{"1", "2"})
+
+	q, _ = storage.Query().Since(t2).Build()
+	expectIds(t, store, q, []string{"2", "3"})
+
+	q, _ = storage.Query().Until(t2.Add(-time.Second)).Build()
+	expectIds(t, store, q, []string{"1"})
+}
+
+func TestAggregate(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	days := []time.Time{
+		time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC),
+		time.Date(2015, 9, 15, 1, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60)),
+		time.Date(2015, 10, 1, 8, 0, 0, 0, time.UTC),
+	}
+	for i, created := range days {
+		p := makePost(fmt.Sprintf("%d", i), "", "#ops")
+		p.Created = created
+		store.Create(p)
+	}
+
+	q, _ := storage.Query().Build()
+	counts, err := storage.Aggregate(store, storage.ByDay, *q)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(counts), 2)
+	tu.ExpectEqual(t, counts[0], storage.Count{Key: "2015-09-14", Count: 2})
+	tu.ExpectEqual(t, counts[1], storage.Count{Key: "2015-10-01", Count: 1})
+
+	q, _ = storage.Query().Since(days[2]).Build()
+	counts, err = storage.Aggregate(store, storage.ByMonth, *q)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(counts), 1)
+	tu.ExpectEqual(t, counts[0], storage.Count{Key: "2015-10", Count: 1})
+
+	counts, err = storage.Aggregate(store, storage.ByTag, *q)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(counts), 1)
+	tu.ExpectEqual(t, counts[0], storage.Count{Key: "ops", Count: 1})
+}
+
+// the same counts as `Find` and the memory storage, paging included
+func TestAggregateLikeMemory(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	posts := []post.Post{}
+	day := time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 12; i++ {
+		posts = append(posts, post.Post{
+			Id:      fmt.Sprintf("%02d", i),
+			Title:   fmt.Sprintf("post %d", i),
+			Content: fmt.Sprintf("#tag%d", i%3),
+			Created: day.AddDate(0, 0, 10*i),
+		})
+	}
+	mem := memory.FromPosts(append([]post.Post(nil), posts...))
+	for _, p := range posts {
+		tu.RequireNil(t, store.Create(p))
+	}
+
+	for _, rawQuery := range []string{"", "start=3", "count=4", "start=2&count=5", "start=1&count=3&reverse=true", "count=0"} {
+		params, _ := url.ParseQuery(rawQuery)
+		q, err := query.FromParams(params)
+		tu.RequireNil(t, err)
+		for _, by := range []string{storage.ByDay, storage.ByMonth, storage.ByYear, storage.ByTag} {
+			expected, err := storage.Aggregate(mem, by, *q)
+			tu.RequireNil(t, err)
+			counts, err := storage.Aggregate(store, by, *q)
+			tu.RequireNil(t, err)
+			if !reflect.DeepEqual(counts, expected) {
+				t.Errorf("?%s by %s: %v, but memory has %v", rawQuery, by, counts, expected)
+			}
+		}
+	}
+}
+
+func expectIds(t *testing.T, store storage.Store, q *query.Query, ids []string) {
+	posts, err := store.Find(*q)
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(posts), len(ids))
+	for i, p := range posts {
+		tu.ExpectEqual(t, p.Id, ids[i])
+	}
+}
+
 func TestFindById(t *testing.T) {
 	store, tearDown := tSetup(t)
 	defer tearDown()
@@ -126,6 +233,33 @@ func TestFindAll(t *testing.T) {
 			}
 		}
 	}
+
+	// a broken row is an error, not a missing post
+	_, err = store.(*Store).db.Exec("UPDATE posts SET old_slugs = 'not json' WHERE id = '0'")
+	tu.RequireNil(t, err)
+	_, err = store.FindAll()
+	tu.ExpectNotNil(t, err)
+}
+
+func TestUniqueSlugs(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	day := time.Date(2015, 9, 14, 10, 0, 0, 0, time.UTC)
+	tu.RequireNil(t, store.Create(post.Post{Id: "1", Title: "cats", Slug: "cats", Created: day}))
+	tu.RequireNil(t, store.Create(post.Post{Id: "2", Title: "dogs", Slug: "dogs", Created: day}))
+
+	// the same day in utc
+	cest := time.FixedZone("CEST", 2*60*60)
+	err := store.Create(post.Post{Id: "3", Title: "cats", Slug: "cats", Created: time.Date(2015, 9, 15, 1, 0, 0, 0, cest)})
+	tu.ExpectEqual(t, err, storage.ConflictError{Id: "3", Slug: "cats"})
+	err = store.Update(post.Post{Id: "2", Title: "cats", Slug: "cats", Created: day})
+	tu.ExpectEqual(t, err, storage.ConflictError{Id: "2", Slug: "cats"})
+
+	// on another day, or without a slug
+	tu.RequireNil(t, store.Create(post.Post{Id: "4", Title: "cats", Slug: "cats", Created: day.AddDate(0, 0, 1)}))
+	tu.RequireNil(t, store.Create(post.Post{Id: "5", Title: "cats", Created: day}))
+	tu.RequireNil(t, store.Create(post.Post{Id: "6", Title: "cats", Created: day}))
 }
 
 func TestCreate(t *testing.T) {
@@ -145,6 +279,20 @@ func TestCreate(t *testing.T) {
 	comparePost(t, foundPost, &post)
 }
 
+func TestCreateDuplicate(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	// an old md5 id
+	post := makePost("d41d8cd98f00b204e9800998ecf8427e", "sqlite-test-create", "")
+	tu.RequireNil(t, store.Create(post))
+	tu.ExpectEqual(t, errors.Is(store.Create(post), storage.ErrConflict), true)
+
+	foundPost, err := store.FindById(post.Id)
+	tu.RequireNil(t, err)
+	comparePost(t, foundPost, &post)
+}
+
 func TestUpdate(t *testing.T) {
 	store, tearDown := tSetup(t)
 	defer tearDown()
@@ -159,6 +307,56 @@ func TestUpdate(t *testing.T) {
 	comparePost(t, foundPost, &updatedPost)
 }
 
+func TestSlugs(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	p := makePost("0815", "sqlite-test-slugs", "")
+	p.Slug = "sqlite-test-slugs"
+	store.Create(p)
+	foundPost, err := store.FindById("0815")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, foundPost.Slug, "sqlite-test-slugs")
+	tu.ExpectEqual(t, len(foundPost.OldSlugs), 0)
+
+	p.Slug = "sqlite-slugs"
+	p.OldSlugs = []string{"sqlite-test-slugs"}
+	store.Update(p)
+	foundPost, err = store.FindById("0815")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, foundPost.Slug, "sqlite-slugs")
+	tu.RequireEqual(t, len(foundPost.OldSlugs), 1)
+	tu.ExpectEqual(t, foundPost.OldSlugs[0], "sqlite-test-slugs")
+}
+
+func TestUpdated(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	t1 := time.Date(2015, 3, 1, 12, 31, 0, 0, time.UTC)
+	for i := 1; i <= 3; i++ {
+		p := makePost(fmt.Sprintf("%d", i), "", "")
+		p.Created = t1.AddDate(0, 0, i)
+		store.Create(p)
+	}
+
+	foundPost, _ := store.FindById("1")
+	tu.ExpectEqual(t, foundPost.Edited(), false)
+
+	foundPost.Content = "edited"
+	foundPost.UpdatedBy = "jane"
+	tu.RequireNil(t, store.Update(*foundPost))
+	foundPost, _ = store.FindById("1")
+	tu.ExpectEqual(t, foundPost.Edited(), true)
+	tu.ExpectEqual(t, foundPost.UpdatedBy, "jane")
+
+	q, _ := storage.Query().SortBy("updated").Build()
+	expectIds(t, store, q, []string{"2", "3", "1"})
+
+	q, _ = storage.Query().RangeBy("updated").Since(t1.AddDate(0, 0, 3)).Build()
+	expectIds(t, store, q, []string{"1", "3"})
+}
+
 func TestDelete(t *testing.T) {
 	store, tearDown := tSetup(t)
 	defer tearDown()
@@ -168,6 +366,10 @@ func TestDelete(t *testing.T) {
 	tu.RequireNil(t, err)
 	posts, _ := store.FindAll()
 	tu.RequireEqual(t, len(posts), 0)
+
+	_, err = store.FindById("0815")
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrNotFound), true)
+	tu.ExpectEqual(t, errors.Is(store.Delete("0815"), storage.ErrNotFound), true)
 }
 
 func TestClose(t *testing.T) {
@@ -189,3 +391,154 @@ func BenchmarkCreate(b *testing.B) {
 		store.Create(makePost(fmt.Sprintf("%d", i), "", ""))
 	}
 }
+
+func TestCancelled(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cs := storage.WithContext(store)
+	_, err := cs.FindByIdContext(ctx, "1")
+	tu.ExpectEqual(t, errors.Is(err, context.Canceled), true)
+	err = cs.CreateContext(ctx, makePost("1", "sqlite-test-cancelled", ""))
+	tu.ExpectEqual(t, errors.Is(err, context.Canceled), true)
+
+	posts, err := cs.FindAllContext(context.Background())
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(posts), 0)
+}
+
+func TestEach(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	for i := 0; i < 5; i++ {
+		p := makePost(fmt.Sprint(i), fmt.Sprintf("sqlite-test-each-%d", i), "")
+		p.Created = time.Date(2015, 9, 1+i, 10, 0, 0, 0, time.UTC)
+		tu.RequireNil(t, store.Create(p))
+	}
+
+	q, _ := storage.Query().Build()
+	var ids []string
+	err := storage.Each(context.Background(), store, *q, func(p post.Post) error {
+		ids = append(ids, p.Id)
+		if len(ids) == 2 {
+			return storage.ErrStop
+		}
+		return nil
+	})
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, ids, []string{"0", "1"})
+}
+
+func TestBatch(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+	s := store.(*Store)
+
+	tu.RequireNil(t, store.Create(makePost("1", "cats", "meow")))
+
+	// the last change fails, the transaction is rolled back
+	err := s.Batch(context.Background(), []storage.Op{
+		{Op: storage.OpCreate, Post: &post.Post{Id: "2", Title: "dogs", Created: time.Now()}},
+		{Op: storage.OpDelete, Id: "1"},
+		{Op: storage.OpCreate, Post: &post.Post{Id: "2", Title: "dogs again", Created: time.Now()}},
+	})
+	tu.ExpectEqual(t, errors.Is(err, storage.ErrConflict), true)
+	posts, _ := store.FindAll()
+	tu.RequireEqual(t, len(posts), 1)
+	tu.ExpectEqual(t, posts[0].Title, "cats")
+
+	ops := make([]storage.Op, 0, 101)
+	for i := 0; i < 100; i++ {
+		p := makePost(fmt.Sprint("batch-", i), "title", "content")
+		ops = append(ops, storage.Op{Op: storage.OpCreate, Post: &p})
+	}
+	ops = append(ops, storage.Op{Op: storage.OpUpdate, Post: &post.Post{Id: "1", Title: "kittens", Created: time.Now()}})
+	tu.RequireNil(t, s.Batch(context.Background(), ops))
+
+	posts, _ = store.FindAll()
+	tu.ExpectEqual(t, len(posts), 101)
+	p, err := store.FindById("1")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, p.Title, "kittens")
+	tu.ExpectEqual(t, p.Edited(), true)
+}
+
+func TestSnapshot(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+	s := store.(*Store)
+	tu.RequireNil(t, store.Create(makePost("1", "cats", "meow")))
+	tu.RequireNil(t, store.Create(makePost("2", "dogs", "woof")))
+
+	online := s.backup
+	for _, backup := range []func(context.Context, *sql.DB, string) error{online, nil} {
+		dir, err := ioutil.TempDir("", "gol_sqlite_snapshot")
+		tu.RequireNil(t, err)
+		defer os.RemoveAll(dir)
+
+		s.backup = backup
+		snapshotUrl, err := s.Snapshot(context.Background(), dir)
+		tu.RequireNil(t, err)
+		snapshot, err := storage.Open(snapshotUrl)
+		tu.RequireNil(t, err)
+		posts, err := snapshot.FindAll()
+		tu.RequireNil(t, err)
+		tu.ExpectEqual(t, len(posts), 2)
+		snapshot.Close()
+	}
+}
+
+func TestEachPages(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	// posts on the same day are ordered by their id
+	n := 2*pageSize + 50
+	for i := 0; i < n; i++ {
+		p := makePost(fmt.Sprintf("%03d", i), "post", "")
+		p.Created = time.Date(2015, 9, 1+i/20, 10, 0, 0, 0, time.UTC)
+		tu.RequireNil(t, store.Create(p))
+	}
+
+	// no rows are open while `f` runs, so it may change the store
+	q, _ := storage.Query().Reverse().Build()
+	var ids []string
+	err := storage.Each(context.Background(), store, *q, func(p post.Post) error {
+		ids = append(ids, p.Id)
+		p.Title = "seen"
+		return store.Update(p)
+	})
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(ids), n)
+	for i, id := range ids {
+		tu.RequireEqual(t, id, fmt.Sprintf("%03d", n-1-i))
+	}
+
+	q, _ = storage.Query().Match("title", "post").Build()
+	posts, err := store.Find(*q)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(posts), 0)
+}
+
+func TestKeepUpdated(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	p := makePost("1", "cats", "meow")
+	tu.RequireNil(t, store.Create(p))
+	p.Content = "purr"
+	err := storage.Batch(storage.KeepUpdated(context.Background()), store, []storage.Op{{Op: storage.OpUpdate, Post: &p}})
+	tu.RequireNil(t, err)
+
+	found, err := store.FindById("1")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, found.Content, "purr")
+	tu.ExpectEqual(t, found.Edited(), false)
+
+	tu.RequireNil(t, store.Update(*found))
+	found, _ = store.FindById("1")
+	tu.ExpectEqual(t, found.Edited(), true)
+}
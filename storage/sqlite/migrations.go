@@ -0,0 +1,45 @@
+package sqlite
+
+import (
+	"database/sql"
+
+	"../migrate"
+)
+
+// the schema, in order.  never change a migration that has been released,
+// add a new one instead.
+var migrations = []migrate.Migration{
+	{Version: 1, Name: "create posts table", Up: func(tx *sql.Tx) error {
+		// databases created before migrations existed already have the table,
+		// but without the columns added since
+		for _, stmt := range []string{
+			"CREATE TABLE IF NOT EXISTS posts (id TEXT NOT NULL PRIMARY KEY, created DATETIME, title TEXT, content TEXT)",
+			"CREATE UNIQUE INDEX IF NOT EXISTS idIdx ON posts (id)",
+			"ALTER TABLE posts ADD COLUMN slug TEXT",
+			"ALTER TABLE posts ADD COLUMN old_slugs TEXT", // a json array
+			"ALTER TABLE posts ADD COLUMN updated DATETIME",
+			"ALTER TABLE posts ADD COLUMN updated_by TEXT",
+		} {
+			_, err := tx.Exec(stmt)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{Version: 2, Name: "add events", Up: func(tx *sql.Tx) error {
+		// `post` is the post as json, empty for deleted posts
+		_, err := tx.Exec("CREATE TABLE events (seq INTEGER NOT NULL PRIMARY KEY, type TEXT, post_id TEXT, post TEXT, time DATETIME)")
+		return err
+	}},
+	{Version: 3, Name: "unique slugs per day", Up: func(tx *sql.Tx) error {
+		// `date` is in utc, like the day in permalinks.  posts without a slug
+		// use one derived from their title.
+		_, err := tx.Exec("CREATE UNIQUE INDEX posts_slug_idx ON posts (date(created), slug) WHERE slug != ''")
+		return err
+	}},
+}
+
+func newMigrator(db *sql.DB) *migrate.Migrator {
+	return migrate.New(db, migrate.Question, migrations)
+}
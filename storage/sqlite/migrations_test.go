@@ -0,0 +1,101 @@
+package sqlite
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	storage ".."
+	tu "../../util/testing"
+	"../migrate"
+)
+
+func TestMigrationStatus(t *testing.T) {
+	store, tearDown := tSetup(t)
+	defer tearDown()
+
+	statuses, err := store.(*Store).MigrationStatus()
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(statuses), len(migrations))
+	for i, status := range statuses {
+		tu.ExpectEqual(t, status.Version, i+1)
+		tu.ExpectEqual(t, status.Applied, true)
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	dbPath, tearDown := tempDbPath(t)
+	defer tearDown()
+
+	// the schema before migrations existed
+	db, err := openDriver(testDriver, dbPath)
+	tu.RequireNil(t, err)
+	_, err = db.Exec("CREATE TABLE posts (id TEXT NOT NULL PRIMARY KEY, created DATETIME, title TEXT, content TEXT)")
+	tu.RequireNil(t, err)
+	_, err = db.Exec("INSERT INTO posts (id, created, title, content) VALUES (?, ?, ?, ?)", "0815", time.Now(), "old", "from before migrations")
+	tu.RequireNil(t, err)
+	db.Close()
+
+	// not migrated when asked not to
+	store, err := openPath(dbPath, "?migrate=false")
+	tu.RequireNil(t, err)
+	statuses, _ := store.(*Store).MigrationStatus()
+	tu.ExpectEqual(t, statuses[0].Applied, false)
+	_, err = store.FindById("0815")
+	tu.ExpectNotNil(t, err)
+
+	tu.RequireNil(t, store.(*Store).Migrate())
+	p, err := store.FindById("0815")
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, p.Title, "old")
+	store.Close()
+
+	// migrating again is fine
+	store, err = openPath(dbPath, "")
+	tu.RequireNil(t, err)
+	store.Close()
+}
+
+func TestRefuseNewerDatabase(t *testing.T) {
+	dbPath, tearDown := tempDbPath(t)
+	defer tearDown()
+
+	store, err := openPath(dbPath, "")
+	tu.RequireNil(t, err)
+	_, err = store.(*Store).db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", 999, "from the future", "")
+	tu.RequireNil(t, err)
+	store.Close()
+
+	_, err = openPath(dbPath, "")
+	tu.RequireNotNil(t, err)
+	_, isTooNew := err.(migrate.TooNewError)
+	tu.ExpectEqual(t, isTooNew, true)
+
+	_, err = openPath(dbPath, "?migrate=false")
+	tu.ExpectNotNil(t, err)
+}
+
+func tempDbPath(t *testing.T) (string, func()) {
+	tmpPath, err := ioutil.TempDir("", "gol_sqlite_test")
+	if err != nil {
+		t.Fatal("could not create temporary directory", err)
+	}
+
+	return path.Join(tmpPath, "sqltest.db"), func() {
+		os.RemoveAll(tmpPath)
+	}
+}
+
+func openPath(dbPath, params string) (storage.Store, error) {
+	u, _ := url.Parse(fmt.Sprintf("sqlite://%s%s", dbPath, params))
+	if testDriver != "" {
+		values := u.Query()
+		values.Set("driver", testDriver)
+		u.RawQuery = values.Encode()
+	}
+	return Backend{}.Open(u)
+}
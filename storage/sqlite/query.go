@@ -2,20 +2,20 @@ package sqlite
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"strings"
 	"text/template"
-	"time"
 
 	"../../post"
 	"../query"
 	"database/sql"
-	_ "github.com/mattn/go-sqlite3"
 	//  "github.com/Masterminds/squirrel" // use this in the future
 )
 
-func buildClause(a, b interface{}, op string) string {
-	return fmt.Sprintf("%s %s \"%s\"", a, op, b)
+// a where clause comparing `field` to a placeholder
+func buildClause(field string, op string) string {
+	return fmt.Sprintf("%s %s ?", field, op)
 }
 
 type SqlQuery struct {
@@ -24,97 +24,223 @@ type SqlQuery struct {
 	Where  string
 	Order  string
 	SortBy string
+	Limit  int
+	Offset int
 }
 
-const sqlTemplate = `SELECT {{ .Select }} FROM {{ .From }}
+const sqlTemplate = `SELECT {{ .Select }}, {{ .SortBy }}, id FROM {{ .From }}
 WHERE {{ .Where }}
-ORDER BY {{ .SortBy }} {{ .Order }};`
+ORDER BY {{ .SortBy }} {{ .Order }}, id {{ .Order }}
+LIMIT {{ .Limit }} OFFSET {{ .Offset }}`
+
+// where the previous page ended, the value of the sort expression and the
+// id of its last post
+type pageKey struct {
+	sortBy interface{}
+	id     string
+}
 
-func buildSqlQuery(q query.Query) (string, error) {
+// returns the query for the page of `q` after `after` (the first one if
+// nil) and the arguments for its placeholders.  a negative `limit` reads
+// all posts.
+func buildSqlQuery(q query.Query, after *pageKey, limit, offset int) (string, []interface{}, error) {
 	sqlQuery := SqlQuery{
-		Select: "*",
-		From:   "posts"}
+		Select: postColumns,
+		From:   "posts",
+		Limit:  limit,
+		Offset: offset,
+	}
+
+	sqlQuery.Order = "ASC"
+	if q.Reverse {
+		sqlQuery.Order = "DESC"
+	}
+
+	// dates are compared as numbers, so that the key of a page reads back
+	// as it was
+	sqlQuery.SortBy = dateColumn("created")
+	if q.SortBy == "updated" {
+		sqlQuery.SortBy = dateColumn(q.SortBy)
+	} else if q.SortBy != "" && q.SortBy != "created" {
+		sqlQuery.SortBy = q.SortBy
+	}
+
+	where, args := buildWhere(q)
+	if after != nil {
+		cmp := ">"
+		if q.Reverse {
+			cmp = "<"
+		}
+		where = fmt.Sprintf("(%s)\nAND (%s %s ? OR (%s = ? AND id %s ?))", where, sqlQuery.SortBy, cmp, sqlQuery.SortBy, cmp)
+		args = append(args, after.sortBy, after.sortBy, after.id)
+	}
+	sqlQuery.Where = where
+
+	tmpl, err := template.New("sqlQuery").Parse(sqlTemplate)
+	if err != nil {
+		return "", nil, err
+	}
 
+	var query bytes.Buffer
+	err = tmpl.Execute(&query, sqlQuery)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return query.String(), args, nil
+}
+
+// an expression for comparing the "created" or "updated" date of posts,
+// the latter is the created date for posts that were never edited
+func dateColumn(field string) string {
+	if field == "updated" {
+		return "julianday(COALESCE(updated, created))"
+	}
+	return "julianday(created)"
+}
+
+// the where clause for the conditions of `q` and the arguments for its
+// placeholders
+func buildWhere(q query.Query) (string, []interface{}) {
 	var whereClauses []string
+	var args []interface{}
 	if q.Find != nil {
 		whereClauses = append(whereClauses,
-			buildClause(q.Find.Name, q.Find.Value, "="))
+			buildClause(q.Find.Name, "="))
+		args = append(args, q.Find.Value)
 	}
 
 	if q.Matches != nil && len(q.Matches) > 0 {
 		for _, field := range q.Matches {
+			if field.Name == "text" {
+				// no full-text search, every word has to appear somewhere
+				for _, word := range strings.Fields(field.Value.(string)) {
+					whereClauses = append(whereClauses,
+						"instr(lower(title || ' ' || content), lower(?)) > 0")
+					args = append(args, word)
+				}
+				continue
+			}
+
 			whereClauses = append(whereClauses,
-				buildClause(field.Name, field.Value, "="))
+				fmt.Sprintf("instr(%s, ?) > 0", field.Name))
+			args = append(args, field.Value)
 		}
 	}
 
+	// dates are stored with their timezone, compare them in utc.  ranges
+	// may be open on either end.
+	rangeColumn := dateColumn(q.RangeBy)
 	if q.RangeStart != nil {
 		whereClauses = append(whereClauses,
-			buildClause("created", q.RangeStart, "<"))
+			rangeColumn+" >= julianday(?)")
+		args = append(args, *q.RangeStart)
 	}
 	if q.RangeEnd != nil {
 		whereClauses = append(whereClauses,
-			buildClause("created", q.RangeStart, "<"))
-	}
-	sqlQuery.Where = strings.Join(whereClauses, "\nAND ")
-	if sqlQuery.Where == "" {
-		sqlQuery.Where = "1=1"
-	}
-
-	sqlQuery.Order = "ASC"
-	if q.Reverse {
-		sqlQuery.Order = "DESC"
+			rangeColumn+" <= julianday(?)")
+		args = append(args, *q.RangeEnd)
 	}
 
-	sqlQuery.SortBy = "created"
-	if q.SortBy != "" {
-		sqlQuery.SortBy = q.SortBy
+	where := strings.Join(whereClauses, "\nAND ")
+	if where == "" {
+		where = "1=1"
 	}
+	return where, args
+}
 
-	tmpl, err := template.New("sqlQuery").Parse(sqlTemplate)
-	if err != nil {
-		return "", err
-	}
+func (s *Store) Find(q query.Query) ([]post.Post, error) {
+	return s.FindContext(context.Background(), q)
+}
 
-	var query bytes.Buffer
-	err = tmpl.Execute(&query, sqlQuery)
+func (s *Store) FindContext(ctx context.Context, q query.Query) ([]post.Post, error) {
+	var posts []post.Post
+	err := s.Each(ctx, q, func(p post.Post) error {
+		posts = append(posts, p)
+		return nil
+	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	return query.String(), nil
+	return posts, nil
 }
 
-func (s *Store) Find(q query.Query) ([]post.Post, error) {
-	var posts []post.Post
-	var rows *sql.Rows
+// posts are read this many at a time
+const pageSize = 100
+
+// reads the posts a page at a time, so that no rows are open while `f`
+// runs.  `f` may take long (e.g. writing to a slow client), open rows
+// would keep writers waiting meanwhile.
+func (s *Store) Each(ctx context.Context, q query.Query, f func(p post.Post) error) error {
+	// the first page skips `q.Start` posts, the others start after the
+	// previous one
+	offset := 0
+	if q.Start > 0 {
+		offset = q.Start
+	}
+	// all of them if negative
+	remaining := q.Count
+
+	var after *pageKey
+	for remaining != 0 {
+		limit := pageSize
+		if remaining > 0 && remaining < limit {
+			limit = remaining
+		}
 
-	query, err := buildSqlQuery(q)
-	if err != nil {
-		return nil, err
+		posts, last, err := s.page(ctx, q, after, limit, offset)
+		if err != nil {
+			return err
+		}
+		for _, p := range posts {
+			err = f(p)
+			if err != nil {
+				return err
+			}
+		}
+		if len(posts) < limit {
+			return nil
+		}
+		if remaining > 0 {
+			remaining -= len(posts)
+		}
+		after = last
+		offset = 0
 	}
+	return nil
+}
 
-	stmt, err := s.db.Prepare(query)
+// `limit` posts of `q` after `after`, and the key of the last one
+func (s *Store) page(ctx context.Context, q query.Query, after *pageKey, limit, offset int) ([]post.Post, *pageKey, error) {
+	query, args, err := buildSqlQuery(q, after, limit, offset)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	rows, err = stmt.Query()
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
 	defer rows.Close()
 
+	posts := make([]post.Post, 0, limit)
+	last := &pageKey{}
 	for rows.Next() {
-		var title, content, id string
-		var created time.Time
-		err = rows.Scan(&id, &created, &title, &content)
+		p, err := scanPost(keyScanner{rows, last})
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		posts = append(posts, post.Post{id, title, content, created})
+		posts = append(posts, *p)
 	}
+	return posts, last, rows.Err()
+}
 
-	return posts, nil
+// scans the key of the page after the columns of the post
+type keyScanner struct {
+	rows *sql.Rows
+	key  *pageKey
+}
+
+func (k keyScanner) Scan(dest ...interface{}) error {
+	return k.rows.Scan(append(dest, &k.key.sortBy, &k.key.id)...)
 }
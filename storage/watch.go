@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"../post"
+)
+
+const (
+	Created = "created"
+	Updated = "updated"
+	Deleted = "deleted"
+)
+
+// a change to a post
+type Event struct {
+	// increases by one with each change to a store, and continues where
+	// it left off after a restart.  remember the last one seen to resume
+	// watching with `WatchFrom`.
+	Seq  uint64 `json:"seq"`
+	Type string `json:"type"`
+	Id   string `json:"id"`
+	// the post after the change, nil if it was deleted
+	Post *post.Post `json:"post,omitempty"`
+	Time time.Time  `json:"time"`
+}
+
+// implemented by stores that tell about changes to their posts
+type Watcher interface {
+	// events for changes from now on.  the channel is closed when `ctx`
+	// is done, when the store is closed or when the events are not read
+	// fast enough (watch again with `WatchFrom` then).
+	Watch(ctx context.Context) <-chan Event
+	// like `Watch`, but starting with the events after `seq`.  returns
+	// `ErrEventsGone` if they are not kept anymore, or if `seq` is from
+	// events that were lost.
+	WatchFrom(ctx context.Context, seq uint64) (<-chan Event, error)
+}
+
+// implemented by stores that wrap another one, they can be watched only if
+// the wrapped one can.  check with `CanWatch`.
+type Watchable interface {
+	Watchable() bool
+}
+
+// the watcher of `s`, if it tells about changes
+func CanWatch(s Store) (Watcher, bool) {
+	watcher, ok := s.(Watcher)
+	if !ok {
+		return nil, false
+	}
+	if w, ok := s.(Watchable); ok && !w.Watchable() {
+		return nil, false
+	}
+	return watcher, true
+}
+
+var ErrEventsGone = errors.New("the events to resume from are not kept anymore")
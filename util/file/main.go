@@ -0,0 +1,53 @@
+// helpers for writing files safely
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// replaces the file at `path` with `data`, so that it either has the old
+// or the new contents, even if gol crashes while writing
+func WriteAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	// no-op after the rename
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	return SyncDir(dir)
+}
+
+// makes sure renames and deletions in `dir` are on disk
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
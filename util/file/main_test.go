@@ -0,0 +1,33 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	tu "../testing"
+)
+
+func TestWriteAtomic(t *testing.T) {
+	tmpPath, err := ioutil.TempDir("", "gol_file_test")
+	tu.RequireNil(t, err)
+	defer os.RemoveAll(tmpPath)
+
+	p := path.Join(tmpPath, "test.txt")
+	tu.RequireNil(t, WriteAtomic(p, []byte("one"), 0644))
+	tu.RequireNil(t, WriteAtomic(p, []byte("two"), 0600))
+
+	data, err := ioutil.ReadFile(p)
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, string(data), "two")
+
+	info, _ := os.Stat(p)
+	tu.ExpectEqual(t, info.Mode().Perm(), os.FileMode(0600))
+
+	files, _ := filepath.Glob(path.Join(tmpPath, "*"))
+	tu.ExpectEqual(t, len(files), 1)
+
+	tu.ExpectNotNil(t, WriteAtomic(path.Join(tmpPath, "missing", "test.txt"), []byte("three"), 0644))
+}
@@ -7,25 +7,25 @@ import (
 )
 
 func ExpectEqual(t *testing.T, actual, expected interface{}) {
-	if actual != expected {
+	if !isEqual(actual, expected) {
 		t.Errorf("%#v != %#v", actual, expected)
 	}
 }
 
 func RequireEqual(t *testing.T, actual, expected interface{}) {
-	if actual != expected {
+	if !isEqual(actual, expected) {
 		t.Fatalf("%#v != %#v", actual, expected)
 	}
 }
 
 func RequireNotEqual(t *testing.T, actual, expected interface{}) {
-	if actual == expected {
+	if isEqual(actual, expected) {
 		t.Fatalf("%#v == %#v", actual, expected)
 	}
 }
 
 func ExpectNotEqual(t *testing.T, actual, expected interface{}) {
-	if actual == expected {
+	if isEqual(actual, expected) {
 		t.Errorf("%#v == %#v", actual, expected)
 	}
 }
@@ -55,5 +55,20 @@ func RequireNotNil(t *testing.T, value interface{}) {
 }
 
 func isNil(value interface{}) bool {
-	return value == nil || reflect.ValueOf(value).IsNil()
+	if value == nil {
+		return true
+	}
+
+	// e.g. errors that are structs are never nil
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
+// like ==, but also works for values that contain slices or maps
+func isEqual(actual, expected interface{}) bool {
+	return reflect.DeepEqual(actual, expected)
 }
@@ -0,0 +1,442 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"../storage"
+	"../util/file"
+)
+
+// an event waiting to be sent to a subscription
+type Delivery struct {
+	Id          string        `json:"id"`
+	Url         string        `json:"url"`
+	Event       storage.Event `json:"event"`
+	Attempts    int           `json:"attempts"`
+	NextAttempt time.Time     `json:"next_attempt"`
+}
+
+// an entry of the delivery log
+type Attempt struct {
+	Delivery string        `json:"delivery"`
+	Url      string        `json:"url"`
+	Event    string        `json:"event"`
+	PostId   string        `json:"post_id"`
+	Seq      uint64        `json:"seq"`
+	Attempt  int           `json:"attempt"`
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	// the http status, 0 if there was no response
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// whether the delivery is done, because it succeeded or it was
+	// tried too often
+	Done bool `json:"done"`
+}
+
+// what is kept on disk, so that no deliveries are lost on restarts
+type state struct {
+	// the last event that has been queued
+	Seq        uint64     `json:"seq"`
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+// sends events to subscriptions.  pending deliveries are kept in a queue
+// file and every attempt is appended to a log file, one json object per
+// line.
+type Dispatcher struct {
+	// deliveries are retried after `Backoff`, twice as long after the
+	// next failure and so on, up to `MaxBackoff`
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// deliveries are given up after this many failed attempts
+	MaxAttempts int
+	Client      *http.Client
+
+	mu            sync.Mutex
+	subscriptions map[string]Subscription
+	queuePath     string
+	logPath       string
+	state         state
+	// signals that there are new deliveries, or that a subscription is
+	// not busy anymore
+	wake chan bool
+	// the subscriptions that are being delivered to, each by its own
+	// goroutine, so that a slow one doesn't hold up the others
+	busy      map[string]bool
+	delivered sync.WaitGroup
+}
+
+func New(subscriptions []Subscription, queuePath, logPath string) (*Dispatcher, error) {
+	d := &Dispatcher{
+		Backoff:       time.Second,
+		MaxBackoff:    time.Hour,
+		MaxAttempts:   10,
+		Client:        &http.Client{Timeout: 10 * time.Second},
+		subscriptions: make(map[string]Subscription, len(subscriptions)),
+		queuePath:     queuePath,
+		logPath:       logPath,
+		wake:          make(chan bool, 1),
+		busy:          map[string]bool{},
+	}
+
+	for _, s := range subscriptions {
+		err := s.validate()
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := d.subscriptions[s.Url]; exists {
+			return nil, errors.New(fmt.Sprintf("duplicate webhook: %s", s.Url))
+		}
+		d.subscriptions[s.Url] = s
+	}
+
+	data, err := ioutil.ReadFile(queuePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &d.state)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid webhook queue %s: %s", queuePath, err))
+		}
+	}
+
+	return d, nil
+}
+
+// queues the events of `watcher` and delivers them until `ctx` is done.
+// events that happened while gol was not running are delivered as well,
+// as far as `watcher` still has them.
+func (d *Dispatcher) Run(ctx context.Context, watcher storage.Watcher) error {
+	ctx, cancel := context.WithCancel(ctx)
+	delivering := make(chan bool)
+	go func() {
+		d.deliverAll(ctx)
+		close(delivering)
+	}()
+	defer func() {
+		cancel()
+		<-delivering
+	}()
+
+	for {
+		events, err := d.watch(ctx, watcher)
+		if err != nil {
+			return err
+		}
+		for e := range events {
+			err = d.enqueue(e)
+			if err != nil {
+				log.Println("Error: webhooks:", err)
+			}
+		}
+
+		// dropped for being too slow, or the storage was closed
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// continues after the last event that has been queued
+func (d *Dispatcher) watch(ctx context.Context, watcher storage.Watcher) (<-chan storage.Event, error) {
+	d.mu.Lock()
+	seq := d.state.Seq
+	d.mu.Unlock()
+
+	if seq == 0 {
+		return watcher.Watch(ctx), nil
+	}
+
+	events, err := watcher.WatchFrom(ctx, seq)
+	if err == storage.ErrEventsGone {
+		log.Printf("webhooks: some events after %d are lost", seq)
+		return watcher.Watch(ctx), nil
+	}
+	return events, err
+}
+
+// adds deliveries of `e` for all subscriptions that want it
+func (d *Dispatcher) enqueue(e storage.Event) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, s := range d.subscriptions {
+		if !s.Matches(e) {
+			continue
+		}
+		d.state.Deliveries = append(d.state.Deliveries, Delivery{
+			Id:          newId(),
+			Url:         s.Url,
+			Event:       e,
+			NextAttempt: time.Now(),
+		})
+	}
+	d.state.Seq = e.Seq
+
+	d.notify()
+	return d.save()
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- true:
+	default:
+	}
+}
+
+// must be called with `d.mu` locked
+func (d *Dispatcher) save() error {
+	data, err := json.Marshal(d.state)
+	if err != nil {
+		return err
+	}
+	return file.WriteAtomic(d.queuePath, data, 0600)
+}
+
+func (d *Dispatcher) deliverAll(ctx context.Context) {
+	for {
+		next := d.deliverDue(ctx)
+
+		var timer <-chan time.Time
+		if !next.IsZero() {
+			timer = time.After(time.Until(next))
+		}
+		select {
+		case <-d.wake:
+		case <-timer:
+		case <-ctx.Done():
+			d.delivered.Wait()
+			return
+		}
+	}
+}
+
+// starts delivering what is due to each subscription that isn't busy,
+// in order, returns when the next delivery is due.  a delivery that is
+// waiting for a retry holds back the later ones to the same url, so that
+// subscriptions never see the events out of order.
+func (d *Dispatcher) deliverDue(ctx context.Context) time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	due := map[string][]Delivery{}
+	waiting := map[string]bool{}
+	var next time.Time
+	for _, delivery := range d.state.Deliveries {
+		if d.busy[delivery.Url] || waiting[delivery.Url] {
+			continue
+		}
+		if !delivery.NextAttempt.After(time.Now()) {
+			due[delivery.Url] = append(due[delivery.Url], delivery)
+			continue
+		}
+		waiting[delivery.Url] = true
+		if next.IsZero() || delivery.NextAttempt.Before(next) {
+			next = delivery.NextAttempt
+		}
+	}
+
+	for url, deliveries := range due {
+		d.busy[url] = true
+		d.delivered.Add(1)
+		go func(url string, deliveries []Delivery) {
+			defer d.delivered.Done()
+			// the rest waits until the failed one is retried
+			for _, delivery := range deliveries {
+				if ctx.Err() != nil || !d.deliver(delivery) {
+					break
+				}
+			}
+
+			d.mu.Lock()
+			delete(d.busy, url)
+			d.mu.Unlock()
+			d.notify()
+		}(url, deliveries)
+	}
+	return next
+}
+
+// whether the delivery is done, i.e. succeeded or was given up on
+func (d *Dispatcher) deliver(delivery Delivery) bool {
+	subscription, ok := d.subscriptions[delivery.Url]
+	delivery.Attempts += 1
+	attempt := Attempt{
+		Delivery: delivery.Id,
+		Url:      delivery.Url,
+		Event:    delivery.Event.Type,
+		PostId:   delivery.Event.Id,
+		Seq:      delivery.Event.Seq,
+		Attempt:  delivery.Attempts,
+		Time:     time.Now(),
+	}
+
+	var err error
+	if !ok {
+		// removed from the configuration since
+		err = errors.New("no such webhook anymore")
+		attempt.Done = true
+	} else {
+		attempt.Status, err = d.send(subscription, delivery)
+		attempt.Done = err == nil || delivery.Attempts >= d.MaxAttempts
+	}
+	attempt.Duration = time.Since(attempt.Time)
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := range d.state.Deliveries {
+		if d.state.Deliveries[i].Id != delivery.Id {
+			continue
+		}
+		if attempt.Done {
+			d.state.Deliveries = append(d.state.Deliveries[:i], d.state.Deliveries[i+1:]...)
+		} else {
+			delivery.NextAttempt = time.Now().Add(d.backoff(delivery.Attempts))
+			d.state.Deliveries[i] = delivery
+		}
+		break
+	}
+
+	err = d.save()
+	if err != nil {
+		log.Println("Error: webhooks: could not save queue:", err)
+	}
+	err = d.logAttempt(attempt)
+	if err != nil {
+		log.Println("Error: webhooks: could not log delivery:", err)
+	}
+	return attempt.Done
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.Backoff
+	for i := 1; i < attempts && backoff < d.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.MaxBackoff {
+		backoff = d.MaxBackoff
+	}
+	return backoff
+}
+
+// posts the event as json.  the `X-Gol-Signature` header contains the
+// hmac of the body, see `Sign`.
+func (d *Dispatcher) send(s Subscription, delivery Delivery) (int, error) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest("POST", s.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gol-webhooks")
+	req.Header.Set("X-Gol-Event", delivery.Event.Type)
+	req.Header.Set("X-Gol-Delivery", delivery.Id)
+	if s.Secret != "" {
+		req.Header.Set("X-Gol-Signature", Sign(s.Secret, body))
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, errors.New(fmt.Sprintf("unexpected response: %s", resp.Status))
+	}
+	return resp.StatusCode, nil
+}
+
+// `sha256=<hex encoded hmac-sha256 of body>`, receivers compute it with
+// the secret they share with gol and compare
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// must be called with `d.mu` locked
+func (d *Dispatcher) logAttempt(attempt Attempt) error {
+	line, err := json.Marshal(attempt)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(d.logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// the deliveries that have not succeeded yet
+func (d *Dispatcher) Pending() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]Delivery{}, d.state.Deliveries...)
+}
+
+// reads the delivery log
+func ReadLog(path string) ([]Attempt, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := []Attempt{}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var attempt Attempt
+		err = json.Unmarshal(line, &attempt)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, nil
+}
+
+func newId() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Println(err)
+	}
+	return hex.EncodeToString(b)
+}
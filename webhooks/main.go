@@ -0,0 +1,119 @@
+// notifies other services about changes to posts
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"../post"
+	"../storage"
+	"../storage/memory"
+	"../storage/query"
+)
+
+// where to send which events.  for example:
+//
+//	{
+//	    "url": "https://chat.example.com/hooks/gol",
+//	    "secret": "s3cr3t",
+//	    "events": ["created", "updated"],
+//	    "tag": "release",
+//	    "query": "match=title:v"
+//	}
+type Subscription struct {
+	Url string `json:"url"`
+	// signs deliveries if set, see `Sign`
+	Secret string `json:"secret,omitempty"`
+	// `created`, `updated` and/or `deleted`, all if empty
+	Events []string `json:"events,omitempty"`
+	// only posts with this #tag
+	Tag string `json:"tag,omitempty"`
+	// only posts matching this query, given like the url parameters of
+	// `/posts` (e.g. `match=content:cats`)
+	Query string `json:"query,omitempty"`
+
+	query *query.Query
+}
+
+// reads subscriptions from a json file containing a list of them
+func Load(path string) ([]Subscription, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions []Subscription
+	err = json.Unmarshal(data, &subscriptions)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid webhooks in %s: %s", path, err))
+	}
+	return subscriptions, nil
+}
+
+func (s *Subscription) validate() error {
+	u, err := url.Parse(s.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return errors.New(fmt.Sprintf("invalid webhook url: %#v", s.Url))
+	}
+
+	for _, e := range s.Events {
+		if e != storage.Created && e != storage.Updated && e != storage.Deleted {
+			return errors.New(fmt.Sprintf("%s: unknown event %#v", s.Url, e))
+		}
+	}
+
+	s.Tag = strings.ToLower(strings.TrimPrefix(s.Tag, "#"))
+
+	if s.Query != "" {
+		params, err := url.ParseQuery(s.Query)
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s: invalid query: %s", s.Url, err))
+		}
+		s.query, err = query.FromParams(params)
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s: invalid query: %s", s.Url, err))
+		}
+	}
+	return nil
+}
+
+// whether the subscription wants to hear about `e`.  deleted posts are
+// gone and can't be matched against tags or queries, so their events are
+// only sent to subscriptions without these filters.
+func (s *Subscription) Matches(e storage.Event) bool {
+	if len(s.Events) > 0 && !contains(s.Events, e.Type) {
+		return false
+	}
+
+	if s.Tag == "" && s.query == nil {
+		return true
+	}
+	if e.Post == nil {
+		return false
+	}
+
+	if s.Tag != "" && !contains(e.Post.Tags(), s.Tag) {
+		return false
+	}
+	if s.query != nil {
+		// the memory backend defines what queries mean
+		posts, err := memory.FromPosts([]post.Post{*e.Post}).Find(*s.query)
+		if err != nil || len(posts) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
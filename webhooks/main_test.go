@@ -0,0 +1,367 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"../post"
+	"../storage"
+	"../storage/memory"
+	tu "../util/testing"
+)
+
+// records the events it receives, failing the first `failures` requests
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	failures int
+	events   []storage.Event
+	received chan storage.Event
+}
+
+func newReceiver(secret string, failures int) (*receiver, *httptest.Server) {
+	r := &receiver{secret: secret, failures: failures, received: make(chan storage.Event, 100)}
+	return r, httptest.NewServer(r)
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failures > 0 {
+		r.failures -= 1
+		http.Error(w, "not now", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(req.Body)
+	if r.secret != "" && req.Header.Get("X-Gol-Signature") != Sign(r.secret, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var e storage.Event
+	err := json.Unmarshal(body, &e)
+	if err != nil || req.Header.Get("X-Gol-Event") != e.Type || req.Header.Get("X-Gol-Delivery") == "" {
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+	r.events = append(r.events, e)
+	r.received <- e
+}
+
+func (r *receiver) wait(t *testing.T, n int) []storage.Event {
+	events := []storage.Event{}
+	for len(events) < n {
+		select {
+		case e := <-r.received:
+			events = append(events, e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d events, got %d", n, len(events))
+		}
+	}
+	return events
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "gol_webhooks_test")
+	tu.RequireNil(t, err)
+	return dir, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func newDispatcher(t *testing.T, dir string, subscriptions ...Subscription) *Dispatcher {
+	d, err := New(subscriptions, path.Join(dir, "queue"), path.Join(dir, "log"))
+	tu.RequireNil(t, err)
+	d.Backoff = 10 * time.Millisecond
+	d.MaxBackoff = 50 * time.Millisecond
+	return d
+}
+
+func run(d *Dispatcher, watcher storage.Watcher) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		d.Run(ctx, watcher)
+		close(done)
+	}()
+	// wait until it watches
+	time.Sleep(50 * time.Millisecond)
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func waitFor(condition func() bool) {
+	for i := 0; i < 500 && !condition(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func noPending(d *Dispatcher) func() bool {
+	return func() bool {
+		return len(d.Pending()) == 0
+	}
+}
+
+func TestMatches(t *testing.T) {
+	p := &post.Post{Id: "1", Title: "v1.0", Content: "out now #Release"}
+	created := storage.Event{Seq: 1, Type: storage.Created, Id: "1", Post: p}
+	deleted := storage.Event{Seq: 2, Type: storage.Deleted, Id: "1"}
+
+	s := Subscription{Url: "http://localhost/hook"}
+	tu.RequireNil(t, s.validate())
+	tu.ExpectEqual(t, s.Matches(created), true)
+	tu.ExpectEqual(t, s.Matches(deleted), true)
+
+	s = Subscription{Url: "http://localhost/hook", Events: []string{"deleted"}}
+	tu.RequireNil(t, s.validate())
+	tu.ExpectEqual(t, s.Matches(created), false)
+	tu.ExpectEqual(t, s.Matches(deleted), true)
+
+	s = Subscription{Url: "http://localhost/hook", Tag: "#release"}
+	tu.RequireNil(t, s.validate())
+	tu.ExpectEqual(t, s.Matches(created), true)
+	tu.ExpectEqual(t, s.Matches(deleted), false)
+
+	s = Subscription{Url: "http://localhost/hook", Tag: "cats"}
+	tu.RequireNil(t, s.validate())
+	tu.ExpectEqual(t, s.Matches(created), false)
+
+	s = Subscription{Url: "http://localhost/hook", Query: "match=title:v1"}
+	tu.RequireNil(t, s.validate())
+	tu.ExpectEqual(t, s.Matches(created), true)
+	s = Subscription{Url: "http://localhost/hook", Query: "match=title:v2"}
+	tu.RequireNil(t, s.validate())
+	tu.ExpectEqual(t, s.Matches(created), false)
+
+	for _, invalid := range []Subscription{
+		{Url: "ftp://localhost/hook"},
+		{Url: "http://localhost/hook", Events: []string{"published"}},
+		{Url: "http://localhost/hook", Query: "sort=content"},
+	} {
+		tu.ExpectNotNil(t, invalid.validate())
+	}
+}
+
+func TestLoadExample(t *testing.T) {
+	dir, tearDown := tempDir(t)
+	defer tearDown()
+
+	subscriptions, err := Load("../webhooksExample.json")
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(subscriptions), 2)
+	tu.ExpectEqual(t, subscriptions[1].Tag, "release")
+
+	_, err = New(subscriptions, path.Join(dir, "queue"), path.Join(dir, "log"))
+	tu.ExpectNil(t, err)
+	_, err = New(append(subscriptions, subscriptions[0]), path.Join(dir, "queue"), path.Join(dir, "log"))
+	tu.ExpectNotNil(t, err)
+}
+
+func TestDeliver(t *testing.T) {
+	dir, tearDown := tempDir(t)
+	defer tearDown()
+
+	r, server := newReceiver("s3cr3t", 0)
+	defer server.Close()
+	tagged, taggedServer := newReceiver("", 0)
+	defer taggedServer.Close()
+
+	store := &memory.Store{}
+	d := newDispatcher(t, dir,
+		Subscription{Url: server.URL, Secret: "s3cr3t"},
+		Subscription{Url: taggedServer.URL, Tag: "cats"})
+	stop := run(d, store)
+	defer stop()
+
+	p := post.Post{Id: "1", Title: "hello", Content: "world"}
+	tu.RequireNil(t, store.Create(p))
+	p.Content = "#cats"
+	tu.RequireNil(t, store.Update(p))
+	tu.RequireNil(t, store.Delete(p.Id))
+
+	events := r.wait(t, 3)
+	types := map[string]bool{}
+	for _, e := range events {
+		types[e.Type] = true
+		tu.ExpectEqual(t, e.Id, "1")
+	}
+	tu.ExpectEqual(t, types, map[string]bool{storage.Created: true, storage.Updated: true, storage.Deleted: true})
+
+	events = tagged.wait(t, 1)
+	tu.ExpectEqual(t, events[0].Type, storage.Updated)
+	tu.ExpectEqual(t, events[0].Post.Content, "#cats")
+
+	waitFor(noPending(d))
+	stop()
+	tu.ExpectEqual(t, len(d.Pending()), 0)
+	attempts, err := ReadLog(path.Join(dir, "log"))
+	tu.RequireNil(t, err)
+	tu.ExpectEqual(t, len(attempts), 4)
+	for _, a := range attempts {
+		tu.ExpectEqual(t, a.Status, http.StatusOK)
+		tu.ExpectEqual(t, a.Done, true)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	dir, tearDown := tempDir(t)
+	defer tearDown()
+
+	r, server := newReceiver("", 2)
+	defer server.Close()
+
+	store := &memory.Store{}
+	d := newDispatcher(t, dir, Subscription{Url: server.URL})
+	stop := run(d, store)
+	defer stop()
+
+	tu.RequireNil(t, store.Create(post.Post{Id: "1", Title: "hello"}))
+	r.wait(t, 1)
+	waitFor(noPending(d))
+	stop()
+
+	attempts, err := ReadLog(path.Join(dir, "log"))
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(attempts), 3)
+	for i, a := range attempts {
+		tu.ExpectEqual(t, a.Attempt, i+1)
+		tu.ExpectEqual(t, a.Delivery, attempts[0].Delivery)
+	}
+	tu.ExpectEqual(t, attempts[0].Status, http.StatusServiceUnavailable)
+	tu.ExpectEqual(t, attempts[0].Done, false)
+	tu.ExpectEqual(t, attempts[2].Status, http.StatusOK)
+	tu.ExpectEqual(t, attempts[2].Done, true)
+}
+
+func TestRetryKeepsOrder(t *testing.T) {
+	dir, tearDown := tempDir(t)
+	defer tearDown()
+
+	r, server := newReceiver("", 1)
+	defer server.Close()
+
+	store := &memory.Store{}
+	d := newDispatcher(t, dir, Subscription{Url: server.URL})
+	d.Backoff = 200 * time.Millisecond
+	d.MaxBackoff = d.Backoff
+	stop := run(d, store)
+	defer stop()
+
+	p := post.Post{Id: "1", Title: "hello"}
+	tu.RequireNil(t, store.Create(p))
+	waitFor(func() bool {
+		pending := d.Pending()
+		return len(pending) > 0 && pending[0].Attempts > 0
+	})
+	// queued while the creation waits for its retry
+	p.Content = "world"
+	tu.RequireNil(t, store.Update(p))
+
+	events := r.wait(t, 2)
+	tu.ExpectEqual(t, events[0].Type, storage.Created)
+	tu.ExpectEqual(t, events[1].Type, storage.Updated)
+}
+
+func TestGivesUp(t *testing.T) {
+	dir, tearDown := tempDir(t)
+	defer tearDown()
+
+	_, server := newReceiver("", 100)
+	defer server.Close()
+
+	store := &memory.Store{}
+	d := newDispatcher(t, dir, Subscription{Url: server.URL})
+	d.MaxAttempts = 3
+	stop := run(d, store)
+	defer stop()
+
+	tu.RequireNil(t, store.Create(post.Post{Id: "1", Title: "hello"}))
+	waitFor(func() bool { return len(d.Pending()) > 0 })
+	waitFor(noPending(d))
+	stop()
+
+	tu.ExpectEqual(t, len(d.Pending()), 0)
+	attempts, err := ReadLog(path.Join(dir, "log"))
+	tu.RequireNil(t, err)
+	tu.RequireEqual(t, len(attempts), 3)
+	tu.ExpectEqual(t, attempts[2].Done, true)
+	tu.ExpectNotNil(t, attempts[2].Error)
+}
+
+func TestQueueSurvivesRestarts(t *testing.T) {
+	dir, tearDown := tempDir(t)
+	defer tearDown()
+
+	r, server := newReceiver("", 1000)
+	defer server.Close()
+
+	store := &memory.Store{}
+	d := newDispatcher(t, dir, Subscription{Url: server.URL})
+	d.Backoff = time.Hour
+	stop := run(d, store)
+	tu.RequireNil(t, store.Create(post.Post{Id: "1", Title: "hello"}))
+	waitFor(func() bool { return len(d.Pending()) > 0 })
+	stop()
+	tu.ExpectEqual(t, len(d.Pending()), 1)
+
+	// created while not running
+	tu.RequireNil(t, store.Create(post.Post{Id: "2", Title: "again"}))
+
+	// works again
+	r.mu.Lock()
+	r.failures = 0
+	r.mu.Unlock()
+
+	d = newDispatcher(t, dir, Subscription{Url: server.URL})
+	tu.ExpectEqual(t, len(d.Pending()), 1)
+	// retry now
+	d.state.Deliveries[0].NextAttempt = time.Now()
+	stop = run(d, store)
+	defer stop()
+
+	events := r.wait(t, 2)
+	ids := map[string]bool{}
+	for _, e := range events {
+		ids[e.Id] = true
+	}
+	tu.ExpectEqual(t, ids, map[string]bool{"1": true, "2": true})
+}
+
+func TestSlowSubscription(t *testing.T) {
+	dir, tearDown := tempDir(t)
+	defer tearDown()
+
+	release := make(chan bool)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	r, server := newReceiver("", 0)
+	defer server.Close()
+
+	store := &memory.Store{}
+	d := newDispatcher(t, dir, Subscription{Url: slow.URL}, Subscription{Url: server.URL})
+	stop := run(d, store)
+	defer stop()
+	defer close(release)
+
+	// the other subscription gets its events while the slow one still
+	// answers the first
+	tu.RequireNil(t, store.Create(post.Post{Id: "1", Title: "hello"}))
+	tu.RequireNil(t, store.Create(post.Post{Id: "2", Title: "again"}))
+	events := r.wait(t, 2)
+	tu.ExpectEqual(t, events[0].Id, "1")
+	tu.ExpectEqual(t, events[1].Id, "2")
+}